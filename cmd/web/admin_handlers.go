@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	netmail "net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	data "github.com/dubass83/go-concurrency-project/data/sqlc"
+	"github.com/rs/zerolog/log"
+)
+
+type formatedMailEvent struct {
+	Status    string    `json:"status"`
+	Detail    string    `json:"detail"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type formatedTrackingEvent struct {
+	Kind      string    `json:"kind"`
+	URL       string    `json:"url"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type formatedOutbox struct {
+	ID        int64     `json:"id"`
+	MessageID string    `json:"message_id"`
+	To        string    `json:"to"`
+	Subject   string    `json:"subject"`
+	Template  string    `json:"template"`
+	Status    string    `json:"status"`
+	Attempts  int32     `json:"attempts"`
+	LastError string    `json:"last_error"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (app *Server) DeadLetters(w http.ResponseWriter, r *http.Request) {
+	arg := data.GetDeadMailOutboxParams{
+		Limit:  50,
+		Offset: 0,
+	}
+	rows, err := app.Store.GetDeadMailOutbox(context.Background(), arg)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get dead letters")
+		app.Session.Put(r.Context(), "error", "Unable to load dead letters!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	dataMap := make(map[string]any)
+	dataMap["letters"] = outboxFormatted(rows)
+
+	app.render(w, r, "dead-letters.page.gohtml", &TemplateData{
+		DataMap: dataMap,
+	})
+}
+
+func (app *Server) RedriveDeadLetter(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse redrive form")
+		http.Redirect(w, r, "/admin/mail/dead-letters", http.StatusSeeOther)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.Form.Get("id"), 10, 64)
+	if err != nil {
+		app.Session.Put(r.Context(), "error", "Invalid message id!")
+		http.Redirect(w, r, "/admin/mail/dead-letters", http.StatusSeeOther)
+		return
+	}
+
+	_, err = app.Store.RedriveMailOutbox(context.Background(), id)
+	if err != nil {
+		log.Error().Err(err).Int64("outbox_id", id).Msg("failed to redrive dead letter")
+		app.Session.Put(r.Context(), "error", "Unable to re-drive the message!")
+		http.Redirect(w, r, "/admin/mail/dead-letters", http.StatusSeeOther)
+		return
+	}
+	app.recordMailEvent(id, data.MailEventQueued, "re-driven by admin")
+	app.wakeOutbox()
+
+	app.Session.Put(r.Context(), "flash", "Message is queued again.")
+	http.Redirect(w, r, "/admin/mail/dead-letters", http.StatusSeeOther)
+}
+
+// MailMessages search messages by the recipient address
+func (app *Server) MailMessages(w http.ResponseWriter, r *http.Request) {
+	recipient := strings.TrimSpace(r.URL.Query().Get("recipient"))
+
+	arg := data.SearchMailOutboxParams{
+		Recipient: escapeLike(recipient),
+		Limit:     50,
+		Offset:    0,
+	}
+	rows, err := app.Store.SearchMailOutbox(context.Background(), arg)
+	if err != nil {
+		log.Error().Err(err).Str("recipient", recipient).Msg("failed to search mail outbox")
+		app.Session.Put(r.Context(), "error", "Unable to search messages!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	dataMap := make(map[string]any)
+	dataMap["messages"] = outboxFormatted(rows)
+
+	app.render(w, r, "mail-messages.page.gohtml", &TemplateData{
+		StringMap: map[string]string{
+			"recipient": recipient,
+		},
+		DataMap: dataMap,
+	})
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// escapeLike escape wildcards of ILIKE, so the recipient is matched literally
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// MailMessage show the message with its delivery history
+func (app *Server) MailMessage(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		app.Session.Put(r.Context(), "error", "Invalid message id!")
+		http.Redirect(w, r, "/admin/mail/messages", http.StatusSeeOther)
+		return
+	}
+
+	row, err := app.Store.GetOneMailOutbox(context.Background(), id)
+	if err != nil {
+		log.Error().Err(err).Int64("outbox_id", id).Msg("failed to get outbox message")
+		app.Session.Put(r.Context(), "error", "Message not found!")
+		http.Redirect(w, r, "/admin/mail/messages", http.StatusSeeOther)
+		return
+	}
+
+	events, err := app.Store.GetMailEvents(context.Background(), id)
+	if err != nil {
+		log.Error().Err(err).Int64("outbox_id", id).Msg("failed to get mail events")
+		app.Session.Put(r.Context(), "error", "Unable to load the delivery history!")
+		http.Redirect(w, r, "/admin/mail/messages", http.StatusSeeOther)
+		return
+	}
+
+	tracking, err := app.Store.GetMailTrackingEvents(context.Background(), row.MessageID)
+	if err != nil {
+		log.Error().Err(err).Int64("outbox_id", id).Msg("failed to get tracking events")
+		app.Session.Put(r.Context(), "error", "Unable to load opens and clicks!")
+		http.Redirect(w, r, "/admin/mail/messages", http.StatusSeeOther)
+		return
+	}
+
+	dataMap := make(map[string]any)
+	dataMap["message"] = outboxFormatted([]data.MailOutbox{row})[0]
+	dataMap["events"] = mailEventsFormatted(events)
+	dataMap["tracking"] = trackingEventsFormatted(tracking)
+
+	app.render(w, r, "mail-message.page.gohtml", &TemplateData{
+		DataMap: dataMap,
+	})
+}
+
+func mailEventsFormatted(rows []data.MailEvent) []formatedMailEvent {
+	result := []formatedMailEvent{}
+	for _, row := range rows {
+		result = append(result, formatedMailEvent{
+			Status:    row.Status,
+			Detail:    row.Detail.String,
+			CreatedAt: row.CreatedAt.Time,
+		})
+	}
+	return result
+}
+
+func trackingEventsFormatted(rows []data.MailTrackingEvent) []formatedTrackingEvent {
+	result := []formatedTrackingEvent{}
+	for _, row := range rows {
+		result = append(result, formatedTrackingEvent{
+			Kind:      row.Kind,
+			URL:       row.Url.String,
+			CreatedAt: row.CreatedAt.Time,
+		})
+	}
+	return result
+}
+
+func outboxFormatted(rows []data.MailOutbox) []formatedOutbox {
+	result := []formatedOutbox{}
+	for _, row := range rows {
+		formated := formatedOutbox{
+			ID:        row.ID,
+			MessageID: row.MessageID,
+			Status:    row.Status,
+			Attempts:  row.Attempts,
+			LastError: row.LastError.String,
+			CreatedAt: row.CreatedAt.Time,
+			UpdatedAt: row.UpdatedAt.Time,
+		}
+		// payload could be broken, that is why the message is dead
+		if msg, err := messageFromOutbox(row); err == nil {
+			formated.To = strings.Join(msg.To, ", ")
+			formated.Subject = msg.Subject
+			formated.Template = msg.Template
+		}
+		result = append(result, formated)
+	}
+	return result
+}
+
+// MailTemplatePreview show the template rendered with its sample data
+func (app *Server) MailTemplatePreview(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	names := app.Mail.Templates.Names()
+	if name == "" && len(names) > 0 {
+		name = names[0]
+	}
+
+	app.renderMailTemplate(w, r, name, sampleMailData(name), "")
+}
+
+// PostMailTemplatePreview render the template with the edited sample data
+func (app *Server) PostMailTemplatePreview(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse template preview form")
+		http.Redirect(w, r, "/admin/mail/templates", http.StatusSeeOther)
+		return
+	}
+
+	app.renderMailTemplate(w, r, r.Form.Get("name"), r.Form.Get("data"), r.Form.Get("to"))
+}
+
+// SendTestMailTemplate enqueue the template rendered with the sample data to the chosen address,
+// the listed variant is sent as the template with its locale like in the preview
+func (app *Server) SendTestMailTemplate(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse template test send form")
+		http.Redirect(w, r, "/admin/mail/templates", http.StatusSeeOther)
+		return
+	}
+
+	name := r.Form.Get("name")
+	rawData := r.Form.Get("data")
+	to := strings.TrimSpace(r.Form.Get("to"))
+
+	if !app.Mail.Templates.Has(name) {
+		app.Session.Put(r.Context(), "error", "Unknown email template!")
+		http.Redirect(w, r, "/admin/mail/templates", http.StatusSeeOther)
+		return
+	}
+	sample, err := parseSampleMailData(rawData)
+	if err != nil {
+		app.Session.Put(r.Context(), "error", err.Error())
+		app.renderMailTemplate(w, r, name, rawData, to)
+		return
+	}
+	address, err := netmail.ParseAddress(to)
+	if err != nil {
+		app.Session.Put(r.Context(), "error", "Invalid email address!")
+		app.renderMailTemplate(w, r, name, rawData, to)
+		return
+	}
+
+	base, locale, _ := strings.Cut(name, ".")
+	msg := Message{
+		To:       []string{address.Address},
+		Subject:  fmt.Sprintf("Test: %s", name),
+		Template: base,
+		Locale:   locale,
+		Data:     sample,
+	}
+	if err := app.enqueueMail(r.Context(), msg); err != nil {
+		log.Error().Err(err).Str("template", name).Msg("failed to enqueue test email")
+		app.Session.Put(r.Context(), "error", "Unable to send the test email!")
+		app.renderMailTemplate(w, r, name, rawData, to)
+		return
+	}
+
+	app.Session.Put(r.Context(), "flash", fmt.Sprintf("Test email is queued for %s.", address.Address))
+	http.Redirect(w, r, "/admin/mail/templates?name="+url.QueryEscape(name), http.StatusSeeOther)
+}
+
+// renderMailTemplate render both variants of the template with the same
+// pipeline as outgoing mail and show them on the preview page
+func (app *Server) renderMailTemplate(w http.ResponseWriter, r *http.Request, name, rawData, to string) {
+	previewRecipient := &MailRecipient{Email: "user@example.com"}
+	if user, ok := app.Session.Get(r.Context(), "user").(data.User); ok {
+		// the preview is personalized for the admin
+		previewRecipient = &MailRecipient{
+			FirstName: user.FirstName.String,
+			LastName:  user.LastName.String,
+			Email:     user.Email.String,
+		}
+	}
+	stringMap := map[string]string{
+		"name": name,
+		"data": rawData,
+		"to":   to,
+	}
+
+	if !app.Mail.Templates.Has(name) {
+		stringMap["renderError"] = fmt.Sprintf("unknown email template %s", name)
+	} else if sample, err := parseSampleMailData(rawData); err != nil {
+		stringMap["renderError"] = err.Error()
+	} else {
+		// the preview goes through the pipeline of sent emails, so it has the recipient,
+		// the unsubscribe link and the translation, opens and clicks are not tracked
+		base, locale, _ := strings.Cut(name, ".")
+		msg := app.withUnsubscribe(Message{
+			To:        []string{previewRecipient.Email},
+			Template:  base,
+			Locale:    locale,
+			Data:      sample,
+			Recipient: previewRecipient,
+		})
+		builder := newMsgBuilder(app.Config, app.Mail.Templates)
+		msg, plain, html, err := builder.prepare(msg)
+		if err != nil {
+			stringMap["renderError"] = err.Error()
+		}
+		stringMap["subject"] = msg.Subject
+		stringMap["plain"] = plain
+		stringMap["html"] = html
+	}
+
+	dataMap := make(map[string]any)
+	dataMap["templates"] = app.Mail.Templates.Names()
+
+	app.render(w, r, "mail-templates.page.gohtml", &TemplateData{
+		StringMap: stringMap,
+		DataMap:   dataMap,
+	})
+}
+
+// sampleMailData return the sample data of the template as indented JSON
+func sampleMailData(name string) string {
+	// translations use the sample of the default template
+	sample, err := json.MarshalIndent(mailTemplateSamples[mailTemplateBase(name)], "", "  ")
+	if err != nil {
+		return "null"
+	}
+	return string(sample)
+}
+
+func parseSampleMailData(rawData string) (any, error) {
+	var sample any
+	if strings.TrimSpace(rawData) == "" {
+		return nil, nil
+	}
+	if err := json.Unmarshal([]byte(rawData), &sample); err != nil {
+		return nil, fmt.Errorf("invalid sample data: %s", err)
+	}
+	return sample, nil
+}
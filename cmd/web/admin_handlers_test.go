@@ -0,0 +1,506 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	mockdb "github.com/dubass83/go-concurrency-project/data/mock"
+	data "github.com/dubass83/go-concurrency-project/data/sqlc"
+	"github.com/dubass83/go-concurrency-project/utils"
+	"github.com/golang/mock/gomock"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminHandler(t *testing.T) {
+
+	admin := utils.RandomUser("Qw12345678!")
+	admin.IsAdmin = pgtype.Int4{Int32: 1, Valid: true}
+	member := utils.RandomUser("Qw12345678!")
+
+	payloads, err := outboxPayload(Message{
+		To:      []string{member.Email.String},
+		Subject: "Yuor invoice",
+	})
+	require.NoError(t, err)
+
+	adminTests := []struct {
+		name               string
+		method             string
+		url                string
+		postedData         url.Values
+		sessionData        map[string]any
+		expectedStatusCode int
+		expectedHTML       string
+		expectedSessionKey string
+		buildStubs         func(store *mockdb.MockStore)
+	}{
+		{
+			name:   "deadLetters",
+			method: "GET",
+			url:    "/mail/dead-letters",
+			sessionData: map[string]any{
+				"userID": admin.ID,
+				"user":   admin,
+			},
+			expectedStatusCode: http.StatusOK,
+			expectedHTML:       member.Email.String,
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					GetDeadMailOutbox(gomock.Any(), gomock.Any()).
+					Times(1).
+					Return([]data.MailOutbox{
+						{
+							ID:       1,
+							Payload:  payloads[0],
+							Status:   "dead",
+							Attempts: 5,
+						},
+					}, nil)
+			},
+		},
+		{
+			name:   "redrive",
+			method: "POST",
+			url:    "/mail/dead-letters/redrive",
+			postedData: url.Values{
+				"id": {"1"},
+			},
+			sessionData: map[string]any{
+				"userID": admin.ID,
+				"user":   admin,
+			},
+			expectedStatusCode: http.StatusSeeOther,
+			expectedSessionKey: "flash",
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					RedriveMailOutbox(gomock.Any(), gomock.Eq(int64(1))).
+					Times(1).
+					Return(data.MailOutbox{ID: 1, Status: "pending"}, nil)
+
+				store.EXPECT().
+					InsertMailEvent(gomock.Any(), gomock.Any()).
+					Times(1).
+					Return(nil)
+			},
+		},
+		{
+			name:   "searchMessages",
+			method: "GET",
+			url:    "/mail/messages?recipient=" + url.QueryEscape(member.Email.String),
+			sessionData: map[string]any{
+				"userID": admin.ID,
+				"user":   admin,
+			},
+			expectedStatusCode: http.StatusOK,
+			expectedHTML:       member.Email.String,
+			buildStubs: func(store *mockdb.MockStore) {
+				arg := data.SearchMailOutboxParams{
+					Recipient: member.Email.String,
+					Limit:     50,
+					Offset:    0,
+				}
+				store.EXPECT().
+					SearchMailOutbox(gomock.Any(), gomock.Eq(arg)).
+					Times(1).
+					Return([]data.MailOutbox{
+						{
+							ID:       1,
+							Payload:  payloads[0],
+							Status:   "sent",
+							Attempts: 1,
+						},
+					}, nil)
+			},
+		},
+		{
+			name:   "searchWildcards",
+			method: "GET",
+			url:    "/mail/messages?recipient=" + url.QueryEscape(`a_b%\`),
+			sessionData: map[string]any{
+				"userID": admin.ID,
+				"user":   admin,
+			},
+			expectedStatusCode: http.StatusOK,
+			buildStubs: func(store *mockdb.MockStore) {
+				arg := data.SearchMailOutboxParams{
+					Recipient: `a\_b\%\\`,
+					Limit:     50,
+					Offset:    0,
+				}
+				store.EXPECT().
+					SearchMailOutbox(gomock.Any(), gomock.Eq(arg)).
+					Times(1).
+					Return([]data.MailOutbox{}, nil)
+			},
+		},
+		{
+			name:   "messageHistory",
+			method: "GET",
+			url:    "/mail/message?id=1",
+			sessionData: map[string]any{
+				"userID": admin.ID,
+				"user":   admin,
+			},
+			expectedStatusCode: http.StatusOK,
+			expectedHTML:       "421 service not available",
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					GetOneMailOutbox(gomock.Any(), gomock.Eq(int64(1))).
+					Times(1).
+					Return(data.MailOutbox{
+						ID:        1,
+						Payload:   payloads[0],
+						Status:    "sent",
+						Attempts:  2,
+						MessageID: "5b0e3c1a",
+					}, nil)
+
+				store.EXPECT().
+					GetMailEvents(gomock.Any(), gomock.Eq(int64(1))).
+					Times(1).
+					Return([]data.MailEvent{
+						{ID: 1, OutboxID: 1, Status: data.MailEventQueued},
+						{ID: 2, OutboxID: 1, Status: mailEventSending},
+						{
+							ID:       3,
+							OutboxID: 1,
+							Status:   mailEventRetried,
+							Detail:   pgtype.Text{String: "421 service not available", Valid: true},
+						},
+						{ID: 4, OutboxID: 1, Status: mailEventSending},
+						{ID: 5, OutboxID: 1, Status: mailEventSent},
+					}, nil)
+
+				store.EXPECT().
+					GetMailTrackingEvents(gomock.Any(), gomock.Eq("5b0e3c1a")).
+					Times(1).
+					Return([]data.MailTrackingEvent{}, nil)
+			},
+		},
+		{
+			name:   "messageTracking",
+			method: "GET",
+			url:    "/mail/message?id=1",
+			sessionData: map[string]any{
+				"userID": admin.ID,
+				"user":   admin,
+			},
+			expectedStatusCode: http.StatusOK,
+			expectedHTML:       "https://example.com/invoices/1",
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					GetOneMailOutbox(gomock.Any(), gomock.Eq(int64(1))).
+					Times(1).
+					Return(data.MailOutbox{
+						ID:        1,
+						Payload:   payloads[0],
+						Status:    "sent",
+						MessageID: "5b0e3c1a",
+					}, nil)
+
+				store.EXPECT().
+					GetMailEvents(gomock.Any(), gomock.Eq(int64(1))).
+					Times(1).
+					Return([]data.MailEvent{}, nil)
+
+				store.EXPECT().
+					GetMailTrackingEvents(gomock.Any(), gomock.Eq("5b0e3c1a")).
+					Times(1).
+					Return([]data.MailTrackingEvent{
+						{ID: 1, MessageID: "5b0e3c1a", Kind: trackingOpen},
+						{
+							ID:        2,
+							MessageID: "5b0e3c1a",
+							Kind:      trackingClick,
+							Url:       pgtype.Text{String: "https://example.com/invoices/1", Valid: true},
+						},
+					}, nil)
+			},
+		},
+		{
+			name:   "messageNotFound",
+			method: "GET",
+			url:    "/mail/message?id=2",
+			sessionData: map[string]any{
+				"userID": admin.ID,
+				"user":   admin,
+			},
+			expectedStatusCode: http.StatusSeeOther,
+			expectedSessionKey: "error",
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					GetOneMailOutbox(gomock.Any(), gomock.Eq(int64(2))).
+					Times(1).
+					Return(data.MailOutbox{}, pgx.ErrNoRows)
+
+				store.EXPECT().
+					GetMailEvents(gomock.Any(), gomock.Any()).
+					Times(0)
+			},
+		},
+		{
+			name:   "templatePreview",
+			method: "GET",
+			url:    "/mail/templates?name=" + mailTemplateConfirmation,
+			sessionData: map[string]any{
+				"userID": admin.ID,
+				"user":   admin,
+			},
+			expectedStatusCode: http.StatusOK,
+			// the activation link is rendered in the html and plain variants
+			expectedHTML: "activate?email=user@example.com",
+			buildStubs:   func(store *mockdb.MockStore) {},
+		},
+		{
+			name:   "templatePreviewRecipient",
+			method: "GET",
+			url:    "/mail/templates?name=" + mailTemplateDefault,
+			sessionData: map[string]any{
+				"userID": admin.ID,
+				"user":   admin,
+			},
+			expectedStatusCode: http.StatusOK,
+			// the preview is rendered like sent emails, with the recipient and the unsubscribe link
+			expectedHTML: "Hi " + admin.FirstName.String,
+			buildStubs:   func(store *mockdb.MockStore) {},
+		},
+		{
+			name:   "templatePreviewUnsubscribe",
+			method: "GET",
+			url:    "/mail/templates?name=" + mailTemplateDefault,
+			sessionData: map[string]any{
+				"userID": admin.ID,
+				"user":   admin,
+			},
+			expectedStatusCode: http.StatusOK,
+			expectedHTML:       "/unsubscribe?email=",
+			buildStubs:         func(store *mockdb.MockStore) {},
+		},
+		{
+			name:   "templatePreviewEditedData",
+			method: "POST",
+			url:    "/mail/templates",
+			postedData: url.Values{
+				"name": {mailTemplateInvoice},
+				"data": {`"$42.00"`},
+			},
+			sessionData: map[string]any{
+				"userID": admin.ID,
+				"user":   admin,
+			},
+			expectedStatusCode: http.StatusOK,
+			expectedHTML:       "$42.00",
+			buildStubs:         func(store *mockdb.MockStore) {},
+		},
+		{
+			name:   "templatePreviewInvalidData",
+			method: "POST",
+			url:    "/mail/templates",
+			postedData: url.Values{
+				"name": {mailTemplateInvoice},
+				"data": {`{"message": `},
+			},
+			sessionData: map[string]any{
+				"userID": admin.ID,
+				"user":   admin,
+			},
+			expectedStatusCode: http.StatusOK,
+			expectedHTML:       "invalid sample data",
+			buildStubs:         func(store *mockdb.MockStore) {},
+		},
+		{
+			name:   "templateTestSend",
+			method: "POST",
+			url:    "/mail/templates/send",
+			postedData: url.Values{
+				"name": {mailTemplateInvoice + ".uk"},
+				"data": {`"$42.00"`},
+				"to":   {admin.Email.String},
+			},
+			sessionData: map[string]any{
+				"userID": admin.ID,
+				"user":   admin,
+			},
+			expectedStatusCode: http.StatusSeeOther,
+			expectedSessionKey: "flash",
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					EnqueueMailTx(gomock.Any(), gomock.Any()).
+					Times(1).
+					DoAndReturn(func(_ any, payloads [][]byte) (data.EnqueueMailTxResult, error) {
+						msg, err := messageFromOutbox(data.MailOutbox{Payload: payloads[0]})
+						require.NoError(t, err)
+						require.Equal(t, []string{admin.Email.String}, msg.To)
+						require.Equal(t, mailTemplateInvoice, msg.Template)
+						require.Equal(t, "uk", msg.Locale)
+						require.Equal(t, "$42.00", msg.Data)
+						return data.EnqueueMailTxResult{}, nil
+					})
+			},
+		},
+		{
+			name:   "templateTestSendUnknownTemplate",
+			method: "POST",
+			url:    "/mail/templates/send",
+			postedData: url.Values{
+				"name": {"invoce"},
+				"data": {`"$42.00"`},
+				"to":   {admin.Email.String},
+			},
+			sessionData: map[string]any{
+				"userID": admin.ID,
+				"user":   admin,
+			},
+			expectedStatusCode: http.StatusSeeOther,
+			expectedSessionKey: "error",
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					EnqueueMailTx(gomock.Any(), gomock.Any()).
+					Times(0)
+			},
+		},
+		{
+			name:   "templateTestSendInvalidAddress",
+			method: "POST",
+			url:    "/mail/templates/send",
+			postedData: url.Values{
+				"name": {mailTemplateInvoice},
+				"data": {`"$42.00"`},
+				"to":   {"not an address"},
+			},
+			sessionData: map[string]any{
+				"userID": admin.ID,
+				"user":   admin,
+			},
+			expectedStatusCode: http.StatusOK,
+			expectedHTML:       "Invalid email address!",
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					EnqueueMailTx(gomock.Any(), gomock.Any()).
+					Times(0)
+			},
+		},
+		{
+			name:   "broadcasts",
+			method: "GET",
+			url:    "/mail/broadcasts",
+			sessionData: map[string]any{
+				"userID": admin.ID,
+				"user":   admin,
+			},
+			expectedStatusCode: http.StatusOK,
+			expectedHTML:       "Gold Plan",
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					GetAllPlans(gomock.Any(), gomock.Any()).
+					Times(1).
+					Return([]data.Plan{
+						{ID: 3, PlanName: pgtype.Text{String: "Gold Plan", Valid: true}},
+					}, nil)
+			},
+		},
+		{
+			name:   "broadcastMandatoryCategory",
+			method: "POST",
+			url:    "/mail/broadcasts",
+			postedData: url.Values{
+				"template": {mailTemplateDefault},
+				"category": {mailCategorySecurity},
+			},
+			sessionData: map[string]any{
+				"userID": admin.ID,
+				"user":   admin,
+			},
+			expectedStatusCode: http.StatusSeeOther,
+			expectedSessionKey: "error",
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					CountSegmentUsers(gomock.Any(), gomock.Any()).
+					Times(0)
+			},
+		},
+		{
+			name:   "cancelUnknownBroadcast",
+			method: "POST",
+			url:    "/mail/broadcasts/cancel",
+			postedData: url.Values{
+				"id": {"42"},
+			},
+			sessionData: map[string]any{
+				"userID": admin.ID,
+				"user":   admin,
+			},
+			expectedStatusCode: http.StatusSeeOther,
+			expectedSessionKey: "error",
+			buildStubs:         func(store *mockdb.MockStore) {},
+		},
+		{
+			name:   "unknownBroadcastProgress",
+			method: "GET",
+			url:    "/mail/broadcasts/progress?id=42",
+			sessionData: map[string]any{
+				"userID": admin.ID,
+				"user":   admin,
+			},
+			expectedStatusCode: http.StatusNotFound,
+			buildStubs:         func(store *mockdb.MockStore) {},
+		},
+		{
+			name:   "notAdmin",
+			method: "GET",
+			url:    "/mail/dead-letters",
+			sessionData: map[string]any{
+				"userID": member.ID,
+				"user":   member,
+			},
+			expectedStatusCode: http.StatusSeeOther,
+			expectedSessionKey: "error",
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					GetDeadMailOutbox(gomock.Any(), gomock.Any()).
+					Times(0)
+			},
+		},
+	}
+
+	for _, at := range adminTests {
+
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest(at.method, at.url, strings.NewReader(at.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		for key, value := range at.sessionData {
+			testApp.Session.Put(ctx, key, value)
+		}
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := mockdb.NewMockStore(ctrl)
+		at.buildStubs(store)
+
+		testApp.Store = store
+
+		// admin router is mounted under /admin
+		testApp.AdminRouter().ServeHTTP(rr, req)
+
+		require.Equal(t, at.expectedStatusCode, rr.Code, fmt.Sprintf("test name: %s", at.name))
+
+		if len(at.expectedHTML) > 0 {
+			html := rr.Body.String()
+			require.Contains(t, html, at.expectedHTML, fmt.Sprintf("test name: %s", at.name))
+		}
+		if len(at.expectedSessionKey) > 0 {
+			require.True(t, testApp.Session.Exists(ctx, at.expectedSessionKey), fmt.Sprintf("test name: %s", at.name))
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"path/filepath"
+
+	"github.com/wneessen/go-mail"
+)
+
+const defaultAttachmentContentType = "application/octet-stream"
+
+// Attachment is a file generated in memory and sent with the message.
+// Data is stored in the mail outbox with the rest of the message,
+// Reader is read once when the message is enqueued or built
+type Attachment struct {
+	Name        string
+	ContentType string
+	Data        []byte    `json:",omitempty"`
+	Reader      io.Reader `json:"-"`
+}
+
+// buffer read the Reader into Data, so the attachment can be stored
+// in the outbox and attached again on every retry
+func (a Attachment) buffer() (Attachment, error) {
+	if a.Reader == nil {
+		return a, nil
+	}
+	data, err := io.ReadAll(a.Reader)
+	if err != nil {
+		return a, fmt.Errorf("failed to read attachment %s: %s", a.Name, err)
+	}
+	a.Data = data
+	a.Reader = nil
+	return a, nil
+}
+
+// contentType return the content type of the attachment
+// or guess it from the file name
+func (a Attachment) contentType() string {
+	if a.ContentType != "" {
+		return a.ContentType
+	}
+	if ct := mime.TypeByExtension(filepath.Ext(a.Name)); ct != "" {
+		return ct
+	}
+	return defaultAttachmentContentType
+}
+
+// attach add the attachment to the mail
+func (a Attachment) attach(m *mail.Msg) error {
+	opt := mail.WithFileContentType(mail.ContentType(a.contentType()))
+	if a.Reader != nil {
+		return m.AttachReader(a.Name, a.Reader, opt)
+	}
+	m.AttachReadSeeker(a.Name, bytes.NewReader(a.Data), opt)
+	return nil
+}
+
+// bufferAttachments return the message with all attachments read into memory,
+// attachments of the original message are not changed
+func bufferAttachments(msg Message) (Message, error) {
+	if len(msg.Attachments) == 0 {
+		return msg, nil
+	}
+	attachments := make([]Attachment, 0, len(msg.Attachments))
+	for _, a := range msg.Attachments {
+		buffered, err := a.buffer()
+		if err != nil {
+			return msg, err
+		}
+		attachments = append(attachments, buffered)
+	}
+	msg.Attachments = attachments
+	return msg, nil
+}
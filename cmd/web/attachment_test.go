@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	data "github.com/dubass83/go-concurrency-project/data/sqlc"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttachmentContentType(t *testing.T) {
+	require.Equal(t, "application/pdf", Attachment{Name: "Manual.pdf"}.contentType())
+	require.Equal(t, "text/csv", Attachment{Name: "report.csv", ContentType: "text/csv"}.contentType())
+	require.Equal(t, defaultAttachmentContentType, Attachment{Name: "blob"}.contentType())
+}
+
+func TestOutboxAttachments(t *testing.T) {
+	reader := strings.NewReader("generated in memory")
+	msg := Message{
+		To: []string{"user@example.com"},
+		Attachments: []Attachment{
+			{Name: "report.txt", ContentType: "text/plain", Reader: reader},
+			{Name: "data.bin", Data: []byte{0, 1, 2}},
+		},
+	}
+
+	payloads, err := outboxPayload(msg)
+	require.NoError(t, err)
+	require.NotNil(t, msg.Attachments[0].Reader, "the original message is not changed")
+
+	restored, err := messageFromOutbox(data.MailOutbox{ID: 1, Payload: payloads[0]})
+	require.NoError(t, err)
+	require.Len(t, restored.Attachments, 2)
+	require.Equal(t, []byte("generated in memory"), restored.Attachments[0].Data)
+	require.Equal(t, "text/plain", restored.Attachments[0].ContentType)
+	require.Nil(t, restored.Attachments[0].Reader)
+	require.Equal(t, []byte{0, 1, 2}, restored.Attachments[1].Data)
+
+	// restored attachments can be sent more than once
+	sender := NewMemorySender(newMsgBuilder(testApp.Config, testApp.Mail.Templates), 10)
+	for range 2 {
+		require.NoError(t, sender.SendEmail(restored))
+		captured := sender.Messages()[0]
+		require.Equal(t, []string{"report.txt", "data.bin"}, captured.Attachments)
+		require.Contains(t, string(captured.Raw), `Content-Disposition: attachment; filename="report.txt"`)
+		require.Contains(t, string(captured.Raw), "Content-Type: text/plain")
+		require.Contains(t, string(captured.Raw), "Content-Type: application/octet-stream")
+	}
+
+	// reader attachments can be sent without the outbox
+	err = sender.SendEmail(Message{
+		To: []string{"user@example.com"},
+		Attachments: []Attachment{
+			{Name: "Manual.pdf", Reader: bytes.NewReader([]byte("%PDF-1.3"))},
+		},
+	})
+	require.NoError(t, err)
+	require.Contains(t, string(sender.Messages()[0].Raw), "Content-Type: application/pdf")
+}
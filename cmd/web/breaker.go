@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"expvar"
+	"net"
+	"net/textproto"
+	"sync"
+	"time"
+
+	"github.com/dubass83/go-concurrency-project/utils"
+	"github.com/rs/zerolog/log"
+	"github.com/wneessen/go-mail"
+)
+
+const (
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 30 * time.Second
+)
+
+// states of the circuit breaker
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// errCircuitOpen is returned when the message is not sent because the mail server is down,
+// the message is released back to the outbox without counting an attempt
+var errCircuitOpen = errors.New("mail server is unavailable, circuit breaker is open")
+
+// CircuitBreaker wrap any EmailSender and stop sending after repeated failures.
+// While it is open mail workers do not take messages from the queue, after the cooldown
+// a single message is sent as a probe and the breaker is closed when it succeeds
+type CircuitBreaker struct {
+	EmailSender
+	Threshold int
+	Cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	// changed is closed every time the state changes to wake parked workers
+	changed chan struct{}
+	now     func() time.Time
+}
+
+// BreakerStatus is the state of the circuit breaker shown on the health endpoint
+type BreakerStatus struct {
+	State    string    `json:"state"`
+	Failures int       `json:"failures"`
+	OpenedAt time.Time `json:"opened_at,omitzero"`
+	RetryAt  time.Time `json:"retry_at,omitzero"`
+}
+
+func NewCircuitBreaker(sender EmailSender, threshold int, cooldown time.Duration) *CircuitBreaker {
+	if threshold <= 0 {
+		threshold = defaultBreakerThreshold
+	}
+	if cooldown <= 0 {
+		cooldown = defaultBreakerCooldown
+	}
+	cb := &CircuitBreaker{
+		EmailSender: sender,
+		Threshold:   threshold,
+		Cooldown:    cooldown,
+		state:       breakerClosed,
+		changed:     make(chan struct{}),
+		now:         time.Now,
+	}
+	mailMetrics.Set("breaker_state", expvar.Func(func() any {
+		return cb.Status().State
+	}))
+	return cb
+}
+
+// newMailBreaker wrap the sender with the circuit breaker configured by MAIL_BREAKER_*
+func newMailBreaker(conf utils.Config, sender EmailSender) *CircuitBreaker {
+	return NewCircuitBreaker(sender, conf.MailBreakerThreshold, conf.MailBreakerCooldown)
+}
+
+func (cb *CircuitBreaker) SendEmail(email Message) error {
+	if !cb.allow() {
+		return errCircuitOpen
+	}
+	err := cb.EmailSender.SendEmail(email)
+	cb.record(err)
+	return err
+}
+
+func (cb *CircuitBreaker) NewWorker() EmailWorker {
+	return &breakerWorker{
+		EmailWorker: cb.EmailSender.NewWorker(),
+		breaker:     cb,
+	}
+}
+
+// breakerWorker report results of the mail worker to the circuit breaker
+type breakerWorker struct {
+	EmailWorker
+	breaker *CircuitBreaker
+}
+
+func (w *breakerWorker) SendEmail(email Message) error {
+	if !w.breaker.allow() {
+		return errCircuitOpen
+	}
+	err := w.EmailWorker.SendEmail(email)
+	w.breaker.record(err)
+	return err
+}
+
+// Status return the current state of the breaker
+func (cb *CircuitBreaker) Status() BreakerStatus {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	status := BreakerStatus{
+		State:    cb.state,
+		Failures: cb.failures,
+	}
+	if cb.state != breakerClosed {
+		status.OpenedAt = cb.openedAt
+		status.RetryAt = cb.openedAt.Add(cb.Cooldown)
+	}
+	return status
+}
+
+// Open report if messages must wait for the cooldown, it is false for a nil breaker
+func (cb *CircuitBreaker) Open() bool {
+	if cb == nil {
+		return false
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state == breakerOpen && cb.now().Before(cb.openedAt.Add(cb.Cooldown))
+}
+
+// allow report if the message can be sent, the first message after the cooldown
+// switch the breaker to half-open and the rest wait for its result
+func (cb *CircuitBreaker) allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case breakerClosed:
+		return true
+	case breakerOpen:
+		if cb.now().Before(cb.openedAt.Add(cb.Cooldown)) {
+			return false
+		}
+		cb.setState(breakerHalfOpen)
+		log.Info().Msg("circuit breaker is half-open, probing the mail server")
+		return true
+	default:
+		return false
+	}
+}
+
+// record the result of the message. Any reply of the mail server, 4xx included, prove it
+// is available and only failures to reach it count. Messages which failed before reaching
+// the server (render, subject, redirect) leave the state unchanged
+func (cb *CircuitBreaker) record(err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if err == nil || smtpReply(err) {
+		if cb.state != breakerClosed {
+			log.Info().Msg("circuit breaker is closed, the mail server is available")
+			cb.setState(breakerClosed)
+		}
+		cb.failures = 0
+		return
+	}
+	if !unreachable(err) {
+		if cb.state == breakerHalfOpen {
+			// the probe did not reach the server, the next message probes it again
+			cb.setState(breakerOpen)
+		}
+		return
+	}
+
+	cb.failures++
+	if cb.state == breakerHalfOpen || (cb.state == breakerClosed && cb.failures >= cb.Threshold) {
+		cb.openedAt = cb.now()
+		cb.setState(breakerOpen)
+		mailMetrics.Add("breaker_opened", 1)
+		log.Error().
+			Err(err).
+			Int("failures", cb.failures).
+			Dur("cooldown", cb.Cooldown).
+			Msg("circuit breaker is open, mail workers are paused")
+	}
+}
+
+// smtpReply report if the error is the reply of the mail server
+func smtpReply(err error) bool {
+	var te *textproto.Error
+	if errors.As(err, &te) {
+		return true
+	}
+	// go-mail keeps the reply code of failed smtp commands
+	var se *mail.SendError
+	return errors.As(err, &se) && se.ErrorCode() > 0
+}
+
+// unreachable report if the mail server could not be reached: dial, TLS and timeout
+// errors and connections lost in the middle of the message
+func unreachable(err error) bool {
+	var ne net.Error
+	if errors.As(err, &ne) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var rhe tls.RecordHeaderError
+	var ae tls.AlertError
+	var cve *tls.CertificateVerificationError
+	if errors.As(err, &rhe) || errors.As(err, &ae) || errors.As(err, &cve) {
+		return true
+	}
+	var se *mail.SendError
+	return errors.As(err, &se) && se.Reason == mail.ErrConnCheck
+}
+
+// setState must be called with the lock held
+func (cb *CircuitBreaker) setState(state string) {
+	cb.state = state
+	close(cb.changed)
+	cb.changed = make(chan struct{})
+}
+
+// wait park the mail worker while the breaker is open or the probe is in flight,
+// so messages stay in the queue. It report false when the worker must stop
+func (cb *CircuitBreaker) wait(stop <-chan struct{}) bool {
+	if cb == nil {
+		return true
+	}
+	for {
+		cb.mu.Lock()
+		state := cb.state
+		retryAt := cb.openedAt.Add(cb.Cooldown)
+		changed := cb.changed
+		now := cb.now()
+		cb.mu.Unlock()
+
+		if state == breakerClosed || (state == breakerOpen && !now.Before(retryAt)) {
+			// after the cooldown one of the workers take the probe
+			return true
+		}
+
+		// half-open breaker wait only for the result of the probe
+		timer := time.NewTimer(time.Hour)
+		if state == breakerOpen {
+			timer.Reset(retryAt.Sub(now))
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+		case <-stop:
+			timer.Stop()
+			return false
+		}
+		timer.Stop()
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mockdb "github.com/dubass83/go-concurrency-project/data/mock"
+	data "github.com/dubass83/go-concurrency-project/data/sqlc"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/wneessen/go-mail"
+)
+
+// errConnRefused is the error of the mail server which is down
+var errConnRefused = &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+// rcptReplyError send the message to the smtp stub which answer RCPT TO with the reply
+// and return the error of go-mail, so it has the reply code like real failures
+func rcptReplyError(t *testing.T, reply string) error {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		write := func(line string) { _, _ = conn.Write([]byte(line + "\r\n")) }
+		write("220 localhost ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				write("250 localhost")
+			case strings.HasPrefix(cmd, "RCPT"):
+				write(reply)
+			case strings.HasPrefix(cmd, "QUIT"):
+				write("221 bye")
+				return
+			default:
+				write("250 ok")
+			}
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+	client, err := mail.NewClient("127.0.0.1",
+		mail.WithPort(portNumber),
+		mail.WithTLSPolicy(mail.NoTLS),
+		mail.WithTimeout(5*time.Second),
+	)
+	require.NoError(t, err)
+	m := mail.NewMsg()
+	require.NoError(t, m.From("no-reply@example.com"))
+	require.NoError(t, m.To("user@example.com"))
+	m.SetBodyString(mail.TypeTextPlain, "hello")
+
+	err = client.DialAndSend(m)
+	require.Error(t, err)
+	return err
+}
+
+// stubSender return the configured error and count messages which reached it
+type stubSender struct {
+	err   atomic.Value
+	calls atomic.Int32
+}
+
+func (s *stubSender) setErr(err error) {
+	s.err.Store(&err)
+}
+
+func (s *stubSender) SendEmail(email Message) error {
+	s.calls.Add(1)
+	if err, ok := s.err.Load().(*error); ok {
+		return *err
+	}
+	return nil
+}
+
+func (s *stubSender) NewWorker() EmailWorker {
+	return s
+}
+
+func (s *stubSender) Close() error {
+	return nil
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	sender := &stubSender{}
+	cb := NewCircuitBreaker(sender, 2, time.Minute)
+	now := time.Now()
+	cb.now = func() time.Time { return now }
+	worker := cb.NewWorker()
+
+	down := errConnRefused
+	sender.setErr(down)
+
+	require.ErrorIs(t, worker.SendEmail(Message{}), down)
+	require.Equal(t, breakerClosed, cb.Status().State, "single failure must not open the breaker")
+	require.ErrorIs(t, worker.SendEmail(Message{}), down)
+	require.True(t, cb.Open())
+	require.Equal(t, breakerOpen, cb.Status().State)
+	require.Equal(t, now.Add(time.Minute), cb.Status().RetryAt)
+
+	require.ErrorIs(t, worker.SendEmail(Message{}), errCircuitOpen)
+	require.ErrorIs(t, cb.SendEmail(Message{}), errCircuitOpen)
+	require.Equal(t, int32(2), sender.calls.Load(), "messages must not reach the mail server while the breaker is open")
+
+	// the probe fail and the breaker is open for another cooldown
+	now = now.Add(time.Minute)
+	require.False(t, cb.Open())
+	require.ErrorIs(t, worker.SendEmail(Message{}), down)
+	require.True(t, cb.Open())
+	require.Equal(t, int32(3), sender.calls.Load())
+
+	// only one probe is sent at a time
+	now = now.Add(time.Minute)
+	require.True(t, cb.allow())
+	require.Equal(t, breakerHalfOpen, cb.Status().State)
+	require.ErrorIs(t, worker.SendEmail(Message{}), errCircuitOpen)
+
+	sender.setErr(nil)
+	cb.record(nil)
+	require.Equal(t, breakerClosed, cb.Status().State)
+	require.Zero(t, cb.Status().Failures)
+	require.NoError(t, worker.SendEmail(Message{}))
+
+	// rejected recipients mean the mail server is up
+	sender.setErr(&textproto.Error{Code: 550, Msg: "no such user"})
+	for range 3 {
+		require.Error(t, worker.SendEmail(Message{}))
+	}
+	require.Equal(t, breakerClosed, cb.Status().State)
+
+	// temporary replies like greylisting or a full mailbox are answers of the server as well
+	full := rcptReplyError(t, "452 4.2.2 mailbox full")
+	var se *mail.SendError
+	require.ErrorAs(t, full, &se)
+	require.Equal(t, 452, se.ErrorCode())
+	sender.setErr(full)
+	for range 3 {
+		require.Error(t, worker.SendEmail(Message{}))
+	}
+	require.Equal(t, breakerClosed, cb.Status().State)
+	require.Zero(t, cb.Status().Failures)
+
+	// a temporary reply to the probe close the breaker
+	sender.setErr(down)
+	require.Error(t, worker.SendEmail(Message{}))
+	require.Error(t, worker.SendEmail(Message{}))
+	require.Equal(t, breakerOpen, cb.Status().State)
+	now = now.Add(time.Minute)
+	sender.setErr(&textproto.Error{Code: 421, Msg: "too many connections"})
+	require.Error(t, worker.SendEmail(Message{}))
+	require.Equal(t, breakerClosed, cb.Status().State)
+
+	// messages which fail before reaching the mail server do not close the breaker
+	sender.setErr(down)
+	require.Error(t, worker.SendEmail(Message{}))
+	require.Error(t, worker.SendEmail(Message{}))
+	require.Equal(t, breakerOpen, cb.Status().State)
+	now = now.Add(time.Minute)
+	sender.setErr(permanent(errors.New("failed to generate html formated message")))
+	require.Error(t, worker.SendEmail(Message{}))
+	require.Equal(t, breakerOpen, cb.Status().State, "the failed probe must not close the breaker")
+	require.True(t, cb.allow(), "the next message probes the mail server again")
+	require.Equal(t, breakerHalfOpen, cb.Status().State)
+}
+
+func TestCircuitBreakerWait(t *testing.T) {
+	sender := &stubSender{}
+	cb := NewCircuitBreaker(sender, 1, time.Hour)
+	stop := make(chan struct{})
+
+	require.True(t, cb.wait(stop), "closed breaker must not park workers")
+
+	sender.setErr(errConnRefused)
+	require.Error(t, cb.SendEmail(Message{}))
+	require.True(t, cb.Open())
+
+	// the probe of another worker close the breaker
+	parked := make(chan bool)
+	go func() {
+		parked <- cb.wait(stop)
+	}()
+	select {
+	case <-parked:
+		t.Fatal("worker must be parked while the breaker is open")
+	case <-time.After(20 * time.Millisecond):
+	}
+	cb.mu.Lock()
+	cb.setState(breakerHalfOpen)
+	cb.mu.Unlock()
+	cb.record(nil)
+	require.True(t, <-parked)
+
+	// parked workers stop with the app
+	require.Error(t, cb.SendEmail(Message{}))
+	close(stop)
+	require.False(t, cb.wait(stop))
+
+	var nilBreaker *CircuitBreaker
+	require.True(t, nilBreaker.wait(stop))
+	require.False(t, nilBreaker.Open())
+}
+
+func TestDeliverMailCircuitOpen(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	store := mockdb.NewMockStore(ctrl)
+	store.EXPECT().
+		ReleaseMailOutbox(gomock.Any(), gomock.Eq(int64(7))).
+		Times(1).
+		Return(nil)
+	store.EXPECT().
+		InsertMailEvent(gomock.Any(), gomock.Any()).
+		Times(1).
+		DoAndReturn(func(_ any, arg data.InsertMailEventParams) error {
+			require.Equal(t, mailEventReleased, arg.Status)
+			return nil
+		})
+	// the attempt is not counted
+	store.EXPECT().
+		RetryMailOutbox(gomock.Any(), gomock.Any()).
+		Times(0)
+
+	sender := &stubSender{}
+	sender.setErr(errConnRefused)
+	cb := NewCircuitBreaker(sender, 1, time.Hour)
+	require.Error(t, cb.SendEmail(Message{}))
+
+	app := Server{
+		Store: store,
+		Mail: Mail{
+			Breaker: cb,
+		},
+	}
+	app.deliverMail(cb.NewWorker(), Message{OutboxID: 7, Attempt: 1})
+	require.Equal(t, int32(1), sender.calls.Load())
+}
+
+func TestHealth(t *testing.T) {
+	sender := &stubSender{}
+	cb := NewCircuitBreaker(sender, 1, time.Hour)
+	app := Server{
+		Mail: Mail{
+			MailerChan: make(chan Message, 2),
+			BulkChan:   make(chan Message, 2),
+			Breaker:    cb,
+		},
+	}
+	app.Mail.BulkChan <- Message{}
+
+	health := func() healthStatus {
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/health", nil)
+		app.Health(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var status healthStatus
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &status))
+		return status
+	}
+
+	status := health()
+	require.Equal(t, healthOK, status.Status)
+	require.Equal(t, breakerClosed, status.Mail.Breaker.State)
+	require.Equal(t, 1, status.Mail.QueueDepth[mailPriorityBulk])
+
+	sender.setErr(errConnRefused)
+	require.Error(t, cb.SendEmail(Message{}))
+
+	status = health()
+	require.Equal(t, healthDegraded, status.Status)
+	require.Equal(t, breakerOpen, status.Mail.Breaker.State)
+	require.False(t, status.Mail.Breaker.RetryAt.IsZero())
+}
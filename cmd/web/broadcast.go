@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	data "github.com/dubass83/go-concurrency-project/data/sqlc"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/rs/zerolog/log"
+)
+
+// statuses of broadcasts
+const (
+	broadcastRunning  = "running"
+	broadcastFinished = "finished"
+	broadcastCanceled = "canceled"
+	broadcastFailed   = "failed"
+)
+
+// activity statuses of users in the segment
+const (
+	segmentActive   = "active"
+	segmentInactive = "inactive"
+)
+
+// broadcastPageSize is the number of recipients loaded from the database at once
+const broadcastPageSize = 100
+
+// BroadcastSegment select recipients of the broadcast, zero values match every user
+type BroadcastSegment struct {
+	PlanID         int32
+	Activity       string
+	SignedUpAfter  time.Time
+	SignedUpBefore time.Time
+}
+
+func (s BroadcastSegment) countParams() data.CountSegmentUsersParams {
+	arg := data.CountSegmentUsersParams{
+		PlanID: pgtype.Int4{
+			Int32: s.PlanID,
+			Valid: s.PlanID != 0,
+		},
+		SignedUpAfter: pgtype.Timestamp{
+			Time:  s.SignedUpAfter,
+			Valid: !s.SignedUpAfter.IsZero(),
+		},
+		SignedUpBefore: pgtype.Timestamp{
+			Time:  s.SignedUpBefore,
+			Valid: !s.SignedUpBefore.IsZero(),
+		},
+	}
+	switch s.Activity {
+	case segmentActive:
+		arg.UserActive = pgtype.Int4{Int32: 1, Valid: true}
+	case segmentInactive:
+		arg.UserActive = pgtype.Int4{Int32: 0, Valid: true}
+	}
+	return arg
+}
+
+// pageParams return the query of recipients following the user afterID
+func (s BroadcastSegment) pageParams(afterID int32) data.GetSegmentUsersParams {
+	arg := s.countParams()
+	return data.GetSegmentUsersParams{
+		AfterID:        afterID,
+		PlanID:         arg.PlanID,
+		UserActive:     arg.UserActive,
+		SignedUpAfter:  arg.SignedUpAfter,
+		SignedUpBefore: arg.SignedUpBefore,
+		Limit:          broadcastPageSize,
+	}
+}
+
+// Broadcast is the email sent to every user of the segment,
+// the template is rendered for every recipient in the language of the user
+type Broadcast struct {
+	ID        int
+	Template  string
+	Subject   string
+	Category  string
+	Data      any
+	Segment   BroadcastSegment
+	StartedAt time.Time
+
+	mu         sync.Mutex
+	status     string
+	total      int64
+	queued     int
+	failed     int
+	lastError  string
+	finishedAt time.Time
+	cancel     context.CancelFunc
+}
+
+// BroadcastProgress is the state of the broadcast shown to admins
+type BroadcastProgress struct {
+	ID         int       `json:"id"`
+	Template   string    `json:"template"`
+	Subject    string    `json:"subject"`
+	Status     string    `json:"status"`
+	Total      int64     `json:"total"`
+	Queued     int       `json:"queued"`
+	Failed     int       `json:"failed"`
+	Percent    int       `json:"percent"`
+	LastError  string    `json:"last_error"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// Progress return the snapshot of the broadcast state
+func (b *Broadcast) Progress() BroadcastProgress {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	p := BroadcastProgress{
+		ID:         b.ID,
+		Template:   b.Template,
+		Subject:    b.Subject,
+		Status:     b.status,
+		Total:      b.total,
+		Queued:     b.queued,
+		Failed:     b.failed,
+		LastError:  b.lastError,
+		StartedAt:  b.StartedAt,
+		FinishedAt: b.finishedAt,
+	}
+	if b.total > 0 {
+		p.Percent = int(int64(b.queued+b.failed) * 100 / b.total)
+	}
+	return p
+}
+
+// Cancel stop the running broadcast, messages already queued are still sent
+func (b *Broadcast) Cancel() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.status != broadcastRunning || b.cancel == nil {
+		return false
+	}
+	b.cancel()
+	return true
+}
+
+func (b *Broadcast) setTotal(total int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.total = total
+}
+
+func (b *Broadcast) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err != nil {
+		b.failed++
+		b.lastError = err.Error()
+		return
+	}
+	b.queued++
+}
+
+func (b *Broadcast) finish(status string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.status = status
+	b.finishedAt = time.Now()
+	if err != nil {
+		b.lastError = err.Error()
+	}
+}
+
+// message return the email of the recipient
+func (b *Broadcast) message(user data.User) Message {
+	return Message{
+		To:       []string{user.Email.String},
+		Subject:  b.Subject,
+		Template: b.Template,
+		Locale:   user.Locale,
+		Category: b.Category,
+		Priority: mailPriorityBulk,
+		Data:     b.Data,
+		Recipient: &MailRecipient{
+			FirstName: user.FirstName.String,
+			LastName:  user.LastName.String,
+			Email:     user.Email.String,
+		},
+	}
+}
+
+// Broadcasts keep broadcasts started since the start of the app, they are not persisted:
+// messages queued before a restart stay in the mail outbox, the rest of the segment is not sent
+type Broadcasts struct {
+	mu     sync.Mutex
+	nextID int
+	jobs   map[int]*Broadcast
+}
+
+func NewBroadcasts() *Broadcasts {
+	return &Broadcasts{
+		jobs: make(map[int]*Broadcast),
+	}
+}
+
+func (bs *Broadcasts) add(b *Broadcast) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	bs.nextID++
+	b.ID = bs.nextID
+	bs.jobs[b.ID] = b
+}
+
+// Get return the broadcast by its id
+func (bs *Broadcasts) Get(id int) (*Broadcast, bool) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	b, ok := bs.jobs[id]
+	return b, ok
+}
+
+// List return the progress of all broadcasts, the latest first
+func (bs *Broadcasts) List() []BroadcastProgress {
+	bs.mu.Lock()
+	jobs := make([]*Broadcast, 0, len(bs.jobs))
+	for _, b := range bs.jobs {
+		jobs = append(jobs, b)
+	}
+	bs.mu.Unlock()
+
+	result := make([]BroadcastProgress, 0, len(jobs))
+	for _, b := range jobs {
+		result = append(result, b.Progress())
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID > result[j].ID
+	})
+	return result
+}
+
+// CancelAll stop running broadcasts when the app is shutting down
+func (bs *Broadcasts) CancelAll() {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	for _, b := range bs.jobs {
+		b.Cancel()
+	}
+}
+
+// startBroadcast queue emails of the broadcast in the background
+func (app *Server) startBroadcast(b *Broadcast) {
+	ctx, cancel := context.WithCancel(context.Background())
+	b.status = broadcastRunning
+	b.cancel = cancel
+	b.StartedAt = time.Now()
+	app.Mail.Broadcasts.add(b)
+
+	app.Wait.Add(1)
+	go func() {
+		defer app.Wait.Done()
+		defer cancel()
+		app.runBroadcast(ctx, b)
+	}()
+}
+
+// runBroadcast store the message of every recipient in the mail outbox page by page,
+// mail workers send them with the rest of bulk mail
+func (app *Server) runBroadcast(ctx context.Context, b *Broadcast) {
+	total, err := app.Store.CountSegmentUsers(ctx, b.Segment.countParams())
+	if err != nil {
+		log.Error().Err(err).Int("broadcast", b.ID).Msg("failed to count recipients of the broadcast")
+		b.finish(broadcastFailed, err)
+		return
+	}
+	b.setTotal(total)
+
+	var afterID int32
+	for {
+		users, err := app.Store.GetSegmentUsers(ctx, b.Segment.pageParams(afterID))
+		if ctx.Err() != nil {
+			b.finish(broadcastCanceled, nil)
+			log.Info().Int("broadcast", b.ID).Msg("broadcast is canceled")
+			return
+		}
+		if err != nil {
+			log.Error().Err(err).Int("broadcast", b.ID).Msg("failed to get recipients of the broadcast")
+			b.finish(broadcastFailed, err)
+			return
+		}
+
+		for _, user := range users {
+			if ctx.Err() != nil {
+				b.finish(broadcastCanceled, nil)
+				log.Info().Int("broadcast", b.ID).Msg("broadcast is canceled")
+				return
+			}
+			err := app.enqueueMail(ctx, b.message(user))
+			if err != nil {
+				log.Error().Err(err).Int("broadcast", b.ID).Int32("user_id", user.ID).Msg("failed to queue broadcast email")
+			}
+			b.record(err)
+		}
+
+		if len(users) < broadcastPageSize {
+			break
+		}
+		afterID = users[len(users)-1].ID
+	}
+
+	b.finish(broadcastFinished, nil)
+	p := b.Progress()
+	log.Info().
+		Int("broadcast", b.ID).
+		Int("queued", p.Queued).
+		Int("failed", p.Failed).
+		Msg("broadcast is finished")
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	data "github.com/dubass83/go-concurrency-project/data/sqlc"
+	"github.com/rs/zerolog/log"
+)
+
+// MailBroadcasts show the form of a new broadcast and the progress of started ones
+func (app *Server) MailBroadcasts(w http.ResponseWriter, r *http.Request) {
+	plans, err := app.Store.GetAllPlans(r.Context(), data.GetAllPlansParams{
+		Limit:  50,
+		Offset: 0,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get plans for the broadcast")
+		app.Session.Put(r.Context(), "error", "Unable to load plans!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	categories := []mailCategory{}
+	for _, c := range mailCategories {
+		if !c.Mandatory {
+			categories = append(categories, c)
+		}
+	}
+
+	dataMap := make(map[string]any)
+	dataMap["templates"] = app.broadcastTemplates()
+	dataMap["plans"] = plans
+	dataMap["categories"] = categories
+	dataMap["broadcasts"] = app.Mail.Broadcasts.List()
+
+	app.render(w, r, "broadcasts.page.gohtml", &TemplateData{
+		DataMap: dataMap,
+	})
+}
+
+// PostMailBroadcast start sending the template to every user of the chosen segment
+func (app *Server) PostMailBroadcast(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse broadcast form")
+		http.Redirect(w, r, "/admin/mail/broadcasts", http.StatusSeeOther)
+		return
+	}
+
+	b, err := app.broadcastFromForm(r.PostForm)
+	if err != nil {
+		app.Session.Put(r.Context(), "error", fmt.Sprintf("Unable to start the broadcast: %s!", err))
+		http.Redirect(w, r, "/admin/mail/broadcasts", http.StatusSeeOther)
+		return
+	}
+	app.startBroadcast(b)
+
+	log.Info().
+		Int("broadcast", b.ID).
+		Str("template", b.Template).
+		Interface("segment", b.Segment).
+		Msg("broadcast is started")
+	app.Session.Put(r.Context(), "flash", fmt.Sprintf("Broadcast #%d is started.", b.ID))
+	http.Redirect(w, r, "/admin/mail/broadcasts", http.StatusSeeOther)
+}
+
+// CancelMailBroadcast stop queueing emails of the broadcast
+func (app *Server) CancelMailBroadcast(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse broadcast cancel form")
+		http.Redirect(w, r, "/admin/mail/broadcasts", http.StatusSeeOther)
+		return
+	}
+
+	id, err := strconv.Atoi(r.Form.Get("id"))
+	if err != nil {
+		app.Session.Put(r.Context(), "error", "Invalid broadcast id!")
+		http.Redirect(w, r, "/admin/mail/broadcasts", http.StatusSeeOther)
+		return
+	}
+	b, ok := app.Mail.Broadcasts.Get(id)
+	if !ok {
+		app.Session.Put(r.Context(), "error", "Broadcast not found!")
+		http.Redirect(w, r, "/admin/mail/broadcasts", http.StatusSeeOther)
+		return
+	}
+	if !b.Cancel() {
+		app.Session.Put(r.Context(), "error", "Broadcast is not running!")
+		http.Redirect(w, r, "/admin/mail/broadcasts", http.StatusSeeOther)
+		return
+	}
+
+	app.Session.Put(r.Context(), "flash", fmt.Sprintf("Broadcast #%d is canceled.", id))
+	http.Redirect(w, r, "/admin/mail/broadcasts", http.StatusSeeOther)
+}
+
+// MailBroadcastProgress return the progress of the broadcast as JSON,
+// the broadcasts page poll it while the broadcast is running
+func (app *Server) MailBroadcastProgress(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "invalid broadcast id", http.StatusBadRequest)
+		return
+	}
+	b, ok := app.Mail.Broadcasts.Get(id)
+	if !ok {
+		http.Error(w, "broadcast not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(b.Progress()); err != nil {
+		log.Error().Err(err).Int("broadcast", id).Msg("failed to write broadcast progress")
+	}
+}
+
+// broadcastTemplates return templates which can be broadcast,
+// translations are chosen for every recipient by the locale
+func (app *Server) broadcastTemplates() []string {
+	names := []string{}
+	for _, name := range app.Mail.Templates.Names() {
+		if mailTemplateBase(name) == name {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// broadcastFromForm validate the broadcast form, errors name the invalid field for the admin
+func (app *Server) broadcastFromForm(form url.Values) (*Broadcast, error) {
+	name := form.Get("template")
+	if mailTemplateBase(name) != name || !app.Mail.Templates.Has(name) {
+		return nil, fmt.Errorf("unknown email template %s", name)
+	}
+
+	category := form.Get("category")
+	if category == "" {
+		category = mailCategoryProduct
+	}
+	// broadcasts respect the choice of users, they can not be sent in mandatory categories
+	if c, ok := findMailCategory(category); !ok || c.Mandatory {
+		return nil, fmt.Errorf("invalid email category %s", category)
+	}
+
+	sample, err := parseSampleMailData(form.Get("data"))
+	if err != nil {
+		return nil, err
+	}
+
+	var segment BroadcastSegment
+	if plan := form.Get("plan"); plan != "" {
+		id, err := strconv.ParseInt(plan, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid plan %s", plan)
+		}
+		segment.PlanID = int32(id)
+	}
+	switch activity := form.Get("activity"); activity {
+	case "", segmentActive, segmentInactive:
+		segment.Activity = activity
+	default:
+		return nil, fmt.Errorf("invalid activity status %s", activity)
+	}
+	if segment.SignedUpAfter, err = parseSegmentDate(form.Get("signed_up_after")); err != nil {
+		return nil, err
+	}
+	if segment.SignedUpBefore, err = parseSegmentDate(form.Get("signed_up_before")); err != nil {
+		return nil, err
+	}
+
+	return &Broadcast{
+		Template: name,
+		Subject:  strings.TrimSpace(form.Get("subject")),
+		Category: category,
+		Data:     sample,
+		Segment:  segment,
+	}, nil
+}
+
+// parseSegmentDate parse the date of the date input, empty dates are zero time
+func parseSegmentDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	date, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid signup date %s", value)
+	}
+	return date, nil
+}
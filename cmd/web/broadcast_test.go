@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	mockdb "github.com/dubass83/go-concurrency-project/data/mock"
+	data "github.com/dubass83/go-concurrency-project/data/sqlc"
+	"github.com/dubass83/go-concurrency-project/utils"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunBroadcast(t *testing.T) {
+	// hashing the password is slow, recipients differ only by id and email
+	member := utils.RandomUser("Qw12345678!")
+	users := func(n int, firstID int32) []data.User {
+		result := make([]data.User, 0, n)
+		for i := range n {
+			user := member
+			user.ID = firstID + int32(i)
+			user.Email.String = fmt.Sprintf("user%d@example.com", user.ID)
+			result = append(result, user)
+		}
+		return result
+	}
+
+	broadcastTests := []struct {
+		name       string
+		buildStubs func(store *mockdb.MockStore, b *Broadcast)
+		expected   BroadcastProgress
+	}{
+		{
+			name: "finished",
+			buildStubs: func(store *mockdb.MockStore, b *Broadcast) {
+				store.EXPECT().
+					CountSegmentUsers(gomock.Any(), gomock.Any()).
+					Times(1).
+					DoAndReturn(func(_ any, arg data.CountSegmentUsersParams) (int64, error) {
+						require.Equal(t, int32(2), arg.PlanID.Int32)
+						require.True(t, arg.UserActive.Valid)
+						require.Equal(t, int32(1), arg.UserActive.Int32)
+						require.False(t, arg.SignedUpAfter.Valid)
+						return 2, nil
+					})
+
+				store.EXPECT().
+					GetSegmentUsers(gomock.Any(), gomock.Any()).
+					Times(1).
+					Return(users(2, 1), nil)
+
+				store.EXPECT().
+					EnqueueMailTx(gomock.Any(), gomock.Any()).
+					Times(2).
+					DoAndReturn(func(_ any, payloads [][]byte) (data.EnqueueMailTxResult, error) {
+						msg, err := messageFromOutbox(data.MailOutbox{Payload: payloads[0]})
+						require.NoError(t, err)
+						require.Equal(t, mailTemplateDefault, msg.Template)
+						require.Equal(t, mailCategoryProduct, msg.Category)
+						require.Equal(t, mailPriorityBulk, msg.Priority)
+						require.NotNil(t, msg.Recipient)
+						require.Equal(t, msg.To[0], msg.Recipient.Email)
+						return data.EnqueueMailTxResult{}, nil
+					})
+			},
+			expected: BroadcastProgress{Status: broadcastFinished, Total: 2, Queued: 2, Percent: 100},
+		},
+		{
+			name: "pages",
+			buildStubs: func(store *mockdb.MockStore, b *Broadcast) {
+				store.EXPECT().
+					CountSegmentUsers(gomock.Any(), gomock.Any()).
+					Times(1).
+					Return(int64(broadcastPageSize+1), nil)
+
+				gomock.InOrder(
+					store.EXPECT().
+						GetSegmentUsers(gomock.Any(), gomock.Any()).
+						DoAndReturn(func(_ any, arg data.GetSegmentUsersParams) ([]data.User, error) {
+							require.Equal(t, int32(0), arg.AfterID)
+							return users(broadcastPageSize, 1), nil
+						}),
+					store.EXPECT().
+						GetSegmentUsers(gomock.Any(), gomock.Any()).
+						DoAndReturn(func(_ any, arg data.GetSegmentUsersParams) ([]data.User, error) {
+							require.Equal(t, int32(broadcastPageSize), arg.AfterID)
+							return users(1, broadcastPageSize+1), nil
+						}),
+				)
+
+				store.EXPECT().
+					EnqueueMailTx(gomock.Any(), gomock.Any()).
+					Times(broadcastPageSize+1).
+					Return(data.EnqueueMailTxResult{}, nil)
+			},
+			expected: BroadcastProgress{Status: broadcastFinished, Total: broadcastPageSize + 1, Queued: broadcastPageSize + 1, Percent: 100},
+		},
+		{
+			name: "enqueueError",
+			buildStubs: func(store *mockdb.MockStore, b *Broadcast) {
+				store.EXPECT().
+					CountSegmentUsers(gomock.Any(), gomock.Any()).
+					Times(1).
+					Return(int64(2), nil)
+
+				store.EXPECT().
+					GetSegmentUsers(gomock.Any(), gomock.Any()).
+					Times(1).
+					Return(users(2, 1), nil)
+
+				gomock.InOrder(
+					store.EXPECT().
+						EnqueueMailTx(gomock.Any(), gomock.Any()).
+						Return(data.EnqueueMailTxResult{}, errors.New("connection refused")),
+					store.EXPECT().
+						EnqueueMailTx(gomock.Any(), gomock.Any()).
+						Return(data.EnqueueMailTxResult{}, nil),
+				)
+			},
+			expected: BroadcastProgress{Status: broadcastFinished, Total: 2, Queued: 1, Failed: 1, Percent: 100},
+		},
+		{
+			name: "countError",
+			buildStubs: func(store *mockdb.MockStore, b *Broadcast) {
+				store.EXPECT().
+					CountSegmentUsers(gomock.Any(), gomock.Any()).
+					Times(1).
+					Return(int64(0), errors.New("connection refused"))
+
+				store.EXPECT().
+					GetSegmentUsers(gomock.Any(), gomock.Any()).
+					Times(0)
+			},
+			expected: BroadcastProgress{Status: broadcastFailed},
+		},
+		{
+			name: "canceled",
+			buildStubs: func(store *mockdb.MockStore, b *Broadcast) {
+				store.EXPECT().
+					CountSegmentUsers(gomock.Any(), gomock.Any()).
+					Times(1).
+					Return(int64(3), nil)
+
+				store.EXPECT().
+					GetSegmentUsers(gomock.Any(), gomock.Any()).
+					Times(1).
+					Return(users(3, 1), nil)
+
+				// the admin cancel the broadcast after the first email
+				store.EXPECT().
+					EnqueueMailTx(gomock.Any(), gomock.Any()).
+					Times(1).
+					DoAndReturn(func(_ any, _ [][]byte) (data.EnqueueMailTxResult, error) {
+						require.True(t, b.Cancel())
+						return data.EnqueueMailTxResult{}, nil
+					})
+			},
+			expected: BroadcastProgress{Status: broadcastCanceled, Total: 3, Queued: 1, Percent: 33},
+		},
+	}
+
+	for _, bt := range broadcastTests {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := mockdb.NewMockStore(ctrl)
+		app := Server{
+			Store: store,
+			Wait:  &sync.WaitGroup{},
+			Mail: Mail{
+				OutboxChan: make(chan struct{}, 1),
+				Broadcasts: NewBroadcasts(),
+			},
+		}
+
+		b := &Broadcast{
+			Template: mailTemplateDefault,
+			Category: mailCategoryProduct,
+			Data:     "News",
+			Segment: BroadcastSegment{
+				PlanID:   2,
+				Activity: segmentActive,
+			},
+		}
+		bt.buildStubs(store, b)
+
+		app.startBroadcast(b)
+		app.Wait.Wait()
+
+		progress := b.Progress()
+		require.Equal(t, bt.expected.Status, progress.Status, fmt.Sprintf("test name: %s", bt.name))
+		require.Equal(t, bt.expected.Total, progress.Total, fmt.Sprintf("test name: %s", bt.name))
+		require.Equal(t, bt.expected.Queued, progress.Queued, fmt.Sprintf("test name: %s", bt.name))
+		require.Equal(t, bt.expected.Failed, progress.Failed, fmt.Sprintf("test name: %s", bt.name))
+		require.Equal(t, bt.expected.Percent, progress.Percent, fmt.Sprintf("test name: %s", bt.name))
+		require.False(t, progress.FinishedAt.IsZero(), fmt.Sprintf("test name: %s", bt.name))
+		require.False(t, b.Cancel(), "finished broadcast can not be canceled")
+	}
+}
+
+func TestBroadcastFromForm(t *testing.T) {
+	formTests := []struct {
+		name     string
+		form     url.Values
+		hasError bool
+		check    func(b *Broadcast)
+	}{
+		{
+			name: "segment",
+			form: url.Values{
+				"template":         {mailTemplateDefault},
+				"subject":          {" Gold plan changes "},
+				"category":         {mailCategoryBilling},
+				"data":             {`"Prices are changing"`},
+				"plan":             {"3"},
+				"activity":         {segmentInactive},
+				"signed_up_after":  {"2024-01-01"},
+				"signed_up_before": {"2024-02-01"},
+			},
+			check: func(b *Broadcast) {
+				require.Equal(t, "Gold plan changes", b.Subject)
+				require.Equal(t, mailCategoryBilling, b.Category)
+				require.Equal(t, "Prices are changing", b.Data)
+				require.Equal(t, int32(3), b.Segment.PlanID)
+				require.Equal(t, segmentInactive, b.Segment.Activity)
+				require.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), b.Segment.SignedUpAfter)
+
+				arg := b.Segment.pageParams(10)
+				require.Equal(t, int32(10), arg.AfterID)
+				require.True(t, arg.UserActive.Valid)
+				require.Equal(t, int32(0), arg.UserActive.Int32)
+				require.True(t, arg.SignedUpBefore.Valid)
+			},
+		},
+		{
+			name: "everybody",
+			form: url.Values{
+				"template": {mailTemplateDefault},
+			},
+			check: func(b *Broadcast) {
+				require.Equal(t, mailCategoryProduct, b.Category)
+
+				arg := b.Segment.countParams()
+				require.False(t, arg.PlanID.Valid)
+				require.False(t, arg.UserActive.Valid)
+				require.False(t, arg.SignedUpAfter.Valid)
+				require.False(t, arg.SignedUpBefore.Valid)
+			},
+		},
+		{
+			name:     "unknownTemplate",
+			form:     url.Values{"template": {"missing"}},
+			hasError: true,
+		},
+		{
+			name:     "translation",
+			form:     url.Values{"template": {mailTemplateInvoice + ".uk"}},
+			hasError: true,
+		},
+		{
+			name: "mandatoryCategory",
+			form: url.Values{
+				"template": {mailTemplateDefault},
+				"category": {mailCategorySecurity},
+			},
+			hasError: true,
+		},
+		{
+			name: "invalidData",
+			form: url.Values{
+				"template": {mailTemplateDefault},
+				"data":     {`{"message": `},
+			},
+			hasError: true,
+		},
+		{
+			name: "invalidDate",
+			form: url.Values{
+				"template":        {mailTemplateDefault},
+				"signed_up_after": {"01/02/2024"},
+			},
+			hasError: true,
+		},
+	}
+
+	for _, ft := range formTests {
+		b, err := testApp.broadcastFromForm(ft.form)
+		if ft.hasError {
+			require.Error(t, err, fmt.Sprintf("test name: %s", ft.name))
+			continue
+		}
+		require.NoError(t, err, fmt.Sprintf("test name: %s", ft.name))
+		ft.check(b)
+	}
+}
+
+func TestBroadcastsList(t *testing.T) {
+	bs := NewBroadcasts()
+	first := &Broadcast{Template: "first", status: broadcastRunning, cancel: func() {}}
+	second := &Broadcast{Template: "second", status: broadcastFinished}
+	bs.add(first)
+	bs.add(second)
+
+	list := bs.List()
+	require.Len(t, list, 2)
+	require.Equal(t, "second", list[0].Template, "the latest broadcast go first")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first.cancel = cancel
+	bs.CancelAll()
+	require.Error(t, ctx.Err(), "running broadcasts must be canceled")
+
+	_, ok := bs.Get(3)
+	require.False(t, ok)
+}
+
+func TestBroadcastRecipientTemplate(t *testing.T) {
+	builder := newMsgBuilder(testApp.Config, testApp.Mail.Templates)
+
+	b := &Broadcast{
+		Template: mailTemplateDefault,
+		Subject:  "Gold plan changes",
+		Data:     "Prices are changing",
+	}
+	member := utils.RandomUser("Qw12345678!")
+	_, plain, html, err := builder.prepare(b.message(member))
+	require.NoError(t, err)
+	require.Contains(t, plain, fmt.Sprintf("Hi %s,", member.FirstName.String))
+	require.Contains(t, html, fmt.Sprintf("Hi %s,", member.FirstName.String))
+	require.Contains(t, plain, "Prices are changing")
+
+	// messages without the recipient are not personalized
+	_, plain, _, err = builder.prepare(Message{
+		To:       []string{member.Email.String},
+		Subject:  "Hello",
+		Template: mailTemplateDefault,
+		Data:     "Prices are changing",
+	})
+	require.NoError(t, err)
+	require.NotContains(t, plain, "Hi ")
+}
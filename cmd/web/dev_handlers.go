@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+type formatedCapturedMail struct {
+	ID          int       `json:"id"`
+	SentAt      time.Time `json:"sent_at"`
+	From        string    `json:"from"`
+	To          string    `json:"to"`
+	Subject     string    `json:"subject"`
+	Template    string    `json:"template"`
+	Plain       string    `json:"plain"`
+	Attachments string    `json:"attachments"`
+}
+
+// devMode report if development only pages are available
+func (app *Server) devMode() bool {
+	return app.Config.Enviroment == "devel" || app.Config.Enviroment == "tests"
+}
+
+func (app *Server) Mailbox(w http.ResponseWriter, r *http.Request) {
+	dataMap := make(map[string]any)
+	dataMap["service"] = app.Config.EmailService
+	dataMap["enabled"] = app.Mail.Mailbox != nil
+	if app.Mail.Mailbox != nil {
+		dataMap["messages"] = capturedMailFormatted(app.Mail.Mailbox.Messages())
+	}
+
+	app.render(w, r, "mailbox.page.gohtml", &TemplateData{
+		DataMap: dataMap,
+	})
+}
+
+// MailboxMessage show the html body of the captured message
+// or the whole message as an .eml file with format=raw
+func (app *Server) MailboxMessage(w http.ResponseWriter, r *http.Request) {
+	if app.Mail.Mailbox == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "invalid message id", http.StatusBadRequest)
+		return
+	}
+
+	captured, ok := app.Mail.Mailbox.Message(id)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "raw" {
+		w.Header().Set("Content-Type", "message/rfc822")
+		w.Header().Set("Content-Disposition", "attachment; filename=message-"+strconv.Itoa(id)+".eml")
+		_, _ = w.Write(captured.Raw)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write([]byte(captured.HTML))
+}
+
+func (app *Server) ClearMailbox(w http.ResponseWriter, r *http.Request) {
+	if app.Mail.Mailbox != nil {
+		app.Mail.Mailbox.Clear()
+	}
+	app.Session.Put(r.Context(), "flash", "Mailbox is empty.")
+	http.Redirect(w, r, "/dev/mailbox", http.StatusSeeOther)
+}
+
+// SimulateMailEvent post a bounce or complaint of the captured message to the mail webhook,
+// it stands in for the mail provider in development
+func (app *Server) SimulateMailEvent(w http.ResponseWriter, r *http.Request) {
+	if app.Mail.Mailbox == nil {
+		http.NotFound(w, r)
+		return
+	}
+	err := r.ParseForm()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse mail event form")
+		http.Redirect(w, r, "/dev/mailbox", http.StatusSeeOther)
+		return
+	}
+
+	if app.Config.MailWebhookSecret == "" {
+		// the webhook is disabled until the secret is configured
+		app.Session.Put(r.Context(), "error", "Set MAIL_WEBHOOK_SECRET to simulate mail provider events.")
+		http.Redirect(w, r, "/dev/mailbox", http.StatusSeeOther)
+		return
+	}
+
+	id, err := strconv.Atoi(r.Form.Get("id"))
+	if err != nil {
+		http.Error(w, "invalid message id", http.StatusBadRequest)
+		return
+	}
+	captured, ok := app.Mail.Mailbox.Message(id)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	eventType := r.Form.Get("type")
+	if eventType != mailWebhookBounce && eventType != mailWebhookComplaint {
+		http.Error(w, "invalid event type", http.StatusBadRequest)
+		return
+	}
+
+	recipients := append(append(append([]string{}, captured.To...), captured.CC...), captured.BCC...)
+	payload := sampleMailWebhook(eventType, captured.MessageID, recipients...)
+	url := fmt.Sprintf("http://localhost:%s/webhooks/mail", app.Config.WebPort)
+	if err := postMailWebhook(url, app.Config.MailWebhookSecret, payload); err != nil {
+		log.Error().Err(err).Int("id", id).Msg("failed to post mail webhook")
+		app.Session.Put(r.Context(), "error", fmt.Sprintf("Unable to post the %s: %s", eventType, err))
+		http.Redirect(w, r, "/dev/mailbox", http.StatusSeeOther)
+		return
+	}
+
+	app.Session.Put(r.Context(), "flash", fmt.Sprintf("The %s of %s is posted.", eventType, strings.Join(recipients, ", ")))
+	http.Redirect(w, r, "/dev/mailbox", http.StatusSeeOther)
+}
+
+func capturedMailFormatted(messages []CapturedMail) []formatedCapturedMail {
+	result := []formatedCapturedMail{}
+	for _, captured := range messages {
+		result = append(result, formatedCapturedMail{
+			ID:          captured.ID,
+			SentAt:      captured.SentAt,
+			From:        captured.From,
+			To:          strings.Join(captured.To, ", "),
+			Subject:     captured.Subject,
+			Template:    captured.Template,
+			Plain:       captured.Plain,
+			Attachments: strings.Join(captured.Attachments, ", "),
+		})
+	}
+	return result
+}
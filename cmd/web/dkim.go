@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dubass83/go-concurrency-project/utils"
+	"github.com/wneessen/go-mail"
+)
+
+const dkimHeader = "DKIM-Signature"
+
+// dkimSignedHeaders are signed when they are present in the message
+var dkimSignedHeaders = []string{
+	"From",
+	"To",
+	"Cc",
+	"Subject",
+	"Date",
+	"Message-ID",
+	"MIME-Version",
+	"Content-Type",
+	"List-Unsubscribe",
+	"List-Unsubscribe-Post",
+}
+
+// DKIMSigner sign messages with rsa-sha256 and relaxed/relaxed canonicalization (RFC 6376)
+type DKIMSigner struct {
+	Domain   string
+	Selector string
+	key      *rsa.PrivateKey
+	now      func() time.Time
+}
+
+// newDKIMSigner load the private key from DKIM_PRIVATE_KEY_FILE,
+// it return nil when signing is not configured
+func newDKIMSigner(conf utils.Config) (*DKIMSigner, error) {
+	if conf.DKIMPrivateKeyFile == "" {
+		return nil, nil
+	}
+
+	pemKey, err := os.ReadFile(conf.DKIMPrivateKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dkim private key: %s", err)
+	}
+
+	domain := conf.DKIMDomain
+	if domain == "" {
+		if i := strings.LastIndex(conf.SenderEmail, "@"); i >= 0 {
+			domain = conf.SenderEmail[i+1:]
+		}
+	}
+	return NewDKIMSigner(domain, conf.DKIMSelector, pemKey)
+}
+
+// NewDKIMSigner create a signer from the PEM encoded PKCS#1 or PKCS#8 rsa private key
+func NewDKIMSigner(domain, selector string, pemKey []byte) (*DKIMSigner, error) {
+	if domain == "" || selector == "" {
+		return nil, fmt.Errorf("dkim domain and selector must be set")
+	}
+
+	block, _ := pem.Decode(pemKey)
+	if block == nil {
+		return nil, fmt.Errorf("dkim private key is not PEM encoded")
+	}
+
+	var key *rsa.PrivateKey
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		k, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse dkim private key: %s", err)
+		}
+		key = k
+	case "PRIVATE KEY":
+		k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse dkim private key: %s", err)
+		}
+		rsaKey, ok := k.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("dkim private key must be an rsa key")
+		}
+		key = rsaKey
+	default:
+		return nil, fmt.Errorf("unsupported dkim private key type: %s", block.Type)
+	}
+
+	return &DKIMSigner{
+		Domain:   domain,
+		Selector: selector,
+		key:      key,
+		now:      time.Now,
+	}, nil
+}
+
+// Sign add the DKIM-Signature header to the message. The message is written
+// once to compute the signature, go-mail keep the boundaries, date and
+// message id, so the message is written the same way when it is sent
+func (s *DKIMSigner) Sign(m *mail.Msg) error {
+	var raw bytes.Buffer
+	if _, err := m.WriteTo(&raw); err != nil {
+		return fmt.Errorf("failed to write message for dkim signing: %s", err)
+	}
+
+	signature, err := s.signature(raw.Bytes())
+	if err != nil {
+		return err
+	}
+	m.SetGenHeaderPreformatted(mail.Header(dkimHeader), signature)
+	return nil
+}
+
+// signature return the value of the DKIM-Signature header for the raw message
+func (s *DKIMSigner) signature(raw []byte) (string, error) {
+	headers, body := splitMessage(raw)
+
+	bodyHash := sha256.Sum256(relaxedBody(body))
+
+	var signed []string
+	var hashed strings.Builder
+	for _, name := range dkimSignedHeaders {
+		value, ok := findHeader(headers, name)
+		if !ok {
+			continue
+		}
+		signed = append(signed, strings.ToLower(name))
+		hashed.WriteString(relaxedHeader(name, value))
+	}
+
+	value := fmt.Sprintf("v=1; a=rsa-sha256; c=relaxed/relaxed; d=%s; s=%s; t=%d; h=%s; bh=%s; b=",
+		s.Domain,
+		s.Selector,
+		s.now().Unix(),
+		strings.Join(signed, ":"),
+		base64.StdEncoding.EncodeToString(bodyHash[:]),
+	)
+	// the signature header itself is signed without the trailing CRLF
+	hashed.WriteString(strings.TrimSuffix(relaxedHeader(dkimHeader, value), "\r\n"))
+
+	digest := sha256.Sum256([]byte(hashed.String()))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign message: %s", err)
+	}
+
+	return value + base64.StdEncoding.EncodeToString(sig), nil
+}
+
+// splitMessage return unfolded header fields and the body of the raw message
+func splitMessage(raw []byte) ([]string, []byte) {
+	head, body, found := bytes.Cut(raw, []byte("\r\n\r\n"))
+	if !found {
+		head, body = raw, nil
+	}
+
+	var headers []string
+	for _, line := range strings.Split(string(head), "\r\n") {
+		if len(headers) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			headers[len(headers)-1] += "\r\n" + line
+			continue
+		}
+		headers = append(headers, line)
+	}
+	return headers, body
+}
+
+// findHeader return the value of the last header field with the name
+func findHeader(headers []string, name string) (string, bool) {
+	for i := len(headers) - 1; i >= 0; i-- {
+		key, value, ok := strings.Cut(headers[i], ":")
+		if ok && strings.EqualFold(strings.TrimSpace(key), name) {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// relaxedHeader canonicalize the header field with the relaxed algorithm
+func relaxedHeader(name, value string) string {
+	value = strings.ReplaceAll(value, "\r\n", "")
+	value = strings.Join(strings.Fields(value), " ")
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + value + "\r\n"
+}
+
+// relaxedBody canonicalize the body with the relaxed algorithm
+func relaxedBody(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	for i, line := range lines {
+		line = strings.Map(func(r rune) rune {
+			if r == '\t' {
+				return ' '
+			}
+			return r
+		}, line)
+		for strings.Contains(line, "  ") {
+			line = strings.ReplaceAll(line, "  ", " ")
+		}
+		lines[i] = strings.TrimRight(line, " ")
+	}
+	// ignore all empty lines at the end of the body
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dubass83/go-concurrency-project/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDKIMCanonicalization(t *testing.T) {
+	// example from RFC 6376 section 3.4.6
+	headers, body := splitMessage([]byte("A: X\r\nB : Y\t\r\n\tZ  \r\n\r\n C \r\nD \t E\r\n\r\n\r\n"))
+	require.Len(t, headers, 2)
+
+	a, ok := findHeader(headers, "a")
+	require.True(t, ok)
+	b, ok := findHeader(headers, "B")
+	require.True(t, ok)
+
+	require.Equal(t, "a:X\r\n", relaxedHeader("A", a))
+	require.Equal(t, "b:Y Z\r\n", relaxedHeader("B ", b))
+	require.Equal(t, " C\r\nD E\r\n", string(relaxedBody(body)))
+	require.Empty(t, relaxedBody([]byte("\r\n\r\n")))
+}
+
+func TestDKIMSigner(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	keyFile := filepath.Join(t.TempDir(), "dkim.pem")
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	}), 0600)
+	require.NoError(t, err)
+
+	conf := testApp.Config
+	conf.EmailService = "memory"
+	conf.DKIMPrivateKeyFile = keyFile
+	conf.DKIMSelector = "mail"
+	conf.DKIMDomain = ""
+
+	sender, err := NewMailSender(conf, testApp.Mail.Templates, nil)
+	require.NoError(t, err)
+	mailbox := sender.(*MemorySender)
+	require.NotNil(t, mailbox.DKIM)
+	require.Equal(t, "dubass83.xyz", mailbox.DKIM.Domain, "domain of the sender is used by default")
+
+	err = mailbox.SendEmail(Message{
+		To:        []string{"user@example.com", "admin@example.com"},
+		Subject:   "Activate your account",
+		Template:  mailTemplateConfirmation,
+		Data:      "http://localhost:8080/activate?email=user@example.com",
+		MessageID: "5b0e3c1a",
+		Attachments: []Attachment{
+			{Name: "Manual.pdf", Data: []byte("%PDF-1.3")},
+		},
+	})
+	require.NoError(t, err)
+
+	raw := string(mailbox.Messages()[0].Raw)
+	require.Contains(t, raw, "DKIM-Signature: v=1; a=rsa-sha256; c=relaxed/relaxed; d=dubass83.xyz; s=mail;")
+	require.NoError(t, verifyDKIM(raw, &key.PublicKey))
+
+	tampered := strings.Replace(raw, "Subject: Activate your account", "Subject: Activate your acount", 1)
+	require.Error(t, verifyDKIM(tampered, &key.PublicKey), "signed header is changed")
+
+	tampered = strings.Replace(raw, "Thank you for registering", "Thank you for registering!", 1)
+	require.Error(t, verifyDKIM(tampered, &key.PublicKey), "body is changed")
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	require.Error(t, verifyDKIM(raw, &other.PublicKey), "signed by another key")
+}
+
+func TestNewDKIMSigner(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	require.NoError(t, err)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ecPKCS8, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	require.NoError(t, err)
+
+	dkimSignerTests := []struct {
+		name          string
+		domain        string
+		selector      string
+		key           []byte
+		expectedError bool
+	}{
+		{
+			name:     "pkcs8",
+			domain:   "example.com",
+			selector: "mail",
+			key:      pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}),
+		},
+		{
+			name:          "notRSA",
+			domain:        "example.com",
+			selector:      "mail",
+			key:           pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: ecPKCS8}),
+			expectedError: true,
+		},
+		{
+			name:          "notPEM",
+			domain:        "example.com",
+			selector:      "mail",
+			key:           []byte("not a key"),
+			expectedError: true,
+		},
+		{
+			name:          "missingSelector",
+			domain:        "example.com",
+			key:           pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}),
+			expectedError: true,
+		},
+	}
+
+	for _, dt := range dkimSignerTests {
+		_, err := NewDKIMSigner(dt.domain, dt.selector, dt.key)
+		if dt.expectedError {
+			require.Error(t, err, fmt.Sprintf("test name: %s", dt.name))
+			continue
+		}
+		require.NoError(t, err, fmt.Sprintf("test name: %s", dt.name))
+	}
+
+	signer, err := newDKIMSigner(utils.Config{})
+	require.NoError(t, err)
+	require.Nil(t, signer, "signing is disabled without the key")
+}
+
+// verifyDKIM check the DKIM-Signature of the raw message like a receiving server
+func verifyDKIM(raw string, key *rsa.PublicKey) error {
+	headers, body := splitMessage([]byte(raw))
+
+	value, ok := findHeader(headers, dkimHeader)
+	if !ok {
+		return fmt.Errorf("message is not signed")
+	}
+
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(value, ";") {
+		k, v, _ := strings.Cut(tag, "=")
+		tags[strings.TrimSpace(k)] = strings.Join(strings.Fields(v), "")
+	}
+
+	bodyHash := sha256.Sum256(relaxedBody(body))
+	if base64.StdEncoding.EncodeToString(bodyHash[:]) != tags["bh"] {
+		return fmt.Errorf("body hash does not match")
+	}
+
+	var hashed strings.Builder
+	for _, name := range strings.Split(tags["h"], ":") {
+		v, _ := findHeader(headers, name)
+		hashed.WriteString(relaxedHeader(name, v))
+	}
+	unsigned := value[:strings.LastIndex(value, "b=")+2]
+	hashed.WriteString(strings.TrimSuffix(relaxedHeader(dkimHeader, unsigned), "\r\n"))
+
+	sig, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256([]byte(hashed.String()))
+	return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig)
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"html/template"
 	"net/http"
@@ -10,6 +12,7 @@ import (
 
 	data "github.com/dubass83/go-concurrency-project/data/sqlc"
 	"github.com/dubass83/go-concurrency-project/utils"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/phpdave11/gofpdf"
 	"github.com/phpdave11/gofpdf/contrib/gofpdi"
@@ -67,13 +70,15 @@ func (app *Server) PostLoginPage(w http.ResponseWriter, r *http.Request) {
 	err = utils.CheckPassword(password, user.Password.String)
 	if err != nil {
 		log.Error().Err(err).Msg("invalid credentials")
-		// send messages asynchronously with channels
+		// store message in the mail outbox, it will be sent asynchronously
 		msg := Message{
-			To:      []string{email},
-			Subject: "Failed log in attempt",
-			Data:    "invalid login attempt!",
+			To:       []string{email},
+			Template: mailTemplateFailedLogin,
+			Locale:   user.Locale,
+		}
+		if err := app.enqueueMail(r.Context(), msg); err != nil {
+			log.Error().Err(err).Msg("failed to enqueue failed log in email")
 		}
-		app.Mail.MailerChan <- msg
 		app.Session.Put(r.Context(), "error", "invalid credentials")
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
@@ -107,7 +112,11 @@ func (app *Server) Logout(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Server) RegisterPage(w http.ResponseWriter, r *http.Request) {
-	app.render(w, r, "register.page.gohtml", nil)
+	app.render(w, r, "register.page.gohtml", &TemplateData{
+		DataMap: map[string]any{
+			"locales": supportedLocales,
+		},
+	})
 }
 
 func (app *Server) PostRegisterPage(w http.ResponseWriter, r *http.Request) {
@@ -121,48 +130,58 @@ func (app *Server) PostRegisterPage(w http.ResponseWriter, r *http.Request) {
 		log.Error().Err(err).Msg("failed to generate hash for a new user password from the request")
 	}
 
-	arg := data.InsertUserParams{
-		Email: pgtype.Text{
-			String: r.Form.Get("email"),
-			Valid:  true,
-		},
-		FirstName: pgtype.Text{
-			String: r.Form.Get("first-name"),
-			Valid:  true,
-		},
-		LastName: pgtype.Text{
-			String: r.Form.Get("last-name"),
-			Valid:  true,
-		},
-		Password: pgtype.Text{
-			String: HashPass,
-			Valid:  true,
-		},
-		UserActive: pgtype.Int4{
-			Int32: 0,
-			Valid: true,
-		},
+	locale := requestLocale(r)
+
+	// prepare activation email, it is stored together with the new user
+	msg, err := app.activationMessage(r, r.Form.Get("email"), locale)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to issue activation token")
+		app.Session.Put(r.Context(), "error", "Unable to create user.")
+		http.Redirect(w, r, "/register", http.StatusSeeOther)
+		return
 	}
-	_, err = app.Store.InsertUser(context.Background(), arg)
+	outbox, err := outboxPayload(msg)
 	if err != nil {
-		log.Error().Err(err).Msg("failed insert a user to the database")
+		log.Error().Err(err).Msg("failed to prepare activation email")
 		app.Session.Put(r.Context(), "error", "Unable to create user.")
 		http.Redirect(w, r, "/register", http.StatusSeeOther)
+		return
 	}
 
-	// send activation email
-	url := fmt.Sprintf("http://localhost:%s/activate?email=%s", app.Config.WebPort, r.Form.Get("email"))
-	signedURL := app.GenerateTokenFromString(url)
-	log.Info().Msg(signedURL)
-
-	msg := Message{
-		To:       []string{r.Form.Get("email")},
-		Subject:  "Activate your account",
-		Template: "confirmation-email",
-		Data:     template.HTML(signedURL),
+	arg := data.InsertUserTxParams{
+		InsertUserParams: data.InsertUserParams{
+			Email: pgtype.Text{
+				String: r.Form.Get("email"),
+				Valid:  true,
+			},
+			FirstName: pgtype.Text{
+				String: r.Form.Get("first-name"),
+				Valid:  true,
+			},
+			LastName: pgtype.Text{
+				String: r.Form.Get("last-name"),
+				Valid:  true,
+			},
+			Password: pgtype.Text{
+				String: HashPass,
+				Valid:  true,
+			},
+			UserActive: pgtype.Int4{
+				Int32: 0,
+				Valid: true,
+			},
+			Locale: locale,
+		},
+		Outbox: outbox,
 	}
-
-	app.Mail.MailerChan <- msg
+	_, err = app.Store.InsertUserTx(context.Background(), arg)
+	if err != nil {
+		log.Error().Err(err).Msg("failed insert a user to the database")
+		app.Session.Put(r.Context(), "error", "Unable to create user.")
+		http.Redirect(w, r, "/register", http.StatusSeeOther)
+		return
+	}
+	app.wakeOutbox()
 
 	// redirect user to the login page
 	app.Session.Put(r.Context(), "flash", "Confirmation email sent. Check your email.")
@@ -171,19 +190,24 @@ func (app *Server) PostRegisterPage(w http.ResponseWriter, r *http.Request) {
 
 func (app *Server) ActivateAccount(w http.ResponseWriter, r *http.Request) {
 	// verify token from URL
-	url := r.RequestURI
-	testURL := fmt.Sprintf("http://localhost:%s%s", app.Config.WebPort, url)
-	log.Info().Msg(testURL)
-	ok := app.VerifyToken(testURL)
-
-	if !ok {
+	token := r.URL.Query().Get("token")
+	claims, err := app.Tokens.Check(tokenActivation, token)
+	if errors.Is(err, errTokenExpired) {
+		app.render(w, r, "activation-expired.page.gohtml", &TemplateData{
+			StringMap: map[string]string{
+				"email": claims.Subject,
+			},
+		})
+		return
+	}
+	if err != nil {
 		app.Session.Put(r.Context(), "error", "Invalid token.")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 	// Make user Active
 	argEmaill := pgtype.Text{
-		String: r.URL.Query().Get("email"),
+		String: claims.Subject,
 		Valid:  true,
 	}
 
@@ -193,6 +217,25 @@ func (app *Server) ActivateAccount(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
+	if u.UserActive.Int32 == 1 {
+		app.Session.Put(r.Context(), "flash", "Acount is already active. You can login to your account.")
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	// the link can be used only once
+	_, err = app.Tokens.Consume(r.Context(), tokenActivation, token)
+	if errors.Is(err, errTokenUsed) {
+		app.Session.Put(r.Context(), "error", "The activation link is already used.")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Int32("user_id", u.ID).Msg("failed to consume activation token")
+		app.Session.Put(r.Context(), "error", "Unable to activate the account, try again later.")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
 
 	argUpdate := data.UpdateUserParams{
 		ID: u.ID,
@@ -213,18 +256,82 @@ func (app *Server) ActivateAccount(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
 }
 
-func (app *Server) SendTestEmail(w http.ResponseWriter, r *http.Request) {
+const defaultActivationTTL = 24 * time.Hour
 
-	email := Message{
-		From:      "Maks",
-		FromEmail: "dubass@test.work",
-		Subject:   "test message",
-		To:        []string{"boloto@test.com", "sasas@test.com"},
-		Data:      "Hello world",
+// activationTTL return how long activation links are valid
+func (app *Server) activationTTL() time.Duration {
+	if app.Config.ActivationTTL <= 0 {
+		return defaultActivationTTL
 	}
+	return app.Config.ActivationTTL
+}
 
-	app.Mail.Sender.SendEmail(email, app.Mail.ErrChan)
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+// activationMessage return the email with the single-use activation link of the account
+func (app *Server) activationMessage(r *http.Request, email, locale string) (Message, error) {
+	token, err := app.Tokens.Issue(tokenActivation, email, app.activationTTL())
+	if err != nil {
+		return Message{}, err
+	}
+	link := app.URLs.Link(r, "/activate?token="+token)
+
+	return Message{
+		To:       []string{email},
+		Template: mailTemplateConfirmation,
+		Locale:   locale,
+		Data:     template.HTML(link),
+	}, nil
+}
+
+func (app *Server) ResendActivationPage(w http.ResponseWriter, r *http.Request) {
+	app.render(w, r, "activation-resend.page.gohtml", &TemplateData{
+		StringMap: map[string]string{
+			"email": r.URL.Query().Get("email"),
+		},
+	})
+}
+
+// PostResendActivation send a fresh activation link to inactive accounts. The reply is
+// the same for unknown and active accounts, so the form can not be used to look up users
+func (app *Server) PostResendActivation(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse the form from the request")
+		http.Redirect(w, r, "/activate/resend", http.StatusSeeOther)
+		return
+	}
+
+	email := r.Form.Get("email")
+	if email == "" {
+		app.Session.Put(r.Context(), "error", "Email is required.")
+		http.Redirect(w, r, "/activate/resend", http.StatusSeeOther)
+		return
+	}
+
+	u, err := app.Store.GetUserByEmail(r.Context(), pgtype.Text{
+		String: email,
+		Valid:  true,
+	})
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		log.Error().Err(err).Msg("failed to get user for the activation link")
+		app.Session.Put(r.Context(), "error", "Unable to send the activation link.")
+		http.Redirect(w, r, "/activate/resend", http.StatusSeeOther)
+		return
+	}
+	if err == nil && u.UserActive.Int32 == 0 {
+		msg, err := app.activationMessage(r, u.Email.String, u.Locale)
+		if err == nil {
+			err = app.enqueueMail(r.Context(), msg)
+		}
+		if err != nil {
+			log.Error().Err(err).Int32("user_id", u.ID).Msg("failed to queue activation email")
+			app.Session.Put(r.Context(), "error", "Unable to send the activation link.")
+			http.Redirect(w, r, "/activate/resend", http.StatusSeeOther)
+			return
+		}
+	}
+
+	app.Session.Put(r.Context(), "flash", "If the account is waiting for activation, a new link is sent. Check your email.")
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
 }
 
 func (app *Server) ChooseSubscription(w http.ResponseWriter, r *http.Request) {
@@ -285,62 +392,71 @@ func (app *Server) SubscribeToPlan(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	app.Wait.Add(1)
-
-	go func() {
-		defer app.Wait.Done()
-
-		invoice, err := app.getInvoice(&plan)
-		if err != nil {
-			app.ErrChan <- err
-		}
-
-		msg := Message{
-			To:       []string{user.Email.String},
-			Subject:  "Yuor invoice",
-			Template: "invoice",
-			Data:     invoice,
-		}
+	// invoice email is stored together with the subscription
+	invoice, err := app.getInvoice(&plan)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get invoice")
+		app.Session.Put(r.Context(), "error", "Unable to subscribe to the plan!")
+		http.Redirect(w, r, "/members/plans", http.StatusSeeOther)
+		return
+	}
+	outbox, err := outboxPayload(Message{
+		To:       []string{user.Email.String},
+		Template: mailTemplateInvoice,
+		Locale:   user.Locale,
+		Data:     invoice,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare invoice email")
+		app.Session.Put(r.Context(), "error", "Unable to subscribe to the plan!")
+		http.Redirect(w, r, "/members/plans", http.StatusSeeOther)
+		return
+	}
 
-		app.Mail.MailerChan <- msg
-	}()
+	// subscribe user to the choosen plan
+	arg := data.SubscribeUserToPlanParams{
+		UserID: user.ID,
+		PlanID: plan.ID,
+		Outbox: outbox,
+	}
+	result, err := app.Store.SubscribeUserToPlan(context.TODO(), arg)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to subscribe user to plan")
+		app.Session.Put(r.Context(), "error", "Unable to subscribe to the plan!")
+		http.Redirect(w, r, "/members/plans", http.StatusSeeOther)
+		return
+	}
+	app.wakeOutbox()
 
 	app.Wait.Add(1)
 	go func() {
 		defer app.Wait.Done()
 
+		// the manual is written straight into the email, nothing is left on the disk
+		var manual bytes.Buffer
 		pdf := app.generateManual(user, &plan)
-		err := pdf.OutputFileAndClose(fmt.Sprintf("%s/%d_user_manual.pdf", app.Config.PathToTmp, user.ID))
-		if err != nil {
+		if err := pdf.Output(&manual); err != nil {
 			app.ErrChan <- err
 			return
 		}
 
 		msg := Message{
-			To:      []string{user.Email.String},
-			Subject: "Yuor manual",
-			Data:    "Your user manual is attached",
-			AttachmentMap: map[string]string{
-				"Manual.pdf": fmt.Sprintf("%s/%d_user_manual.pdf", app.Config.PathToTmp, user.ID),
+			To:       []string{user.Email.String},
+			Template: mailTemplateManual,
+			Locale:   user.Locale,
+			Attachments: []Attachment{
+				{
+					Name:        "Manual.pdf",
+					ContentType: "application/pdf",
+					Reader:      &manual,
+				},
 			},
 		}
-		app.Mail.MailerChan <- msg
-
+		if err := app.enqueueMail(context.Background(), msg); err != nil {
+			app.ErrChan <- err
+		}
 	}()
 
-	// subscribe user to the choosen plan
-	arg := data.SubscribeUserToPlanParams{
-		UserID: user.ID,
-		PlanID: plan.ID,
-	}
-	result, err := app.Store.SubscribeUserToPlan(context.TODO(), arg)
-	if err != nil {
-		log.Error().Err(err).Msg("failed to subscribe user to plan")
-		app.Session.Put(r.Context(), "error", "Unable to subscribe to the plan!")
-		http.Redirect(w, r, "/members/plans", http.StatusSeeOther)
-		return
-	}
-
 	app.Session.Put(r.Context(), "user-plan", result.UserPlan)
 
 	app.Session.Put(r.Context(), "flash", "Subscribed!")
@@ -1,17 +1,20 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	mockdb "github.com/dubass83/go-concurrency-project/data/mock"
 	data "github.com/dubass83/go-concurrency-project/data/sqlc"
 	"github.com/dubass83/go-concurrency-project/utils"
 	"github.com/golang/mock/gomock"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/stretchr/testify/require"
 )
@@ -124,6 +127,60 @@ func TestPostHandler(t *testing.T) {
 					Return(userPlan, nil)
 			},
 		},
+		{
+			name:               "loginPageWrongPassword",
+			url:                "/login",
+			expectedStatusCode: http.StatusSeeOther,
+			expectedSessionKey: "error",
+			handler:            testApp.PostLoginPage,
+			postedData: url.Values{
+				"email":    {user.Email.String},
+				"password": {"wrong-password"},
+			},
+			buildStubs: func(store *mockdb.MockStore) {
+				argUser := pgtype.Text{
+					String: user.Email.String,
+					Valid:  true,
+				}
+				store.EXPECT().
+					GetUserByEmail(gomock.Any(), gomock.Eq(argUser)).
+					Times(1).
+					Return(user, nil)
+
+				store.EXPECT().
+					EnqueueMailTx(gomock.Any(), gomock.Any()).
+					Times(1).
+					Return(data.EnqueueMailTxResult{}, nil)
+			},
+		},
+		{
+			name:               "registerPage",
+			url:                "/register",
+			expectedStatusCode: http.StatusSeeOther,
+			expectedSessionKey: "flash",
+			handler:            testApp.PostRegisterPage,
+			postedData: url.Values{
+				"email":      {user.Email.String},
+				"password":   {pass},
+				"first-name": {user.FirstName.String},
+				"last-name":  {user.LastName.String},
+				"locale":     {"uk"},
+			},
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					InsertUserTx(gomock.Any(), gomock.Any()).
+					Times(1).
+					DoAndReturn(func(_ any, arg data.InsertUserTxParams) (data.InsertUserTxResult, error) {
+						require.Equal(t, user.Email, arg.Email)
+						require.Equal(t, "uk", arg.Locale)
+						require.Len(t, arg.Outbox, 1)
+						msg, err := messageFromOutbox(data.MailOutbox{Payload: arg.Outbox[0]})
+						require.NoError(t, err)
+						require.Equal(t, "uk", msg.Locale, "activation email is sent in the chosen language")
+						return data.InsertUserTxResult{User: user}, nil
+					})
+			},
+		},
 	}
 
 	for _, pt := range pagePostTests {
@@ -198,9 +255,20 @@ func TestGetHandlerSubscribeToPlan(t *testing.T) {
 					Times(1).
 					Return(plan, nil)
 
+				invoice, err := testApp.getInvoice(&plan)
+				require.NoError(t, err)
+				outbox, err := outboxPayload(Message{
+					To:       []string{user.Email.String},
+					Template: mailTemplateInvoice,
+					Locale:   user.Locale,
+					Data:     invoice,
+				})
+				require.NoError(t, err)
+
 				arg := data.SubscribeUserToPlanParams{
 					UserID: user.ID,
 					PlanID: plan.ID,
+					Outbox: outbox,
 				}
 				res := data.SubscribeUserToPlanResult{
 					UserPlan: userPlan,
@@ -209,6 +277,21 @@ func TestGetHandlerSubscribeToPlan(t *testing.T) {
 					SubscribeUserToPlan(gomock.Any(), gomock.Eq(arg)).
 					Times(1).
 					Return(res, nil)
+
+				// user manual is enqueued after the pdf is generated
+				store.EXPECT().
+					EnqueueMailTx(gomock.Any(), gomock.Any()).
+					Times(1).
+					DoAndReturn(func(_ any, payloads [][]byte) (data.EnqueueMailTxResult, error) {
+						msg, err := messageFromOutbox(data.MailOutbox{Payload: payloads[0]})
+						require.NoError(t, err)
+						require.Equal(t, mailTemplateManual, msg.Template)
+						require.Len(t, msg.Attachments, 1)
+						require.Equal(t, "Manual.pdf", msg.Attachments[0].Name)
+						require.Equal(t, "application/pdf", msg.Attachments[0].ContentType)
+						require.True(t, bytes.HasPrefix(msg.Attachments[0].Data, []byte("%PDF")))
+						return data.EnqueueMailTxResult{}, nil
+					})
 			},
 		},
 	}
@@ -250,3 +333,257 @@ func TestGetHandlerSubscribeToPlan(t *testing.T) {
 		}
 	}
 }
+
+func TestActivateAccount(t *testing.T) {
+	user := utils.RandomUser("Qw12345678!")
+	user.UserActive = pgtype.Int4{Int32: 0, Valid: true}
+	activeUser := user
+	activeUser.UserActive = pgtype.Int4{Int32: 1, Valid: true}
+
+	issue := func(purpose string, ttl time.Duration) string {
+		token, err := testApp.Tokens.Issue(purpose, user.Email.String, ttl)
+		require.NoError(t, err)
+		return "/activate?token=" + token
+	}
+	valid := issue(tokenActivation, time.Hour)
+
+	activateTests := []struct {
+		name               string
+		url                string
+		expectedStatusCode int
+		expectedHTML       string
+		expectedSessionKey string
+		buildStubs         func(store *mockdb.MockStore)
+	}{
+		{
+			name:               "activate",
+			url:                valid,
+			expectedStatusCode: http.StatusSeeOther,
+			expectedSessionKey: "flash",
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					GetUserByEmail(gomock.Any(), gomock.Eq(user.Email)).
+					Times(1).
+					Return(user, nil)
+				store.EXPECT().
+					UpdateUser(gomock.Any(), gomock.Any()).
+					Times(1).
+					DoAndReturn(func(_ any, arg data.UpdateUserParams) (data.User, error) {
+						require.Equal(t, user.ID, arg.ID)
+						require.Equal(t, int32(1), arg.UserActive.Int32)
+						return activeUser, nil
+					})
+			},
+		},
+		{
+			name:               "expired",
+			url:                issue(tokenActivation, -time.Minute),
+			expectedStatusCode: http.StatusOK,
+			expectedHTML:       "Link expired",
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					UpdateUser(gomock.Any(), gomock.Any()).
+					Times(0)
+			},
+		},
+		{
+			name:               "invalid",
+			url:                "/activate?token=abc",
+			expectedStatusCode: http.StatusSeeOther,
+			expectedSessionKey: "error",
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					UpdateUser(gomock.Any(), gomock.Any()).
+					Times(0)
+			},
+		},
+		{
+			name:               "alreadyActive",
+			url:                issue(tokenActivation, time.Hour),
+			expectedStatusCode: http.StatusSeeOther,
+			expectedSessionKey: "flash",
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					GetUserByEmail(gomock.Any(), gomock.Eq(user.Email)).
+					Times(1).
+					Return(activeUser, nil)
+				store.EXPECT().
+					UpdateUser(gomock.Any(), gomock.Any()).
+					Times(0)
+			},
+		},
+		{
+			name:               "usedTwice",
+			url:                valid,
+			expectedStatusCode: http.StatusSeeOther,
+			expectedSessionKey: "error",
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					GetUserByEmail(gomock.Any(), gomock.Eq(user.Email)).
+					Times(1).
+					Return(user, nil)
+				store.EXPECT().
+					UpdateUser(gomock.Any(), gomock.Any()).
+					Times(0)
+			},
+		},
+		{
+			name:               "wrongPurpose",
+			url:                issue(tokenPasswordReset, time.Hour),
+			expectedStatusCode: http.StatusSeeOther,
+			expectedSessionKey: "error",
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					GetUserByEmail(gomock.Any(), gomock.Any()).
+					Times(0)
+			},
+		},
+	}
+
+	for _, at := range activateTests {
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", at.url, nil)
+
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := mockdb.NewMockStore(ctrl)
+		at.buildStubs(store)
+
+		testApp.Store = store
+
+		testApp.ActivateAccount(rr, req)
+
+		require.Equal(t, at.expectedStatusCode, rr.Code, fmt.Sprintf("test name: %s", at.name))
+		if len(at.expectedHTML) > 0 {
+			require.Contains(t, rr.Body.String(), at.expectedHTML, fmt.Sprintf("test name: %s", at.name))
+		}
+		if len(at.expectedSessionKey) > 0 {
+			require.True(t, testApp.Session.Exists(ctx, at.expectedSessionKey), fmt.Sprintf("test name: %s", at.name))
+		}
+	}
+}
+
+func TestResendActivation(t *testing.T) {
+	user := utils.RandomUser("Qw12345678!")
+	user.UserActive = pgtype.Int4{Int32: 0, Valid: true}
+	activeUser := user
+	activeUser.Email = pgtype.Text{String: "active-" + user.Email.String, Valid: true}
+	activeUser.UserActive = pgtype.Int4{Int32: 1, Valid: true}
+
+	resendTests := []struct {
+		name               string
+		email              string
+		expectedStatusCode int
+		expectedSessionKey string
+		buildStubs         func(store *mockdb.MockStore)
+	}{
+		{
+			name:               "inactive",
+			email:              user.Email.String,
+			expectedStatusCode: http.StatusSeeOther,
+			expectedSessionKey: "flash",
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					GetUserByEmail(gomock.Any(), gomock.Eq(user.Email)).
+					Times(1).
+					Return(user, nil)
+				store.EXPECT().
+					EnqueueMailTx(gomock.Any(), gomock.Any()).
+					Times(1).
+					DoAndReturn(func(_ any, payloads [][]byte) (data.EnqueueMailTxResult, error) {
+						require.Len(t, payloads, 1)
+						msg, err := messageFromOutbox(data.MailOutbox{Payload: payloads[0]})
+						require.NoError(t, err)
+						require.Equal(t, mailTemplateConfirmation, msg.Template)
+						require.Equal(t, []string{user.Email.String}, msg.To)
+						require.Equal(t, user.Locale, msg.Locale)
+						return data.EnqueueMailTxResult{}, nil
+					})
+			},
+		},
+		{
+			name:               "throttled",
+			email:              user.Email.String,
+			expectedStatusCode: http.StatusSeeOther,
+			expectedSessionKey: "flash",
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					GetUserByEmail(gomock.Any(), gomock.Eq(user.Email)).
+					Times(1).
+					Return(user, nil)
+				store.EXPECT().
+					EnqueueMailTx(gomock.Any(), gomock.Any()).
+					Times(0)
+			},
+		},
+		{
+			name:               "active",
+			email:              activeUser.Email.String,
+			expectedStatusCode: http.StatusSeeOther,
+			expectedSessionKey: "flash",
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					GetUserByEmail(gomock.Any(), gomock.Eq(activeUser.Email)).
+					Times(1).
+					Return(activeUser, nil)
+				store.EXPECT().
+					EnqueueMailTx(gomock.Any(), gomock.Any()).
+					Times(0)
+			},
+		},
+		{
+			name:               "unknown",
+			email:              "nobody@example.com",
+			expectedStatusCode: http.StatusSeeOther,
+			expectedSessionKey: "flash",
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					GetUserByEmail(gomock.Any(), gomock.Any()).
+					Times(1).
+					Return(data.User{}, pgx.ErrNoRows)
+				store.EXPECT().
+					EnqueueMailTx(gomock.Any(), gomock.Any()).
+					Times(0)
+			},
+		},
+		{
+			name:               "emptyEmail",
+			expectedStatusCode: http.StatusSeeOther,
+			expectedSessionKey: "error",
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					GetUserByEmail(gomock.Any(), gomock.Any()).
+					Times(0)
+			},
+		},
+	}
+
+	for _, rt := range resendTests {
+		rr := httptest.NewRecorder()
+		postedData := url.Values{"email": {rt.email}}
+		req, _ := http.NewRequest("POST", "/activate/resend", strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := mockdb.NewMockStore(ctrl)
+		rt.buildStubs(store)
+
+		testApp.Store = store
+
+		testApp.PostResendActivation(rr, req)
+
+		require.Equal(t, rt.expectedStatusCode, rr.Code, fmt.Sprintf("test name: %s", rt.name))
+		if len(rt.expectedSessionKey) > 0 {
+			require.True(t, testApp.Session.Exists(ctx, rt.expectedSessionKey), fmt.Sprintf("test name: %s", rt.name))
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+)
+
+// states of the app on the health endpoint
+const (
+	healthOK       = "ok"
+	healthDegraded = "degraded"
+)
+
+type mailHealth struct {
+	Breaker    BreakerStatus  `json:"breaker"`
+	QueueDepth map[string]int `json:"queue_depth"`
+}
+
+type healthStatus struct {
+	Status string     `json:"status"`
+	Mail   mailHealth `json:"mail"`
+}
+
+// Health report the state of the mail delivery. The app keep serving requests while
+// the mail server is down, so the status is degraded and the code is still 200
+func (app *Server) Health(w http.ResponseWriter, r *http.Request) {
+	health := healthStatus{
+		Status: healthOK,
+		Mail: mailHealth{
+			Breaker:    BreakerStatus{State: breakerClosed},
+			QueueDepth: app.laneStats(func(lane chan Message) int { return len(lane) }),
+		},
+	}
+	if app.Mail.Breaker != nil {
+		health.Mail.Breaker = app.Mail.Breaker.Status()
+	}
+	if health.Mail.Breaker.State != breakerClosed {
+		health.Status = healthDegraded
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(health); err != nil {
+		log.Error().Err(err).Msg("failed to write health status")
+	}
+}
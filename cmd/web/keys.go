@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/dubass83/go-concurrency-project/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// defaultKeyID name the TOKEN_SECRET key, tokens signed before key ids
+// were added are verified with it
+const defaultKeyID = "default"
+
+// keyIDPattern keep ids safe for urls and tokens
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// SigningKey is a secret which sign tokens, tokens name it by the ID
+type SigningKey struct {
+	ID     string
+	Secret string
+	// RetireAt is the time the key stop verifying tokens, zero time never
+	RetireAt time.Time
+}
+
+// SigningKeys hold the keys of TOKEN_KEYS, new tokens are signed with the active key
+// and old keys keep verifying outstanding tokens until they are retired
+type SigningKeys struct {
+	ActiveID string
+	keys     map[string]SigningKey
+	now      func() time.Time
+}
+
+// newSigningKeys parse TOKEN_KEYS, comma separated entries of id:secret or id:secret:YYYY-MM-DD
+// with the retirement date. TOKEN_SECRET is the key named default, without TOKEN_KEYS it is
+// the only key and next to them it keeps verifying outstanding tokens until it is retired
+// by the default entry of TOKEN_KEYS or removed
+func newSigningKeys(conf utils.Config) (*SigningKeys, error) {
+	ks := &SigningKeys{
+		ActiveID: strings.TrimSpace(conf.TokenActiveKey),
+		keys:     make(map[string]SigningKey),
+		now:      time.Now,
+	}
+
+	var order []string
+	for i, entry := range strings.Split(conf.TokenKeys, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, secret, ok := strings.Cut(entry, ":")
+		if !ok {
+			// the entry is not logged, it could be a secret
+			return nil, fmt.Errorf("invalid token key #%d, expected id:secret or id:secret:YYYY-MM-DD", i+1)
+		}
+		key := SigningKey{ID: id, Secret: secret}
+		if !keyIDPattern.MatchString(key.ID) {
+			return nil, fmt.Errorf("invalid id of token key #%d, use letters, digits, - and _", i+1)
+		}
+		// secrets may contain colons, only a trailing date is the retirement date
+		if n := strings.LastIndex(secret, ":"); n >= 0 {
+			if retireAt, err := time.Parse(time.DateOnly, secret[n+1:]); err == nil {
+				key.Secret, key.RetireAt = secret[:n], retireAt
+			}
+		}
+		if key.Secret == "" {
+			return nil, fmt.Errorf("token key %s has an empty secret", key.ID)
+		}
+		if _, ok := ks.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate token key %s", key.ID)
+		}
+		ks.keys[key.ID] = key
+		order = append(order, key.ID)
+	}
+
+	if _, ok := ks.keys[defaultKeyID]; !ok && conf.TokenSecret != "" {
+		ks.keys[defaultKeyID] = SigningKey{ID: defaultKeyID, Secret: conf.TokenSecret}
+		order = append(order, defaultKeyID)
+	}
+	if len(ks.keys) == 0 {
+		return nil, fmt.Errorf("neither TOKEN_KEYS nor TOKEN_SECRET is set")
+	}
+	if ks.ActiveID == "" {
+		ks.ActiveID = order[0]
+	}
+
+	active, ok := ks.keys[ks.ActiveID]
+	if !ok {
+		return nil, fmt.Errorf("active token key %s is not in TOKEN_KEYS", ks.ActiveID)
+	}
+	// the active key is checked only here, so it can not be retired while the server runs
+	if !active.RetireAt.IsZero() {
+		return nil, fmt.Errorf("active token key %s can not have a retirement date, activate another key first", active.ID)
+	}
+	return ks, nil
+}
+
+// logSchedule report retired keys and keys waiting for retirement
+func (ks *SigningKeys) logSchedule() {
+	for id, key := range ks.keys {
+		switch {
+		case ks.retired(key):
+			log.Info().Str("key_id", id).Msg("token key is retired")
+		case !key.RetireAt.IsZero():
+			log.Info().Str("key_id", id).Time("retire_at", key.RetireAt).Msg("token key is scheduled for retirement")
+		}
+	}
+}
+
+// Active return the key which sign new tokens
+func (ks *SigningKeys) Active() SigningKey {
+	return ks.keys[ks.ActiveID]
+}
+
+// Lookup return the key named by the token, retired keys are not returned.
+// Tokens without the key id are verified with the default key
+func (ks *SigningKeys) Lookup(id string) (SigningKey, bool) {
+	if id == "" {
+		id = defaultKeyID
+	}
+	key, ok := ks.keys[id]
+	if !ok || ks.retired(key) {
+		return SigningKey{}, false
+	}
+	return key, true
+}
+
+func (ks *SigningKeys) retired(key SigningKey) bool {
+	return !key.RetireAt.IsZero() && !ks.now().Before(key.RetireAt)
+}
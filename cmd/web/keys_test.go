@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	goalone "github.com/bwmarrin/go-alone"
+	"github.com/dubass83/go-concurrency-project/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSigningKeys(t *testing.T) {
+	keysTests := []struct {
+		name           string
+		conf           utils.Config
+		expectedActive string
+		expectedErr    string
+	}{
+		{
+			name:           "tokenSecret",
+			conf:           utils.Config{TokenSecret: "secret"},
+			expectedActive: defaultKeyID,
+		},
+		{
+			name:           "firstKey",
+			conf:           utils.Config{TokenKeys: "k2:new-secret, k1:old-secret"},
+			expectedActive: "k2",
+		},
+		{
+			name: "activeKey",
+			conf: utils.Config{
+				TokenKeys:      "k1:old-secret:2099-01-01,k2:new:secret",
+				TokenActiveKey: "k2",
+			},
+			expectedActive: "k2",
+		},
+		{
+			name:           "tokenSecretNextToKeys",
+			conf:           utils.Config{TokenKeys: "k1:secret", TokenSecret: "old-secret"},
+			expectedActive: "k1",
+		},
+		{
+			name:        "noKeys",
+			conf:        utils.Config{},
+			expectedErr: "neither TOKEN_KEYS nor TOKEN_SECRET is set",
+		},
+		{
+			name:        "missingSecret",
+			conf:        utils.Config{TokenKeys: "k1:secret,k2"},
+			expectedErr: "invalid token key #2",
+		},
+		{
+			name:        "emptySecret",
+			conf:        utils.Config{TokenKeys: "k1:"},
+			expectedErr: "token key k1 has an empty secret",
+		},
+		{
+			name:        "invalidID",
+			conf:        utils.Config{TokenKeys: "k.1:secret"},
+			expectedErr: "invalid id of token key #1",
+		},
+		{
+			name:        "duplicateKey",
+			conf:        utils.Config{TokenKeys: "k1:secret,k1:other"},
+			expectedErr: "duplicate token key k1",
+		},
+		{
+			name:        "unknownActiveKey",
+			conf:        utils.Config{TokenKeys: "k1:secret", TokenActiveKey: "k2"},
+			expectedErr: "active token key k2 is not in TOKEN_KEYS",
+		},
+		{
+			name:        "retiredActiveKey",
+			conf:        utils.Config{TokenKeys: "k1:secret:2000-01-01"},
+			expectedErr: "active token key k1 can not have a retirement date",
+		},
+		{
+			name:        "scheduledActiveKey",
+			conf:        utils.Config{TokenKeys: "k1:old-secret,k2:secret:2099-01-01", TokenActiveKey: "k2"},
+			expectedErr: "active token key k2 can not have a retirement date",
+		},
+	}
+
+	for _, kt := range keysTests {
+		keys, err := newSigningKeys(kt.conf)
+		if kt.expectedErr != "" {
+			require.ErrorContains(t, err, kt.expectedErr, fmt.Sprintf("test name: %s", kt.name))
+			continue
+		}
+		require.NoError(t, err, fmt.Sprintf("test name: %s", kt.name))
+		require.Equal(t, kt.expectedActive, keys.Active().ID, fmt.Sprintf("test name: %s", kt.name))
+	}
+}
+
+func TestSigningKeysRetire(t *testing.T) {
+	keys, err := newSigningKeys(utils.Config{
+		TokenKeys:      "k1:old:secret:2030-06-01,k2:new-secret",
+		TokenActiveKey: "k2",
+	})
+	require.NoError(t, err)
+
+	old, ok := keys.Lookup("k1")
+	require.True(t, ok)
+	require.Equal(t, "old:secret", old.Secret, "colons are part of the secret")
+	require.Equal(t, time.Date(2030, 6, 1, 0, 0, 0, 0, time.UTC), old.RetireAt)
+
+	keys.now = func() time.Time { return time.Date(2030, 6, 1, 0, 0, 0, 0, time.UTC) }
+	_, ok = keys.Lookup("k1")
+	require.False(t, ok, "retired keys do not verify tokens")
+	_, ok = keys.Lookup("k2")
+	require.True(t, ok)
+	_, ok = keys.Lookup("k3")
+	require.False(t, ok)
+	_, ok = keys.Lookup("")
+	require.False(t, ok, "there is no default key without TOKEN_SECRET")
+}
+
+func TestSigningKeysDefault(t *testing.T) {
+	// TOKEN_SECRET keeps verifying tokens issued before TOKEN_KEYS were set
+	keys, err := newSigningKeys(utils.Config{TokenKeys: "k1:new-secret", TokenSecret: "secret"})
+	require.NoError(t, err)
+	require.Equal(t, "k1", keys.Active().ID)
+	key, ok := keys.Lookup("")
+	require.True(t, ok)
+	require.Equal(t, "secret", key.Secret)
+
+	// the default entry of TOKEN_KEYS retire it
+	keys, err = newSigningKeys(utils.Config{
+		TokenKeys:   "k1:new-secret,default:secret:2030-06-01",
+		TokenSecret: "secret",
+	})
+	require.NoError(t, err)
+	_, ok = keys.Lookup(defaultKeyID)
+	require.True(t, ok)
+	keys.now = func() time.Time { return time.Date(2030, 6, 1, 0, 0, 0, 0, time.UTC) }
+	_, ok = keys.Lookup(defaultKeyID)
+	require.False(t, ok, "retired default key do not verify tokens")
+}
+
+func TestTokenServiceRotation(t *testing.T) {
+	oldKeys, err := newSigningKeys(utils.Config{TokenKeys: "k1:old-secret"})
+	require.NoError(t, err)
+	token, err := NewTokenService(oldKeys, newMemoryNonceLedger()).Issue(tokenActivation, "user@example.com", time.Hour)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(token, "k1."))
+
+	// k2 is introduced and k1 keeps verifying outstanding tokens
+	keys, err := newSigningKeys(utils.Config{
+		TokenKeys:      "k1:old-secret:2030-06-01,k2:new-secret",
+		TokenActiveKey: "k2",
+	})
+	require.NoError(t, err)
+	tokens := NewTokenService(keys, newMemoryNonceLedger())
+
+	claims, err := tokens.Check(tokenActivation, token)
+	require.NoError(t, err)
+	require.Equal(t, "user@example.com", claims.Subject)
+
+	fresh, err := tokens.Issue(tokenActivation, "user@example.com", time.Hour)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(fresh, "k2."), "new tokens are signed with the active key")
+
+	// the token can not be moved to another key
+	_, err = tokens.Check(tokenActivation, "k2."+strings.TrimPrefix(token, "k1."))
+	require.ErrorIs(t, err, errTokenInvalid)
+
+	keys.now = func() time.Time { return time.Date(2030, 6, 1, 0, 0, 0, 0, time.UTC) }
+	_, err = tokens.Check(tokenActivation, token)
+	require.ErrorIs(t, err, errTokenInvalid, "tokens of retired keys are rejected")
+}
+
+func TestTokenServiceLegacyToken(t *testing.T) {
+	keys := testKeys(t, "secret")
+	tokens := NewTokenService(keys, newMemoryNonceLedger())
+	token, err := tokens.Issue(tokenActivation, "user@example.com", time.Hour)
+	require.NoError(t, err)
+
+	// tokens issued before key ids were added have no key id
+	legacy := strings.TrimPrefix(token, defaultKeyID+".")
+	claims, err := tokens.Check(tokenActivation, legacy)
+	require.NoError(t, err)
+	require.Equal(t, "user@example.com", claims.Subject)
+}
+
+func TestVerifyTokenRotation(t *testing.T) {
+	oldKey := SigningKey{ID: "k1", Secret: "old-secret"}
+	keys := &SigningKeys{
+		ActiveID: "k2",
+		keys: map[string]SigningKey{
+			"k1": oldKey,
+			"k2": {ID: "k2", Secret: "new-secret"},
+		},
+		now: time.Now,
+	}
+	app := &Server{Keys: keys}
+
+	link := "http://localhost:8080/unsubscribe?email=user@example.com"
+	signed := signURL(oldKey, link)
+	require.True(t, app.VerifyToken(signed), "urls of the old key are verified")
+	require.False(t, app.TokenExpired(signed, 60))
+
+	fresh := app.GenerateTokenFromString(link)
+	require.Contains(t, fresh, "kid=k2&hash=")
+	require.True(t, app.VerifyToken(fresh))
+
+	require.False(t, app.VerifyToken(strings.Replace(signed, "kid=k1", "kid=k2", 1)))
+	require.False(t, app.VerifyToken(strings.Replace(signed, "kid=k1", "kid=k3", 1)))
+
+	// urls signed before key ids were added are verified with the default key
+	legacy := string(goalone.New([]byte("secret"), goalone.Timestamp).Sign([]byte(link + "&hash=")))
+	require.False(t, app.VerifyToken(legacy), "there is no default key")
+	keys.keys[defaultKeyID] = SigningKey{ID: defaultKeyID, Secret: "secret"}
+	require.True(t, app.VerifyToken(legacy))
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+const defaultLocale = "en"
+
+type mailLocale struct {
+	Code  string
+	Title string
+}
+
+// supportedLocales have translations of the email templates, users can choose them
+var supportedLocales = []mailLocale{
+	{Code: "en", Title: "English"},
+	{Code: "uk", Title: "Українська"},
+}
+
+// supportedLocale return the supported locale matching the language tag,
+// en-US match en when there is no en-us translation
+func supportedLocale(tag string) (string, bool) {
+	tag = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(tag), "_", "-"))
+	if tag == "" {
+		return "", false
+	}
+	lang, _, _ := strings.Cut(tag, "-")
+	for _, l := range supportedLocales {
+		if l.Code == tag {
+			return l.Code, true
+		}
+	}
+	for _, l := range supportedLocales {
+		if l.Code == lang {
+			return l.Code, true
+		}
+	}
+	return "", false
+}
+
+// requestLocale return the locale chosen in the form,
+// or the first supported language of the browser
+func requestLocale(r *http.Request) string {
+	if locale, ok := supportedLocale(r.Form.Get("locale")); ok {
+		return locale
+	}
+	// languages are listed in the order of preference, weights are ignored
+	for _, tag := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, _, _ = strings.Cut(tag, ";")
+		if locale, ok := supportedLocale(tag); ok {
+			return locale
+		}
+	}
+	return defaultLocale
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestLocale(t *testing.T) {
+
+	localeTests := []struct {
+		name           string
+		form           url.Values
+		acceptLanguage string
+		expected       string
+	}{
+		{
+			name:     "default",
+			expected: defaultLocale,
+		},
+		{
+			name:     "chosenInForm",
+			form:     url.Values{"locale": {"uk"}},
+			expected: "uk",
+		},
+		{
+			name:           "unsupportedInForm",
+			form:           url.Values{"locale": {"de"}},
+			acceptLanguage: "uk-UA,uk;q=0.9",
+			expected:       "uk",
+		},
+		{
+			name:           "browserRegion",
+			acceptLanguage: "de-DE, uk_UA;q=0.8, en;q=0.5",
+			expected:       "uk",
+		},
+		{
+			name:           "unsupportedBrowser",
+			acceptLanguage: "de-DE,fr;q=0.8",
+			expected:       defaultLocale,
+		},
+	}
+
+	for _, lt := range localeTests {
+		req, _ := http.NewRequest("POST", "/register", strings.NewReader(lt.form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept-Language", lt.acceptLanguage)
+		require.NoError(t, req.ParseForm())
+
+		require.Equal(t, lt.expected, requestLocale(req), fmt.Sprintf("test name: %s", lt.name))
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	textTemplate "text/template"
+
+	"github.com/dubass83/go-concurrency-project/utils"
+	"github.com/vanng822/go-premailer/premailer"
+)
+
+const (
+	mailTemplateDefault       = "mail"
+	mailTemplateConfirmation  = "confirmation-email"
+	mailTemplateInvoice       = "invoice"
+	mailTemplateFailedLogin   = "failed-login"
+	mailTemplatePasswordReset = "password-reset"
+	mailTemplateManual        = "manual"
+)
+
+// referencedMailTemplates are used by the handlers, the app does not start without them
+var referencedMailTemplates = []string{
+	mailTemplateDefault,
+	mailTemplateConfirmation,
+	mailTemplateInvoice,
+	mailTemplateFailedLogin,
+	mailTemplatePasswordReset,
+	mailTemplateManual,
+}
+
+// mailTemplateSamples are the default data of templates on the preview page
+var mailTemplateSamples = map[string]any{
+	mailTemplateDefault:       "Hello world",
+	mailTemplateConfirmation:  "http://localhost:8080/activate?email=user@example.com",
+	mailTemplateInvoice:       "$10.00",
+	mailTemplatePasswordReset: "http://localhost:8080/password/reset?token=abc",
+}
+
+//go:embed templates
+var templateFS embed.FS
+
+var (
+	templateActionRe      = regexp.MustCompile(`(?s)\{\{.*?\}\}`)
+	templatePlaceholderRe = regexp.MustCompile(`mailtplaction(\d+)end`)
+)
+
+// MailTemplates keep email templates parsed once at the start of the app
+type MailTemplates struct {
+	html    map[string]*template.Template
+	plain   map[string]*textTemplate.Template
+	inlined map[string]bool
+}
+
+// initMailTemplates load embedded templates or templates from PATH_TO_TEMPLATE
+// and check that every template used by the app exists
+func initMailTemplates(conf utils.Config) (*MailTemplates, error) {
+	fsys := os.DirFS(conf.PathToTemplate)
+	if conf.MailTemplatesEmbedded {
+		sub, err := fs.Sub(templateFS, "templates")
+		if err != nil {
+			return nil, err
+		}
+		fsys = sub
+	}
+
+	templates, err := LoadMailTemplates(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	names := referencedMailTemplates
+	if conf.EmailTemplate != "" {
+		names = append([]string{conf.EmailTemplate}, names...)
+	}
+	if err := templates.Validate(names...); err != nil {
+		return nil, err
+	}
+	return templates, nil
+}
+
+// LoadMailTemplates parse all *.html.gohtml and *.plain.gohtml templates
+// from the root of fsys, every template must have both variants.
+// Translations are named name.locale.html.gohtml, the plain variant
+// may define the subject of the email
+func LoadMailTemplates(fsys fs.FS) (*MailTemplates, error) {
+	mt := &MailTemplates{
+		html:    make(map[string]*template.Template),
+		plain:   make(map[string]*textTemplate.Template),
+		inlined: make(map[string]bool),
+	}
+
+	htmlFiles, err := fs.Glob(fsys, "*.html.gohtml")
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range htmlFiles {
+		name := strings.TrimSuffix(file, ".html.gohtml")
+		t, inlined, err := parseHTMLMailTemplate(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+		mt.html[name] = t
+		mt.inlined[name] = inlined
+	}
+
+	plainFiles, err := fs.Glob(fsys, "*.plain.gohtml")
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range plainFiles {
+		name := strings.TrimSuffix(file, ".plain.gohtml")
+		t, err := textTemplate.New(file).ParseFS(fsys, file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse template %s: %s", file, err)
+		}
+		if t.Lookup("body") == nil {
+			return nil, fmt.Errorf("template %s does not define body", file)
+		}
+		mt.plain[name] = t
+	}
+
+	for name := range mt.html {
+		if _, ok := mt.plain[name]; !ok {
+			return nil, fmt.Errorf("template %s.plain.gohtml is missing", name)
+		}
+	}
+	for name := range mt.plain {
+		if _, ok := mt.html[name]; !ok {
+			return nil, fmt.Errorf("template %s.html.gohtml is missing", name)
+		}
+	}
+	for name := range mt.html {
+		if base := mailTemplateBase(name); !mt.Has(base) {
+			return nil, fmt.Errorf("translation %s has no default template %s", name, base)
+		}
+	}
+
+	return mt, nil
+}
+
+// parseHTMLMailTemplate parse the template and inline its CSS once.
+// Template actions are hidden from premailer behind placeholders, if
+// premailer moves them around the CSS is inlined on every render instead
+func parseHTMLMailTemplate(fsys fs.FS, file string) (*template.Template, bool, error) {
+	t, err := template.New(file).ParseFS(fsys, file)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to parse template %s: %s", file, err)
+	}
+	body := t.Lookup("body")
+	if body == nil {
+		return nil, false, fmt.Errorf("template %s does not define body", file)
+	}
+
+	src := body.Tree.Root.String()
+	actions := templateActionRe.FindAllString(src, -1)
+	i := 0
+	protected := templateActionRe.ReplaceAllStringFunc(src, func(string) string {
+		placeholder := fmt.Sprintf("mailtplaction%dend", i)
+		i++
+		return placeholder
+	})
+
+	html, err := inlineCSS(protected)
+	if err != nil {
+		return t, false, nil
+	}
+
+	// every action must stay in place and in the same order
+	found := templatePlaceholderRe.FindAllStringSubmatch(html, -1)
+	if len(found) != len(actions) {
+		return t, false, nil
+	}
+	for i, match := range found {
+		if n, _ := strconv.Atoi(match[1]); n != i {
+			return t, false, nil
+		}
+	}
+	restored := templatePlaceholderRe.ReplaceAllStringFunc(html, func(placeholder string) string {
+		n, _ := strconv.Atoi(templatePlaceholderRe.FindStringSubmatch(placeholder)[1])
+		return actions[n]
+	})
+
+	inlined, err := template.New(file).Parse(fmt.Sprintf(`{{define "body"}}%s{{end}}`, restored))
+	if err != nil {
+		return t, false, nil
+	}
+	return inlined, true, nil
+}
+
+// Names return sorted names of all loaded templates
+func (mt *MailTemplates) Names() []string {
+	names := make([]string, 0, len(mt.html))
+	for name := range mt.html {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Has report if the template with both variants is loaded
+func (mt *MailTemplates) Has(name string) bool {
+	_, ok := mt.html[name]
+	return ok
+}
+
+// mailTemplateBase return the name of the template without the locale
+func mailTemplateBase(name string) string {
+	base, _, _ := strings.Cut(name, ".")
+	return base
+}
+
+// localizedNames return the translations of the template to try,
+// the most specific first and the default template last
+func localizedNames(name, locale string) []string {
+	names := make([]string, 0, 3)
+	if locale != "" {
+		names = append(names, fmt.Sprintf("%s.%s", name, locale))
+		if lang, _, ok := strings.Cut(locale, "-"); ok {
+			names = append(names, fmt.Sprintf("%s.%s", name, lang))
+		}
+	}
+	return append(names, name)
+}
+
+// Resolve return the translation of the template for the locale,
+// or the default template when there is none
+func (mt *MailTemplates) Resolve(name, locale string) string {
+	for _, n := range localizedNames(name, locale) {
+		if mt.Has(n) {
+			return n
+		}
+	}
+	return name
+}
+
+// Subject render the subject defined by the translation of the template,
+// it return an empty string when neither translation defines it
+func (mt *MailTemplates) Subject(name, locale string, message map[string]any) (string, error) {
+	for _, n := range localizedNames(name, locale) {
+		t, ok := mt.plain[n]
+		if !ok || t.Lookup("subject") == nil {
+			continue
+		}
+
+		var tpl bytes.Buffer
+		if err := t.ExecuteTemplate(&tpl, "subject", message); err != nil {
+			return "", fmt.Errorf("failed execute subject of template %s: %s", n, err)
+		}
+		return strings.TrimSpace(tpl.String()), nil
+	}
+	return "", nil
+}
+
+// Validate return error if any of the templates is missing
+func (mt *MailTemplates) Validate(names ...string) error {
+	var missing []string
+	for _, name := range names {
+		if !mt.Has(name) {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing email templates: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// buildHTMLMessage render the html body, links and the open pixel
+// are added by the tracker when the message is tracked
+func (mt *MailTemplates) buildHTMLMessage(name string, message map[string]any, tracker *messageTracker) (string, error) {
+	t, ok := mt.html[name]
+	if !ok {
+		return "", fmt.Errorf("unknown email template %s", name)
+	}
+
+	var tpl bytes.Buffer
+
+	if err := t.ExecuteTemplate(&tpl, "body", message); err != nil {
+		return "", fmt.Errorf("failed execute template with message %v: %s", message, err)
+	}
+
+	formattedMessage := tpl.String()
+	if !mt.inlined[name] {
+		var err error
+		formattedMessage, err = inlineCSS(formattedMessage)
+		if err != nil {
+			return "", fmt.Errorf("failed generate inline CSS message from template: %s", err)
+		}
+	}
+	return tracker.apply(formattedMessage)
+}
+
+func (mt *MailTemplates) builPlainTextMessage(name string, message map[string]any) (string, error) {
+	t, ok := mt.plain[name]
+	if !ok {
+		return "", fmt.Errorf("unknown email template %s", name)
+	}
+
+	var tpl bytes.Buffer
+
+	if err := t.ExecuteTemplate(&tpl, "body", message); err != nil {
+		return "", fmt.Errorf("failed execute template with message %v: %s", message, err)
+	}
+
+	return tpl.String(), nil
+}
+
+func inlineCSS(fm string) (string, error) {
+	options := premailer.Options{
+		RemoveClasses:     false,
+		CssToAttributes:   false,
+		KeepBangImportant: true,
+	}
+
+	prem, err := premailer.NewPremailerFromString(fm, &options)
+	if err != nil {
+		return "", fmt.Errorf("failed create premailer from string %s: %s", fm, err)
+	}
+
+	html, err := prem.Transform()
+	if err != nil {
+		return "", fmt.Errorf("failed transform premailer to string: %s", err)
+	}
+	return html, nil
+}
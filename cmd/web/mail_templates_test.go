@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMailTemplates(t *testing.T) {
+	templates := testApp.Mail.Templates
+
+	require.NoError(t, templates.Validate(referencedMailTemplates...))
+	require.Error(t, templates.Validate("invoce"))
+
+	for _, name := range referencedMailTemplates {
+		require.True(t, templates.inlined[name], fmt.Sprintf("template %s should be inlined at start", name))
+	}
+
+	// embedded templates are the same as the templates on disk
+	sub, err := fs.Sub(templateFS, "templates")
+	require.NoError(t, err)
+	embedded, err := LoadMailTemplates(sub)
+	require.NoError(t, err)
+	require.Equal(t, templates.Names(), embedded.Names())
+}
+
+func TestBuildHTMLMessage(t *testing.T) {
+	templates := testApp.Mail.Templates
+
+	messages := map[string]any{
+		mailTemplateDefault:      "invalid login attempt!",
+		mailTemplateConfirmation: "http://localhost:8080/activate?email=user@example.com&hash=abc",
+		mailTemplateInvoice:      "$10.00",
+	}
+
+	for name, value := range messages {
+		message := map[string]any{"message": value}
+
+		// inlined once at start must be the same as inlined on every send
+		old, err := template.New("email-html").ParseFiles(fmt.Sprintf("%s/%s.html.gohtml", testApp.Config.PathToTemplate, name))
+		require.NoError(t, err)
+		var tpl bytes.Buffer
+		require.NoError(t, old.ExecuteTemplate(&tpl, "body", message))
+		expected, err := inlineCSS(tpl.String())
+		require.NoError(t, err)
+
+		html, err := templates.buildHTMLMessage(name, message, nil)
+		require.NoError(t, err)
+		require.Equal(t, expected, html, fmt.Sprintf("template: %s", name))
+	}
+
+	plain, err := templates.builPlainTextMessage(mailTemplateConfirmation, map[string]any{
+		"message": messages[mailTemplateConfirmation],
+	})
+	require.NoError(t, err)
+	require.Contains(t, plain, "&hash=abc", "plain text must not be html escaped")
+
+	_, err = templates.buildHTMLMessage("invoce", nil, nil)
+	require.Error(t, err)
+}
+
+func TestLoadMailTemplatesErrors(t *testing.T) {
+	body := func(s string) *fstest.MapFile {
+		return &fstest.MapFile{Data: []byte(fmt.Sprintf(`{{define "body"}}%s{{end}}`, s))}
+	}
+
+	_, err := LoadMailTemplates(fstest.MapFS{
+		"news.html.gohtml": body("<p>{{.message}}</p>"),
+	})
+	require.Error(t, err, "plain variant is missing")
+
+	_, err = LoadMailTemplates(fstest.MapFS{
+		"news.html.gohtml":  body("<p>{{.message}}</p>"),
+		"news.plain.gohtml": &fstest.MapFile{Data: []byte("{{.message}}")},
+	})
+	require.Error(t, err, "body is not defined")
+
+	_, err = LoadMailTemplates(fstest.MapFS{
+		"news.html.gohtml":  body("<p>{{.message}</p>"),
+		"news.plain.gohtml": body("{{.message}}"),
+	})
+	require.Error(t, err, "template syntax error")
+
+	_, err = LoadMailTemplates(fstest.MapFS{
+		"news.uk.html.gohtml":  body("<p>{{.message}}</p>"),
+		"news.uk.plain.gohtml": body("{{.message}}"),
+	})
+	require.Error(t, err, "translation without the default template")
+}
+
+func TestLocalizedMailTemplates(t *testing.T) {
+	templates := testApp.Mail.Templates
+
+	require.Equal(t, "invoice.uk", templates.Resolve(mailTemplateInvoice, "uk"))
+	require.Equal(t, "invoice.uk", templates.Resolve(mailTemplateInvoice, "uk-UA"))
+	require.Equal(t, mailTemplateInvoice, templates.Resolve(mailTemplateInvoice, "de"))
+	require.Equal(t, mailTemplateDefault, templates.Resolve(mailTemplateDefault, "uk"), "default template is used without translation")
+
+	message := map[string]any{"message": "$10.00"}
+	subject, err := templates.Subject(mailTemplateInvoice, "", message)
+	require.NoError(t, err)
+	require.Equal(t, "Your invoice", subject)
+
+	subject, err = templates.Subject(mailTemplateInvoice, "uk", message)
+	require.NoError(t, err)
+	require.Equal(t, "Ваш рахунок", subject)
+
+	subject, err = templates.Subject(mailTemplateDefault, "uk", message)
+	require.NoError(t, err)
+	require.Empty(t, subject, "subject is set by the sender")
+
+	sender := NewMemorySender(newMsgBuilder(testApp.Config, templates), 2)
+	require.NoError(t, sender.SendEmail(Message{
+		To:       []string{"user@example.com"},
+		Template: mailTemplateInvoice,
+		Locale:   "uk",
+		Data:     "$10.00",
+	}))
+	captured := sender.Messages()[0]
+	require.Equal(t, "Ваш рахунок", captured.Subject)
+	require.Contains(t, captured.Plain, "Ваш рахунок:")
+	require.Contains(t, captured.HTML, `lang="uk"`)
+
+	require.NoError(t, sender.SendEmail(Message{
+		To:       []string{"user@example.com"},
+		Subject:  "Invoice for May",
+		Template: mailTemplateInvoice,
+		Locale:   "uk",
+		Data:     "$10.00",
+	}))
+	require.Equal(t, "Invoice for May", sender.Messages()[0].Subject, "subject of the message is not replaced")
+
+	require.NoError(t, sender.SendEmail(Message{
+		To:       []string{"user@example.com"},
+		Template: mailTemplateDefault,
+		Data:     "Hello",
+	}))
+	require.Equal(t, testApp.Config.EmailSubject, sender.Messages()[0].Subject, "templates without a subject use the default one")
+}
+
+func TestMailTemplatesInlineFallback(t *testing.T) {
+	// text inside a table is moved by the html parser, so css is inlined on render
+	templates, err := LoadMailTemplates(fstest.MapFS{
+		"report.html.gohtml": &fstest.MapFile{Data: []byte(`{{define "body"}}<html><head><style>td { color: red; }</style></head>` +
+			`<body><table>{{range .message}}<tr><td>{{.}}</td></tr>{{end}}</table></body></html>{{end}}`)},
+		"report.plain.gohtml": &fstest.MapFile{Data: []byte(`{{define "body"}}{{range .message}}{{.}}{{end}}{{end}}`)},
+	})
+	require.NoError(t, err)
+	require.False(t, templates.inlined["report"])
+
+	html, err := templates.buildHTMLMessage("report", map[string]any{"message": []string{"one", "two"}}, nil)
+	require.NoError(t, err)
+	require.Contains(t, html, `<td style="color:red">one</td>`)
+	require.Contains(t, html, `<td style="color:red">two</td>`)
+}
@@ -1,127 +1,379 @@
 package main
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"html/template"
-	"sync"
+	"strings"
+	"time"
 
 	"github.com/dubass83/go-concurrency-project/utils"
 	"github.com/rs/zerolog/log"
-	"github.com/vanng822/go-premailer/premailer"
 	"github.com/wneessen/go-mail"
 )
 
 type Mail struct {
-	MailerChan chan Message
-	ErrChan    chan error
-	DoneChan   chan bool
-	Sender     EmailSender
+	// MailerChan carry transactional messages, they are dispatched before BulkChan
+	MailerChan     chan Message
+	BulkChan       chan Message
+	ErrChan        chan error
+	DoneChan       chan bool
+	OutboxChan     chan struct{}
+	OutboxDoneChan chan bool
+	Sender         EmailSender
+	// Breaker wrap Sender and pause mail workers while the mail server is down
+	Breaker   *CircuitBreaker
+	Templates *MailTemplates
+	// Broadcasts are emails sent by admins to segments of users
+	Broadcasts *Broadcasts
+	// Mailbox is set only when messages are kept in memory
+	Mailbox *MemorySender
+	// Throttle limit emails sent with ThrottleWindows templates
+	Throttle        MailThrottle
+	ThrottleWindows map[string]time.Duration
 }
 
+// EmailSender deliver a single message and report the result,
+// so the caller can record it in the mail outbox
 type EmailSender interface {
-	SendEmail(email Message, errChan chan error)
-	WaitForSending()
+	SendEmail(email Message) error
+	NewWorker() EmailWorker
+}
+
+// EmailWorker is used by a single mail worker and
+// may keep the connection to the mail server between messages
+type EmailWorker interface {
+	SendEmail(email Message) error
+	Close() error
 }
 
 type Message struct {
-	From          string
-	FromEmail     string
-	Subject       string
-	To            []string
-	CC            []string
-	BCC           []string
-	Data          any
-	Message       map[string]any
-	AttachFiles   []string
-	AttachmentMap map[string]string
-	Template      string
-}
-
-type MailTrapSender struct {
-	From        string
-	FromEmail   string
-	Login       string
-	Password    string
-	SMTPHost    string
-	SMTPPort    int
-	SMTPAuth    mail.SMTPAuthType
-	TemplateDir string
-	Wg          *sync.WaitGroup
-}
-
-func NewMailSender(conf utils.Config) (EmailSender, error) {
+	From           string
+	FromEmail      string
+	Subject        string
+	To             []string
+	CC             []string
+	BCC            []string
+	Data           any
+	Message        map[string]any
+	AttachFiles    []string
+	AttachmentMap  map[string]string
+	Attachments    []Attachment `json:",omitempty"`
+	Template       string
+	Locale         string         `json:",omitempty"`
+	Category       string         `json:",omitempty"`
+	Priority       string         `json:",omitempty"`
+	Recipient      *MailRecipient `json:",omitempty"`
+	Retry          *RetryPolicy   `json:",omitempty"`
+	OutboxID       int64          `json:"-"`
+	MessageID      string         `json:"-"`
+	Attempt        int            `json:"-"`
+	UnsubscribeURL string         `json:"-"`
+	OriginalTo     []string       `json:"-"`
+}
+
+// MailRecipient personalize messages sent to many users,
+// it is available in templates as .recipient
+type MailRecipient struct {
+	FirstName string
+	LastName  string
+	Email     string
+}
+
+// msgBuilder render messages with the app templates,
+// it is shared by all EmailSender implementations
+type msgBuilder struct {
+	From            string
+	FromEmail       string
+	Templates       *MailTemplates
+	DefaultTemplate string
+	// DefaultSubject is the subject of messages whose template does not define one
+	DefaultSubject string
+	// DKIM sign messages when it is configured
+	DKIM *DKIMSigner
+	// Redirect rewrite recipients outside of production when it is configured
+	Redirect *MailRedirect
+	// Tracking record opens and clicks of html bodies when it is enabled
+	Tracking *MailTracking
+}
+
+// SMTPSender deliver messages through any SMTP server described by the config
+type SMTPSender struct {
+	msgBuilder
+	Login         string
+	Password      string
+	SMTPHost      string
+	SMTPPort      int
+	SMTPAuth      mail.SMTPAuthType
+	SMTPTLSPolicy mail.TLSPolicy
+	SMTPSSL       bool
+	IdleTimeout   time.Duration
+}
+
+const (
+	mailtrapHost = "sandbox.smtp.mailtrap.io"
+	mailtrapPort = 2525
+
+	defaultSMTPIdleTimeout = 30 * time.Second
+	defaultMailSubject     = "Message from the concurrency project"
+)
+
+// NewMailSender return the sender of EMAIL_SERVICE, tracking is nil when messages are not tracked
+func NewMailSender(conf utils.Config, templates *MailTemplates, tracking *MailTracking) (EmailSender, error) {
+	builder := newMsgBuilder(conf, templates)
+	dkim, err := newDKIMSigner(conf)
+	if err != nil {
+		return nil, err
+	}
+	builder.DKIM = dkim
+	builder.Redirect = newMailRedirect(conf)
+	builder.Tracking = tracking
+	if builder.Redirect != nil {
+		log.Warn().
+			Str("catch_all", builder.Redirect.CatchAll).
+			Strs("allowed_domains", builder.Redirect.AllowedDomains).
+			Msg("emails are redirected outside of production")
+	} else if !isProduction(conf.Enviroment) && (conf.EmailService == "smtp" || conf.EmailService == "mailtrap") {
+		// outside of production real recipients are never reached by mistake
+		return nil, fmt.Errorf("emails of the %s enviroment must be redirected, set MAIL_REDIRECT_TO or MAIL_REDIRECT_ALLOWED_DOMAINS", conf.Enviroment)
+	}
+
 	switch conf.EmailService {
 	case "mailtrap":
-		return &MailTrapSender{
-			From:        conf.SenderName,
-			FromEmail:   conf.SenderEmail,
-			Login:       conf.EmailLogin,
-			Password:    conf.EmailPassword,
-			SMTPHost:    "sandbox.smtp.mailtrap.io",
-			SMTPPort:    2525,
-			SMTPAuth:    mail.SMTPAuthPlain,
-			TemplateDir: conf.PathToTemplate,
-			Wg:          &sync.WaitGroup{},
-		}, nil
+		// mailtrap sandbox is just a well known smtp server
+		conf.EmailHost = mailtrapHost
+		conf.EmailPort = mailtrapPort
+		conf.EmailAuth = string(mail.SMTPAuthPlain)
+		return newSMTPSender(conf, builder)
+	case "smtp":
+		return newSMTPSender(conf, builder)
+	case "file":
+		return newFileSender(conf, builder)
+	case "memory":
+		return NewMemorySender(builder, defaultMailboxSize), nil
 	default:
-		return nil, fmt.Errorf("not implemented any other mail service except mailtrap")
+		return nil, fmt.Errorf("not implemented mail service: %s", conf.EmailService)
+	}
+}
+
+func newMsgBuilder(conf utils.Config, templates *MailTemplates) msgBuilder {
+	builder := msgBuilder{
+		From:            conf.SenderName,
+		FromEmail:       conf.SenderEmail,
+		Templates:       templates,
+		DefaultTemplate: conf.EmailTemplate,
+		DefaultSubject:  conf.EmailSubject,
+	}
+	if builder.DefaultTemplate == "" {
+		builder.DefaultTemplate = mailTemplateDefault
+	}
+	if builder.DefaultSubject == "" {
+		builder.DefaultSubject = defaultMailSubject
+	}
+	return builder
+}
+
+func newSMTPSender(conf utils.Config, builder msgBuilder) (*SMTPSender, error) {
+	if conf.EmailHost == "" {
+		return nil, fmt.Errorf("smtp host is not set")
+	}
+
+	var auth mail.SMTPAuthType
+	if conf.EmailAuth == "" {
+		auth = mail.SMTPAuthNoAuth
+	} else if err := auth.UnmarshalString(conf.EmailAuth); err != nil {
+		return nil, err
+	}
+
+	sender := &SMTPSender{
+		msgBuilder:  builder,
+		Login:       conf.EmailLogin,
+		Password:    conf.EmailPassword,
+		SMTPHost:    conf.EmailHost,
+		SMTPPort:    conf.EmailPort,
+		SMTPAuth:    auth,
+		IdleTimeout: conf.MailConnIdleTimeout,
+	}
+	if sender.IdleTimeout == 0 {
+		sender.IdleTimeout = defaultSMTPIdleTimeout
+	}
+
+	switch strings.ToLower(conf.EmailEncryption) {
+	case "", "none":
+		sender.SMTPTLSPolicy = mail.NoTLS
+	case "starttls":
+		sender.SMTPTLSPolicy = mail.TLSMandatory
+	case "ssl", "tls":
+		sender.SMTPTLSPolicy = mail.NoTLS
+		sender.SMTPSSL = true
+	default:
+		return nil, fmt.Errorf("unsupported email encryption: %s", conf.EmailEncryption)
+	}
+
+	if sender.SMTPPort == 0 {
+		sender.SMTPPort = defaultSMTPPort(sender.SMTPTLSPolicy, sender.SMTPSSL)
+	}
+
+	return sender, nil
+}
+
+// defaultSMTPPort return the standard port for the chosen encryption mode
+func defaultSMTPPort(policy mail.TLSPolicy, ssl bool) int {
+	switch {
+	case ssl:
+		return 465
+	case policy == mail.TLSMandatory:
+		return 587
+	default:
+		return 25
+	}
+}
+
+// clientOptions return go-mail options for connecting to the smtp server
+func (sender *SMTPSender) clientOptions() []mail.Option {
+	opts := []mail.Option{
+		mail.WithPort(sender.SMTPPort),
+		mail.WithTLSPolicy(sender.SMTPTLSPolicy),
+		mail.WithSMTPAuth(sender.SMTPAuth),
+	}
+	if sender.SMTPSSL {
+		opts = append(opts, mail.WithSSL())
+	}
+	if sender.SMTPAuth != mail.SMTPAuthNoAuth {
+		opts = append(opts,
+			mail.WithUsername(sender.Login),
+			mail.WithPassword(sender.Password),
+		)
+	}
+	return opts
+}
+
+func (sender *SMTPSender) SendEmail(email Message) error {
+	m, err := sender.buildMsg(email)
+	if err != nil {
+		return err
+	}
+
+	c, err := mail.NewClient(sender.SMTPHost, sender.clientOptions()...)
+	if err != nil {
+		return fmt.Errorf("failed to create mail client: %s", err)
+	}
+
+	return c.DialAndSend(m)
+}
+
+// NewWorker return a worker which keep the smtp connection open between messages
+func (sender *SMTPSender) NewWorker() EmailWorker {
+	return &smtpWorker{sender: sender}
+}
+
+// buildMsg render the templates of the message and build the mail ready for sending
+func (b msgBuilder) buildMsg(email Message) (*mail.Msg, error) {
+	email, plain, html, err := b.prepare(email)
+	if err != nil {
+		return nil, err
 	}
+	return b.compose(email, plain, html)
 }
 
-func (sender *MailTrapSender) SendEmail(
-	email Message,
-	errChan chan error,
-) {
-	defer sender.Wg.Done()
+// prepare fill the defaults and the subject of the message and render its bodies
+func (b msgBuilder) prepare(email Message) (Message, string, string, error) {
+	email = b.withDefaults(email)
+	if b.Redirect != nil {
+		var err error
+		if email, err = b.Redirect.Apply(email); err != nil {
+			return email, "", "", permanent(err)
+		}
+	}
+	plain, html, err := b.render(email)
+	if err != nil {
+		return email, "", "", err
+	}
+	if email.Subject == "" {
+		email.Subject, err = b.Templates.Subject(email.Template, email.Locale, b.templateData(email))
+		if err != nil {
+			return email, "", "", permanent(err)
+		}
+	}
+	if email.Subject == "" {
+		email.Subject = b.DefaultSubject
+	}
+	return email, plain, html, nil
+}
+
+// withDefaults fill the sender and template of the message from the config
+func (b msgBuilder) withDefaults(email Message) Message {
 	if email.Template == "" {
-		email.Template = "mail"
+		email.Template = b.DefaultTemplate
 	}
 	if email.From == "" {
-		email.From = sender.From
+		email.From = b.From
 	}
 	if email.FromEmail == "" {
-		email.FromEmail = sender.FromEmail
+		email.FromEmail = b.FromEmail
 	}
+	return email
+}
 
-	if email.AttachmentMap == nil {
-		email.AttachmentMap = make(map[string]string)
+// templateData return the data available in the templates of the message
+func (b msgBuilder) templateData(email Message) map[string]any {
+	return map[string]any{
+		"message":     email.Data,
+		"recipient":   email.Recipient,
+		"unsubscribe": email.UnsubscribeURL,
 	}
+}
+
+// render return plain text and html bodies of the message
+// in the translation of the template for the message locale
+func (b msgBuilder) render(email Message) (string, string, error) {
+	email.Message = b.templateData(email)
+	name := b.Templates.Resolve(email.Template, email.Locale)
 
+	// generate text plain body
+	contentPlain, err := b.Templates.builPlainTextMessage(name, email.Message)
+	if err != nil {
+		return "", "", permanent(fmt.Errorf("failed to generate plain text message: %s", err))
+	}
+	// generate alternative html formated body
+	contentHtml, err := b.Templates.buildHTMLMessage(name, email.Message, b.Tracking.Tracker(email))
+	if err != nil {
+		return "", "", permanent(fmt.Errorf("failed to generate html formated message: %s", err))
+	}
+	return contentPlain, contentHtml, nil
+}
+
+// compose build the mail from the message and its rendered bodies
+func (b msgBuilder) compose(email Message, plain, html string) (*mail.Msg, error) {
 	m := mail.NewMsg()
 	if err := m.FromFormat(email.From, email.FromEmail); err != nil {
-		errChan <- fmt.Errorf("failed to set from address: %s", err)
+		return nil, permanent(fmt.Errorf("failed to set from address: %s", err))
 	}
 	if err := m.To(email.To...); err != nil {
-		errChan <- fmt.Errorf("failed to set To address: %s", err)
+		return nil, permanent(fmt.Errorf("failed to set To address: %s", err))
 	}
 	if err := m.Cc(email.CC...); err != nil {
-		errChan <- fmt.Errorf("failed to set CC address: %s", err)
+		return nil, permanent(fmt.Errorf("failed to set CC address: %s", err))
 	}
 	if err := m.Bcc(email.BCC...); err != nil {
-		errChan <- fmt.Errorf("failed to set BCC address: %s", err)
+		return nil, permanent(fmt.Errorf("failed to set BCC address: %s", err))
 	}
 	m.Subject(email.Subject)
-
-	data := map[string]any{
-		"message": email.Data,
+	if email.MessageID != "" {
+		// the same id is stored in the outbox, so the delivery can be tracked
+		m.SetMessageIDWithValue(messageIDHeader(email.MessageID, email.FromEmail))
 	}
-	email.Message = data
-
-	// generate and set to the message text plain body
-	templPlain := fmt.Sprintf("%s/%s.plain.gohtml", sender.TemplateDir, email.Template)
-	contentPlain, err := builPlainTextMessage(templPlain, email.Message)
-	if err != nil {
-		errChan <- fmt.Errorf("failed to generate plain text message: %s", err)
+	if len(email.OriginalTo) > 0 {
+		m.SetGenHeader(headerOriginalTo, strings.Join(email.OriginalTo, ", "))
 	}
-	m.SetBodyString(mail.TypeTextPlain, contentPlain)
-	// generate and set to the message alternative html formated body
-	templFormated := fmt.Sprintf("%s/%s.html.gohtml", sender.TemplateDir, email.Template)
-	contentHtml, err := buildHTMLMessage(templFormated, email.Message)
-	if err != nil {
-		errChan <- fmt.Errorf("failed to generate html formated message: %s", err)
+	if email.UnsubscribeURL != "" {
+		// one-click unsubscribe (RFC 8058), mail clients POST to the link
+		m.SetGenHeader(mail.HeaderListUnsubscribe, fmt.Sprintf("<%s>", email.UnsubscribeURL))
+		m.SetGenHeader(mail.HeaderListUnsubscribePost, "List-Unsubscribe=One-Click")
 	}
-	m.AddAlternativeString(mail.TypeTextHTML, contentHtml)
+
+	m.SetBodyString(mail.TypeTextPlain, plain)
+	m.AddAlternativeString(mail.TypeTextHTML, html)
 
 	for _, file := range email.AttachFiles {
 		m.AttachFile(file)
@@ -131,90 +383,97 @@ func (sender *MailTrapSender) SendEmail(
 		m.AttachFile(value, mail.WithFileName(key))
 	}
 
-	c, err := mail.NewClient(
-		sender.SMTPHost,
-		mail.WithPort(sender.SMTPPort),
-		mail.WithSMTPAuth(sender.SMTPAuth),
-		mail.WithUsername(sender.Login),
-		mail.WithPassword(sender.Password),
-	)
-	if err != nil {
-		errChan <- fmt.Errorf("failed to create mail client: %s", err)
+	for _, a := range email.Attachments {
+		if err := a.attach(m); err != nil {
+			return nil, permanent(err)
+		}
 	}
 
-	if err = c.DialAndSend(m); err != nil {
-		errChan <- err
+	if b.DKIM != nil {
+		if err := b.DKIM.Sign(m); err != nil {
+			return nil, permanent(err)
+		}
 	}
+
+	return m, nil
 }
 
-func buildHTMLMessage(templ string, message map[string]any) (string, error) {
-	t, err := template.New("email-html").ParseFiles(templ)
-	if err != nil {
-		return "", fmt.Errorf("failed to create template from %s: %s", templ, err)
+// messageIDHeader return the value of the Message-ID header
+// in the domain of the sender
+func messageIDHeader(id, fromEmail string) string {
+	domain := "localhost"
+	if i := strings.LastIndex(fromEmail, "@"); i >= 0 && i < len(fromEmail)-1 {
+		domain = fromEmail[i+1:]
 	}
+	return fmt.Sprintf("%s@%s", id, domain)
+}
 
-	var tpl bytes.Buffer
-
-	if err := t.ExecuteTemplate(&tpl, "body", message); err != nil {
-		return "", fmt.Errorf("failed execute template with message %v: %s", message, err)
-	}
+// smtpWorker send messages of a single worker through one smtp connection
+type smtpWorker struct {
+	sender   *SMTPSender
+	client   *mail.Client
+	lastUsed time.Time
+}
 
-	formattedMessage, err := inlineCSS(tpl.String())
+func (w *smtpWorker) SendEmail(email Message) error {
+	m, err := w.sender.buildMsg(email)
 	if err != nil {
-		return "", fmt.Errorf("failed generate inline CSS message from template: %s", err)
+		return err
 	}
-	return formattedMessage, nil
-}
 
-func inlineCSS(fm string) (string, error) {
-	options := premailer.Options{
-		RemoveClasses:     false,
-		CssToAttributes:   false,
-		KeepBangImportant: true,
+	// servers drop idle connections, do not wait for the error
+	if w.client != nil && time.Since(w.lastUsed) > w.sender.IdleTimeout {
+		_ = w.Close()
 	}
-
-	prem, err := premailer.NewPremailerFromString(fm, &options)
-	if err != nil {
-		return "", fmt.Errorf("failed create premailer from string %s: %s", fm, err)
+	if err := w.connect(); err != nil {
+		return err
 	}
 
-	html, err := prem.Transform()
+	err = w.client.Send(m)
+	var se *mail.SendError
+	if errors.As(err, &se) && se.Reason == mail.ErrConnCheck {
+		// connection was closed by the server, dial again once
+		_ = w.Close()
+		if err := w.connect(); err != nil {
+			return err
+		}
+		err = w.client.Send(m)
+	}
 	if err != nil {
-		return "", fmt.Errorf("failed transform premailer to string: %s", err)
+		// connection is in unknown state, next message will dial a new one
+		_ = w.Close()
+		return err
 	}
-	return html, nil
+
+	w.lastUsed = time.Now()
+	return nil
 }
 
-func builPlainTextMessage(templ string, message map[string]any) (string, error) {
-	t, err := template.New("email-plain").ParseFiles(templ)
+func (w *smtpWorker) connect() error {
+	if w.client != nil {
+		return nil
+	}
 
+	c, err := mail.NewClient(w.sender.SMTPHost, w.sender.clientOptions()...)
 	if err != nil {
-		return "", fmt.Errorf("failed to create template from %s: %s", templ, err)
+		return fmt.Errorf("failed to create mail client: %s", err)
 	}
 
-	var tpl bytes.Buffer
-
-	if err := t.ExecuteTemplate(&tpl, "body", message); err != nil {
-		return "", fmt.Errorf("failed execute template with message %v: %s", message, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := c.DialWithContext(ctx); err != nil {
+		return fmt.Errorf("failed to dial smtp server: %w", err)
 	}
 
-	return tpl.String(), nil
+	w.client = c
+	return nil
 }
 
-func (sender *MailTrapSender) WaitForSending() {
-	sender.Wg.Add(1)
-}
-
-func (app *Server) ListenForMail() {
-	for {
-		select {
-		case msg := <-app.Mail.MailerChan:
-			app.Mail.Sender.WaitForSending()
-			go app.Mail.Sender.SendEmail(msg, app.Mail.ErrChan)
-		case err := <-app.Mail.ErrChan:
-			log.Error().Err(err)
-		case <-app.Mail.DoneChan:
-			return
-		}
+func (w *smtpWorker) Close() error {
+	if w.client == nil {
+		return nil
 	}
+	err := w.client.Close()
+	w.client = nil
+	return err
 }
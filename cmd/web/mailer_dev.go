@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/dubass83/go-concurrency-project/utils"
+)
+
+const defaultMailboxSize = 200
+
+// FileSender write every message as an .eml file into the directory,
+// it is used for development without any mail server
+type FileSender struct {
+	msgBuilder
+	Dir string
+}
+
+func newFileSender(conf utils.Config, builder msgBuilder) (*FileSender, error) {
+	dir := conf.MailFileDir
+	if dir == "" {
+		dir = filepath.Join(conf.PathToTmp, "mail")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create mail directory %s: %s", dir, err)
+	}
+
+	return &FileSender{
+		msgBuilder: builder,
+		Dir:        dir,
+	}, nil
+}
+
+func (sender *FileSender) SendEmail(email Message) error {
+	m, err := sender.buildMsg(email)
+	if err != nil {
+		return err
+	}
+
+	// the time prefix keep files sorted in the order of sending
+	f, err := os.CreateTemp(sender.Dir, time.Now().Format("20060102-150405.000000")+"-*.eml")
+	if err != nil {
+		return fmt.Errorf("failed to create mail file: %s", err)
+	}
+	defer f.Close()
+
+	if _, err := m.WriteTo(f); err != nil {
+		return fmt.Errorf("failed to write mail file %s: %s", f.Name(), err)
+	}
+	return nil
+}
+
+// NewWorker return the sender itself, files do not need a connection
+func (sender *FileSender) NewWorker() EmailWorker {
+	return sender
+}
+
+func (sender *FileSender) Close() error {
+	return nil
+}
+
+// CapturedMail is a message kept by the MemorySender
+type CapturedMail struct {
+	ID          int
+	MessageID   string
+	SentAt      time.Time
+	From        string
+	To          []string
+	CC          []string
+	BCC         []string
+	Subject     string
+	Template    string
+	Plain       string
+	HTML        string
+	Attachments []string
+	Raw         []byte
+}
+
+// MemorySender keep the last messages in an in-process inbox,
+// they can be inspected by tests or on the /dev/mailbox page
+type MemorySender struct {
+	msgBuilder
+	mu     sync.Mutex
+	size   int
+	lastID int
+	inbox  []CapturedMail
+}
+
+func NewMemorySender(builder msgBuilder, size int) *MemorySender {
+	if size <= 0 {
+		size = defaultMailboxSize
+	}
+	return &MemorySender{
+		msgBuilder: builder,
+		size:       size,
+	}
+}
+
+func (sender *MemorySender) SendEmail(email Message) error {
+	email, plain, html, err := sender.prepare(email)
+	if err != nil {
+		return err
+	}
+	m, err := sender.compose(email, plain, html)
+	if err != nil {
+		return err
+	}
+
+	var raw bytes.Buffer
+	if _, err := m.WriteTo(&raw); err != nil {
+		return fmt.Errorf("failed to write mail: %s", err)
+	}
+
+	captured := CapturedMail{
+		SentAt:   time.Now(),
+		From:     fmt.Sprintf("%s <%s>", email.From, email.FromEmail),
+		To:       email.To,
+		CC:       email.CC,
+		BCC:      email.BCC,
+		Subject:  email.Subject,
+		Template: email.Template,
+		Plain:    plain,
+		HTML:     html,
+		Raw:      raw.Bytes(),
+	}
+	if email.MessageID != "" {
+		captured.MessageID = messageIDHeader(email.MessageID, email.FromEmail)
+	}
+	for _, file := range email.AttachFiles {
+		captured.Attachments = append(captured.Attachments, filepath.Base(file))
+	}
+	for name := range email.AttachmentMap {
+		captured.Attachments = append(captured.Attachments, name)
+	}
+	for _, a := range email.Attachments {
+		captured.Attachments = append(captured.Attachments, a.Name)
+	}
+
+	sender.mu.Lock()
+	defer sender.mu.Unlock()
+
+	sender.lastID++
+	captured.ID = sender.lastID
+	sender.inbox = append(sender.inbox, captured)
+	// drop the oldest messages
+	if len(sender.inbox) > sender.size {
+		sender.inbox = append([]CapturedMail(nil), sender.inbox[len(sender.inbox)-sender.size:]...)
+	}
+	return nil
+}
+
+// NewWorker return the sender itself, all workers share the same inbox
+func (sender *MemorySender) NewWorker() EmailWorker {
+	return sender
+}
+
+func (sender *MemorySender) Close() error {
+	return nil
+}
+
+// Messages return captured messages, the newest first
+func (sender *MemorySender) Messages() []CapturedMail {
+	sender.mu.Lock()
+	defer sender.mu.Unlock()
+
+	result := make([]CapturedMail, 0, len(sender.inbox))
+	for i := len(sender.inbox) - 1; i >= 0; i-- {
+		result = append(result, sender.inbox[i])
+	}
+	return result
+}
+
+// Message return the captured message by its id
+func (sender *MemorySender) Message(id int) (CapturedMail, bool) {
+	sender.mu.Lock()
+	defer sender.mu.Unlock()
+
+	for _, captured := range sender.inbox {
+		if captured.ID == id {
+			return captured, true
+		}
+	}
+	return CapturedMail{}, false
+}
+
+// Clear remove all captured messages
+func (sender *MemorySender) Clear() {
+	sender.mu.Lock()
+	defer sender.mu.Unlock()
+
+	sender.inbox = nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dubass83/go-concurrency-project/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileSender(t *testing.T) {
+	dir := t.TempDir()
+
+	sender, err := NewMailSender(utils.Config{
+		EmailService: "file",
+		MailFileDir:  dir,
+		SenderName:   "Dummy",
+		SenderEmail:  "no-reply@example.com",
+	}, testApp.Mail.Templates, nil)
+	require.NoError(t, err)
+
+	worker := sender.NewWorker()
+	defer worker.Close()
+
+	err = worker.SendEmail(Message{
+		To:       []string{"user@example.com"},
+		Subject:  "Activate your account",
+		Template: mailTemplateConfirmation,
+		Data:     "http://localhost:8080/activate?email=user@example.com",
+	})
+	require.NoError(t, err)
+
+	err = worker.SendEmail(Message{
+		To:       []string{"user@example.com"},
+		Template: "invoce",
+	})
+	require.Error(t, err)
+	require.True(t, isPermanentMailError(err))
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	eml, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+	require.Contains(t, string(eml), "Subject: Activate your account")
+	require.Contains(t, string(eml), "To: <user@example.com>")
+}
+
+func TestMemorySender(t *testing.T) {
+	sender := NewMemorySender(newMsgBuilder(testApp.Config, testApp.Mail.Templates), 3)
+
+	for i := range 5 {
+		err := sender.SendEmail(Message{
+			To:      []string{"user@example.com"},
+			Subject: fmt.Sprintf("message %d", i),
+			Data:    "invalid login attempt!",
+		})
+		require.NoError(t, err)
+	}
+
+	messages := sender.Messages()
+	require.Len(t, messages, 3, "only the last messages are kept")
+	require.Equal(t, "message 4", messages[0].Subject, "the newest message is first")
+	require.Equal(t, mailTemplateDefault, messages[0].Template)
+	require.Contains(t, messages[0].Plain, "invalid login attempt!")
+	require.Contains(t, messages[0].HTML, "invalid login attempt!")
+
+	err := sender.SendEmail(Message{
+		MessageID: "5b0e3c1a",
+		To:        []string{"user@example.com"},
+		FromEmail: "no-reply@dubass83.xyz",
+	})
+	require.NoError(t, err)
+	require.Contains(t, string(sender.Messages()[0].Raw), "Message-ID: <5b0e3c1a@dubass83.xyz>")
+
+	captured, ok := sender.Message(messages[1].ID)
+	require.True(t, ok)
+	require.Equal(t, "message 3", captured.Subject)
+
+	_, ok = sender.Message(1)
+	require.False(t, ok, "the oldest message is dropped")
+
+	sender.Clear()
+	require.Empty(t, sender.Messages())
+}
+
+func TestMailbox(t *testing.T) {
+	require.NotNil(t, testApp.Mail.Mailbox, "tests keep messages in memory")
+
+	mailbox := testApp.Mail.Mailbox
+	mailbox.Clear()
+	err := mailbox.SendEmail(Message{
+		To:      []string{"mailbox@example.com"},
+		Subject: "Yuor invoice",
+		Data:    "invalid login attempt!",
+	})
+	require.NoError(t, err)
+	id := mailbox.Messages()[0].ID
+
+	mailboxTests := []struct {
+		name               string
+		method             string
+		url                string
+		expectedStatusCode int
+		expectedBody       string
+	}{
+		{
+			name:               "list",
+			method:             "GET",
+			url:                "/dev/mailbox",
+			expectedStatusCode: http.StatusOK,
+			expectedBody:       "mailbox@example.com",
+		},
+		{
+			name:               "html",
+			method:             "GET",
+			url:                fmt.Sprintf("/dev/mailbox/message?id=%d", id),
+			expectedStatusCode: http.StatusOK,
+			expectedBody:       "invalid login attempt!",
+		},
+		{
+			name:               "raw",
+			method:             "GET",
+			url:                fmt.Sprintf("/dev/mailbox/message?id=%d&format=raw", id),
+			expectedStatusCode: http.StatusOK,
+			expectedBody:       "Subject: Yuor invoice",
+		},
+		{
+			name:               "notFound",
+			method:             "GET",
+			url:                "/dev/mailbox/message?id=0",
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "clear",
+			method:             "POST",
+			url:                "/dev/mailbox/clear",
+			expectedStatusCode: http.StatusSeeOther,
+		},
+	}
+
+	for _, mt := range mailboxTests {
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest(mt.method, mt.url, nil)
+
+		testApp.Router.ServeHTTP(rr, req)
+
+		require.Equal(t, mt.expectedStatusCode, rr.Code, fmt.Sprintf("test name: %s", mt.name))
+		if len(mt.expectedBody) > 0 {
+			require.Contains(t, rr.Body.String(), mt.expectedBody, fmt.Sprintf("test name: %s", mt.name))
+		}
+	}
+
+	require.Empty(t, mailbox.Messages())
+}
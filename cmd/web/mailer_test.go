@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/dubass83/go-concurrency-project/utils"
+	"github.com/stretchr/testify/require"
+	"github.com/wneessen/go-mail"
+)
+
+func TestNewMailSender(t *testing.T) {
+
+	mailSenderTests := []struct {
+		name          string
+		conf          utils.Config
+		expectedError bool
+		checkSender   func(sender *SMTPSender)
+	}{
+		{
+			name: "mailtrap",
+			conf: utils.Config{
+				Enviroment:      productionEnviroment,
+				EmailService:    "mailtrap",
+				EmailEncryption: "starttls",
+				EmailLogin:      "login",
+				EmailPassword:   "password",
+			},
+			checkSender: func(sender *SMTPSender) {
+				require.Equal(t, mailtrapHost, sender.SMTPHost)
+				require.Equal(t, mailtrapPort, sender.SMTPPort)
+				require.Equal(t, mail.SMTPAuthPlain, sender.SMTPAuth)
+				require.Equal(t, mail.TLSMandatory, sender.SMTPTLSPolicy)
+			},
+		},
+		{
+			name: "mailhog",
+			conf: utils.Config{
+				Enviroment:      productionEnviroment,
+				EmailService:    "smtp",
+				EmailHost:       "localhost",
+				EmailPort:       1025,
+				EmailAuth:       "none",
+				EmailEncryption: "none",
+			},
+			checkSender: func(sender *SMTPSender) {
+				require.Equal(t, "localhost", sender.SMTPHost)
+				require.Equal(t, 1025, sender.SMTPPort)
+				require.Equal(t, mail.SMTPAuthNoAuth, sender.SMTPAuth)
+				require.Equal(t, mail.NoTLS, sender.SMTPTLSPolicy)
+				require.False(t, sender.SMTPSSL)
+			},
+		},
+		{
+			name: "implicitTLS",
+			conf: utils.Config{
+				Enviroment:      productionEnviroment,
+				EmailService:    "smtp",
+				EmailHost:       "smtp.example.com",
+				EmailAuth:       "login",
+				EmailEncryption: "ssl",
+			},
+			checkSender: func(sender *SMTPSender) {
+				require.Equal(t, 465, sender.SMTPPort)
+				require.Equal(t, mail.SMTPAuthLogin, sender.SMTPAuth)
+				require.True(t, sender.SMTPSSL)
+			},
+		},
+		{
+			name: "starttlsDefaultPort",
+			conf: utils.Config{
+				Enviroment:      productionEnviroment,
+				EmailService:    "smtp",
+				EmailHost:       "smtp.example.com",
+				EmailAuth:       "plain",
+				EmailEncryption: "STARTTLS",
+			},
+			checkSender: func(sender *SMTPSender) {
+				require.Equal(t, 587, sender.SMTPPort)
+				require.Equal(t, mail.TLSMandatory, sender.SMTPTLSPolicy)
+			},
+		},
+		{
+			name: "unknownEncryption",
+			conf: utils.Config{
+				Enviroment:      productionEnviroment,
+				EmailService:    "smtp",
+				EmailHost:       "smtp.example.com",
+				EmailEncryption: "pgp",
+			},
+			expectedError: true,
+		},
+		{
+			name: "unknownAuth",
+			conf: utils.Config{
+				Enviroment:   productionEnviroment,
+				EmailService: "smtp",
+				EmailHost:    "smtp.example.com",
+				EmailAuth:    "kerberos",
+			},
+			expectedError: true,
+		},
+		{
+			name: "missingHost",
+			conf: utils.Config{
+				Enviroment:   productionEnviroment,
+				EmailService: "smtp",
+			},
+			expectedError: true,
+		},
+		{
+			name: "notRedirected",
+			conf: utils.Config{
+				Enviroment:   "devel",
+				EmailService: "smtp",
+				EmailHost:    "localhost",
+			},
+			expectedError: true,
+		},
+		{
+			name: "redirected",
+			conf: utils.Config{
+				Enviroment:     "devel",
+				EmailService:   "smtp",
+				EmailHost:      "localhost",
+				MailRedirectTo: "qa@dubass83.xyz",
+			},
+			checkSender: func(sender *SMTPSender) {
+				require.NotNil(t, sender.Redirect)
+			},
+		},
+		{
+			name: "unknownService",
+			conf: utils.Config{
+				EmailService: "pigeon",
+			},
+			expectedError: true,
+		},
+	}
+
+	for _, mt := range mailSenderTests {
+		sender, err := NewMailSender(mt.conf, testApp.Mail.Templates, nil)
+		if mt.expectedError {
+			require.Error(t, err, fmt.Sprintf("test name: %s", mt.name))
+			continue
+		}
+		require.NoError(t, err, fmt.Sprintf("test name: %s", mt.name))
+
+		smtpSender, ok := sender.(*SMTPSender)
+		require.True(t, ok, fmt.Sprintf("test name: %s", mt.name))
+		mt.checkSender(smtpSender)
+	}
+}
@@ -55,27 +55,69 @@ func main() {
 			Msg("cannot ping the database from the connection pool")
 	}
 
+	// load keys which sign tokens
+	keys, err := newSigningKeys(conf)
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("failed to load token signing keys")
+	}
+	keys.logSchedule()
+
+	urls, err := newURLBuilder(conf)
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("failed to set up the public base url")
+	}
+	if urls.Base == "" && isProduction(conf.Enviroment) {
+		log.Fatal().
+			Str("fallback", urls.Fallback).
+			Msg("PUBLIC_BASE_URL must be set in production, links of emails would point to the fallback")
+	}
+
 	// create sessions
-	session := initSessions(conf)
+	redisPool := initRedis(conf)
+	session := initSessions(redisPool)
 
 	// create channels
-	mailChan := make(chan Message, 100)
+	mailChan := make(chan Message, mailQueueSize(conf))
+	bulkChan := make(chan Message, mailQueueSize(conf))
 	errChan := make(chan error)
 	doneChan := make(chan bool)
 
 	// set up mail
-	sender, err := NewMailSender(conf)
+	templates, err := initMailTemplates(conf)
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("failed to load mail templates")
+	}
+	sender, err := NewMailSender(conf, templates, newMailTracking(conf, keys, urls))
 	if err != nil {
 		log.Fatal().
 			Err(err).
 			Msg("failed to create new mail sender")
 	}
 	mail := Mail{
-		MailerChan: mailChan,
-		ErrChan:    errChan,
-		DoneChan:   doneChan,
-		Sender:     sender,
+		MailerChan:     mailChan,
+		BulkChan:       bulkChan,
+		ErrChan:        errChan,
+		DoneChan:       doneChan,
+		OutboxChan:     make(chan struct{}, 1),
+		OutboxDoneChan: make(chan bool),
+		Sender:         sender,
+		Templates:      templates,
+		Broadcasts:     NewBroadcasts(),
 	}
+	// captured messages are shown on the /dev/mailbox page
+	mail.Mailbox, _ = sender.(*MemorySender)
+	// stop sending while the mail server is down
+	mail.Breaker = newMailBreaker(conf, sender)
+	mail.Sender = mail.Breaker
+	// protect recipients from floods of the same email
+	mail.Throttle = &RedisMailThrottle{Pool: redisPool}
+	mail.ThrottleWindows = mailThrottleWindows(conf)
 
 	// create waitgroup
 	wg := sync.WaitGroup{}
@@ -88,6 +130,9 @@ func main() {
 		Session:     session,
 		Wait:        &wg,
 		Mail:        mail,
+		URLs:        urls,
+		Keys:        keys,
+		Tokens:      NewTokenService(keys, &RedisNonceLedger{Pool: redisPool}),
 		ErrChan:     make(chan error),
 		ErrChanDone: make(chan bool),
 	}
@@ -97,6 +142,9 @@ func main() {
 	// run db migration
 	app.runDbMigration()
 
+	// listen for messages stored in the mail outbox
+	go app.ListenForOutbox()
+
 	// listen for the signals
 	go app.ListenForShutdown()
 
@@ -154,7 +202,7 @@ func poolConfig(conf utils.Config) *pgxpool.Config {
 	return dbConfig
 }
 
-func initSessions(config utils.Config) *scs.SessionManager {
+func initSessions(redisPool *redis.Pool) *scs.SessionManager {
 	gob.Register(data.User{})
 	gob.Register(data.UserPlan{})
 	gob.Register(pgtype.Int4{})
@@ -162,7 +210,7 @@ func initSessions(config utils.Config) *scs.SessionManager {
 	gob.Register(pgtype.Timestamp{})
 	// setup session
 	session := scs.New()
-	session.Store = redisstore.New(initRedis(config))
+	session.Store = redisstore.New(redisPool)
 	session.Lifetime = 24 * time.Hour
 	session.Cookie.Persist = true
 	session.Cookie.SameSite = http.SameSiteLaxMode
@@ -191,6 +239,8 @@ func (app *Server) ListenForShutdown() {
 
 func (app *Server) shutdown() {
 	log.Info().Msg("starting shutdown process for the app...")
+	app.Mail.Broadcasts.CancelAll()
+	app.Mail.OutboxDoneChan <- true
 	app.Wait.Wait()
 	app.Mail.DoneChan <- true
 	app.ErrChanDone <- true
@@ -198,8 +248,11 @@ func (app *Server) shutdown() {
 	log.Info().Msg("all chanels will be stoped and app will be prepared for gracefully shutdown")
 	// TODO close all chanels
 	close(app.Mail.MailerChan)
+	close(app.Mail.BulkChan)
 	close(app.Mail.ErrChan)
 	close(app.Mail.DoneChan)
+	close(app.Mail.OutboxChan)
+	close(app.Mail.OutboxDoneChan)
 	close(app.ErrChan)
 	close(app.ErrChanDone)
 }
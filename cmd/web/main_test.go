@@ -45,23 +45,56 @@ func TestMain(m *testing.M) {
 	session.Cookie.Secure = true
 
 	// create channels
-	mailChan := make(chan Message, 100)
+	mailChan := make(chan Message, mailQueueSize(config))
+	bulkChan := make(chan Message, mailQueueSize(config))
 	errChan := make(chan error)
 	doneChan := make(chan bool)
 
+	keys, err := newSigningKeys(config)
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("failed to load token signing keys")
+	}
+
+	urls, err := newURLBuilder(config)
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("failed to set up the public base url")
+	}
+
 	// set up mail
-	sender, err := NewMailSender(config)
+	templates, err := initMailTemplates(config)
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("failed to load mail templates")
+	}
+	sender, err := NewMailSender(config, templates, newMailTracking(config, keys, urls))
 	if err != nil {
 		log.Fatal().
 			Err(err).
 			Msg("failed to create new mail sender")
 	}
 	mail := Mail{
-		MailerChan: mailChan,
-		ErrChan:    errChan,
-		DoneChan:   doneChan,
-		Sender:     sender,
+		MailerChan:     mailChan,
+		BulkChan:       bulkChan,
+		ErrChan:        errChan,
+		DoneChan:       doneChan,
+		OutboxChan:     make(chan struct{}, 1),
+		OutboxDoneChan: make(chan bool),
+		Sender:         sender,
+		Templates:      templates,
+		Broadcasts:     NewBroadcasts(),
 	}
+	// captured messages are shown on the /dev/mailbox page
+	mail.Mailbox, _ = sender.(*MemorySender)
+	// stop sending while the mail server is down
+	mail.Breaker = newMailBreaker(config, sender)
+	mail.Sender = mail.Breaker
+	mail.Throttle = newMemoryMailThrottle()
+	mail.ThrottleWindows = mailThrottleWindows(config)
 
 	testApp = Server{
 		Config:      config,
@@ -70,6 +103,9 @@ func TestMain(m *testing.M) {
 		Session:     session,
 		Wait:        &sync.WaitGroup{},
 		Mail:        mail,
+		URLs:        urls,
+		Keys:        keys,
+		Tokens:      NewTokenService(keys, newMemoryNonceLedger()),
 		ErrChan:     make(chan error),
 		ErrChanDone: make(chan bool),
 	}
@@ -78,7 +114,7 @@ func TestMain(m *testing.M) {
 		for {
 			select {
 			case <-testApp.Mail.MailerChan:
-				testApp.Wait.Done()
+			case <-testApp.Mail.OutboxChan:
 			case <-testApp.Mail.ErrChan:
 			case <-testApp.Mail.DoneChan:
 				return
@@ -3,6 +3,7 @@ package main
 import (
 	"net/http"
 
+	data "github.com/dubass83/go-concurrency-project/data/sqlc"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/rs/zerolog/log"
 )
@@ -29,3 +30,15 @@ func (app *Server) Auth(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+func (app *Server) Admin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := app.Session.Get(r.Context(), "user").(data.User)
+		if !ok || user.IsAdmin.Int32 != 1 {
+			app.Session.Put(r.Context(), "error", "Admins only!")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
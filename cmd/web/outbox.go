@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	data "github.com/dubass83/go-concurrency-project/data/sqlc"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultOutboxPollInterval = 2 * time.Second
+	defaultOutboxBatchSize    = 10
+	defaultOutboxLease        = 5 * time.Minute
+)
+
+// statuses of the message delivery history, the first one is data.MailEventQueued
+const (
+	mailEventSending  = "sending"
+	mailEventSent     = "sent"
+	mailEventRetried  = "retried"
+	mailEventFailed   = "failed"
+	mailEventSkipped  = "skipped"
+	mailEventReleased = "released"
+	// reported by the mail provider webhook
+	mailEventBounced    = "bounced"
+	mailEventComplained = "complained"
+)
+
+// outboxPayload serialize messages for storing in the mail outbox
+func outboxPayload(msgs ...Message) ([][]byte, error) {
+	payloads := make([][]byte, 0, len(msgs))
+	for _, msg := range msgs {
+		msg, err := bufferAttachments(msg)
+		if err != nil {
+			return nil, err
+		}
+		// the outbox claim transactional messages first
+		msg.Priority = messagePriority(msg)
+		payload, err := json.Marshal(msg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal message %q: %s", msg.Subject, err)
+		}
+		payloads = append(payloads, payload)
+	}
+	return payloads, nil
+}
+
+// messageFromOutbox restore a message from the claimed outbox row
+func messageFromOutbox(row data.MailOutbox) (Message, error) {
+	var msg Message
+	if err := json.Unmarshal(row.Payload, &msg); err != nil {
+		return Message{}, fmt.Errorf("failed to unmarshal outbox message %d: %s", row.ID, err)
+	}
+	msg.OutboxID = row.ID
+	msg.MessageID = row.MessageID
+	msg.Attempt = int(row.Attempts) + 1
+	return msg, nil
+}
+
+// enqueueMail store the message in the mail outbox outside of any transaction,
+// emails suppressed by the throttle are dropped without an error
+func (app *Server) enqueueMail(ctx context.Context, msg Message) error {
+	msg, ok := app.throttleMail(ctx, msg)
+	if !ok {
+		return nil
+	}
+
+	payloads, err := outboxPayload(msg)
+	if err != nil {
+		return err
+	}
+	if _, err := app.Store.EnqueueMailTx(ctx, payloads); err != nil {
+		return fmt.Errorf("failed to insert message to outbox: %s", err)
+	}
+	app.wakeOutbox()
+	return nil
+}
+
+// wakeOutbox ask the outbox poller to check for new messages without waiting for the next tick
+func (app *Server) wakeOutbox() {
+	select {
+	case app.Mail.OutboxChan <- struct{}{}:
+	default:
+	}
+}
+
+// ListenForOutbox periodically claim pending messages from the mail outbox
+// and hand them to the mail listener
+func (app *Server) ListenForOutbox() {
+	interval := app.Config.MailOutboxPollInterval
+	if interval == 0 {
+		interval = defaultOutboxPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			app.pollOutbox()
+		case <-app.Mail.OutboxChan:
+			app.pollOutbox()
+		case <-app.Mail.OutboxDoneChan:
+			log.Info().Msg("finished listen for the mail outbox")
+			return
+		}
+	}
+}
+
+func (app *Server) pollOutbox() {
+	batchSize := app.Config.MailOutboxBatchSize
+	if batchSize == 0 {
+		batchSize = defaultOutboxBatchSize
+	}
+	lease := app.Config.MailOutboxLease
+	if lease == 0 {
+		lease = defaultOutboxLease
+	}
+
+	// messages wait in the outbox until the breaker is ready for the probe
+	if app.Mail.Breaker.Open() {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	saturated := true
+	for _, priority := range mailPriorities {
+		// every lane claim no more than its mail workers can take, the rest wait in the outbox
+		lane := app.laneChan(priority)
+		free := int32(cap(lane) - len(lane))
+		if free <= 0 {
+			continue
+		}
+		saturated = false
+		if free > batchSize {
+			free = batchSize
+		}
+
+		rows, err := app.Store.ClaimMailOutbox(ctx, data.ClaimMailOutboxParams{
+			LeaseSeconds: int32(lease.Seconds()),
+			Priority:     outboxPriority(priority),
+			BatchSize:    free,
+		})
+		if err != nil {
+			log.Error().Err(err).Str("priority", priority).Msg("failed to claim messages from the mail outbox")
+			return
+		}
+		app.dispatchOutbox(ctx, rows)
+	}
+	if saturated {
+		mailMetrics.Add("queue_saturated", 1)
+		log.Warn().Msg("mail queue is full, outbox messages wait for a free mail worker")
+	}
+}
+
+// dispatchOutbox hand the claimed messages to mail workers, messages which are not sent
+// to anybody are skipped and messages which do not fit into the lane are released
+func (app *Server) dispatchOutbox(ctx context.Context, rows []data.MailOutbox) {
+	// lanes which did not take a message, the rest of their messages are released
+	full := make(map[string]error)
+	for _, row := range rows {
+		msg, err := messageFromOutbox(row)
+		if err != nil {
+			app.deadOutbox(row.ID, err)
+			continue
+		}
+		msg, ok, err := app.filterSuppressed(ctx, msg)
+		if err != nil {
+			app.failOutbox(msg, err)
+			continue
+		}
+		if !ok {
+			app.skipOutbox(msg.OutboxID, "recipient addresses are suppressed")
+			continue
+		}
+		msg, ok, err = app.filterUnsubscribed(ctx, msg)
+		if err != nil {
+			app.failOutbox(msg, err)
+			continue
+		}
+		if !ok {
+			app.skipOutbox(msg.OutboxID, fmt.Sprintf("recipients opted out of %s emails", messageCategory(msg)))
+			continue
+		}
+		msg = app.withUnsubscribe(msg)
+		priority := messagePriority(msg)
+		if err, ok := full[priority]; ok {
+			app.releaseOutbox(msg.OutboxID, err)
+			continue
+		}
+		app.recordMailEvent(msg.OutboxID, mailEventSending, fmt.Sprintf("attempt %d", msg.Attempt))
+		if err := app.dispatchMail(ctx, msg); err != nil {
+			// released messages are claimed again on the next poll
+			full[priority] = err
+			app.releaseOutbox(msg.OutboxID, err)
+		}
+	}
+}
+
+// releaseOutbox return the claimed message to the outbox without counting an attempt
+func (app *Server) releaseOutbox(id int64, reason error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := app.Store.ReleaseMailOutbox(ctx, id); err != nil {
+		// the lease expires and the message is claimed again anyway
+		log.Error().Err(err).Int64("outbox_id", id).Msg("failed to release outbox message")
+		return
+	}
+	mailMetrics.Add("released", 1)
+	app.recordMailEvent(id, mailEventReleased, reason.Error())
+}
+
+// deliverMail send the message and record the result in the mail outbox
+func (app *Server) deliverMail(worker EmailWorker, msg Message) {
+	err := worker.SendEmail(msg)
+	if errors.Is(err, errCircuitOpen) {
+		// the message was not sent at all, it does not count as an attempt
+		mailMetrics.Add("breaker_rejected", 1)
+		if msg.OutboxID == 0 {
+			app.Mail.ErrChan <- err
+			return
+		}
+		app.releaseOutbox(msg.OutboxID, err)
+		return
+	}
+	if err != nil {
+		mailMetrics.Add("failed", 1)
+	} else {
+		mailMetrics.Add("sent", 1)
+	}
+
+	if msg.OutboxID == 0 {
+		if err != nil {
+			app.Mail.ErrChan <- err
+		}
+		return
+	}
+
+	if err != nil {
+		app.failOutbox(msg, err)
+		app.Mail.ErrChan <- err
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := app.Store.MarkMailOutboxSent(ctx, msg.OutboxID); err != nil {
+		log.Error().Err(err).Int64("outbox_id", msg.OutboxID).Msg("failed to mark outbox message as sent")
+	}
+	app.recordMailEvent(msg.OutboxID, mailEventSent, "")
+}
+
+// failOutbox schedule the next attempt for the message
+// or move it to the dead letters when retrying will not help
+func (app *Server) failOutbox(msg Message, sendErr error) {
+	policy := app.retryPolicy(msg)
+	if isPermanentMailError(sendErr) || msg.Attempt >= policy.MaxAttempts {
+		app.deadOutbox(msg.OutboxID, sendErr)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	delay := policy.Backoff(msg.Attempt)
+	err := app.Store.RetryMailOutbox(ctx, data.RetryMailOutboxParams{
+		ID: msg.OutboxID,
+		LastError: pgtype.Text{
+			String: sendErr.Error(),
+			Valid:  true,
+		},
+		DelaySeconds: int32(delay.Seconds()),
+	})
+	if err != nil {
+		log.Error().Err(err).Int64("outbox_id", msg.OutboxID).Msg("failed to schedule retry of outbox message")
+		return
+	}
+	app.recordMailEvent(msg.OutboxID, mailEventRetried, fmt.Sprintf("%s, next attempt in %s", sendErr, delay.Round(time.Second)))
+	log.Warn().
+		Int64("outbox_id", msg.OutboxID).
+		Int("attempt", msg.Attempt).
+		Dur("delay", delay).
+		Msg("email will be retried")
+}
+
+// skipOutbox close the message which has nobody to be sent to
+func (app *Server) skipOutbox(id int64, reason string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := app.Store.MarkMailOutboxSkipped(ctx, id); err != nil {
+		log.Error().Err(err).Int64("outbox_id", id).Msg("failed to mark outbox message as skipped")
+		return
+	}
+	app.recordMailEvent(id, mailEventSkipped, reason)
+}
+
+// deadOutbox move the message to the dead letters, admin can re-drive it later
+func (app *Server) deadOutbox(id int64, sendErr error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := app.Store.MarkMailOutboxDead(ctx, data.MarkMailOutboxDeadParams{
+		ID: id,
+		LastError: pgtype.Text{
+			String: sendErr.Error(),
+			Valid:  true,
+		},
+	})
+	if err != nil {
+		log.Error().Err(err).Int64("outbox_id", id).Msg("failed to move outbox message to dead letters")
+		return
+	}
+	app.recordMailEvent(id, mailEventFailed, sendErr.Error())
+}
+
+// recordMailEvent add the status to the delivery history of the message,
+// the history is informational so errors are only logged
+func (app *Server) recordMailEvent(id int64, status, detail string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := app.Store.InsertMailEvent(ctx, data.InsertMailEventParams{
+		OutboxID: id,
+		Status:   status,
+		Detail: pgtype.Text{
+			String: detail,
+			Valid:  detail != "",
+		},
+	})
+	if err != nil {
+		log.Error().Err(err).Int64("outbox_id", id).Str("status", status).Msg("failed to record mail event")
+	}
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"fmt"
+	"net/textproto"
+	"testing"
+	"time"
+
+	mockdb "github.com/dubass83/go-concurrency-project/data/mock"
+	data "github.com/dubass83/go-concurrency-project/data/sqlc"
+	"github.com/dubass83/go-concurrency-project/utils"
+	"github.com/golang/mock/gomock"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutboxPayload(t *testing.T) {
+	msg := Message{
+		MessageID: "4f1c",
+		Subject:   "Yuor invoice",
+		To:        []string{"user@example.com"},
+		Template:  "invoice",
+		Data:      "$10.00",
+		AttachmentMap: map[string]string{
+			"Manual.pdf": "./tmp/1_user_manual.pdf",
+		},
+		OutboxID: 42,
+	}
+
+	payloads, err := outboxPayload(msg)
+	require.NoError(t, err)
+	require.Len(t, payloads, 1)
+	require.NotContains(t, string(payloads[0]), "42", "outbox id must not be stored in the payload")
+
+	require.NotContains(t, string(payloads[0]), "4f1c", "message id is stored in its own column")
+
+	restored, err := messageFromOutbox(data.MailOutbox{
+		ID:        7,
+		Payload:   payloads[0],
+		MessageID: "7a2e",
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(7), restored.OutboxID)
+	require.Equal(t, "7a2e", restored.MessageID)
+	require.Equal(t, msg.Subject, restored.Subject)
+	require.Equal(t, msg.To, restored.To)
+	require.Equal(t, msg.Template, restored.Template)
+	require.Equal(t, msg.Data, restored.Data)
+	require.Equal(t, msg.AttachmentMap, restored.AttachmentMap)
+
+	_, err = messageFromOutbox(data.MailOutbox{
+		ID:      8,
+		Payload: []byte("not a json"),
+	})
+	require.Error(t, err)
+}
+
+func TestFailOutbox(t *testing.T) {
+
+	failOutboxTests := []struct {
+		name       string
+		msg        Message
+		err        error
+		buildStubs func(store *mockdb.MockStore)
+	}{
+		{
+			name: "transientError",
+			msg:  Message{OutboxID: 1, Attempt: 1},
+			err:  &textproto.Error{Code: 421, Msg: "service not available"},
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					RetryMailOutbox(gomock.Any(), gomock.Any()).
+					Times(1).
+					DoAndReturn(func(_ any, arg data.RetryMailOutboxParams) error {
+						require.Equal(t, int64(1), arg.ID)
+						require.Positive(t, arg.DelaySeconds)
+						return nil
+					})
+
+				store.EXPECT().
+					InsertMailEvent(gomock.Any(), gomock.Any()).
+					Times(1).
+					DoAndReturn(func(_ any, arg data.InsertMailEventParams) error {
+						require.Equal(t, mailEventRetried, arg.Status)
+						require.Contains(t, arg.Detail.String, "421")
+						return nil
+					})
+			},
+		},
+		{
+			name: "permanentError",
+			msg:  Message{OutboxID: 2, Attempt: 1},
+			err:  &textproto.Error{Code: 550, Msg: "no such user"},
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					MarkMailOutboxDead(gomock.Any(), gomock.Any()).
+					Times(1).
+					Return(nil)
+
+				store.EXPECT().
+					InsertMailEvent(gomock.Any(), gomock.Any()).
+					Times(1).
+					DoAndReturn(func(_ any, arg data.InsertMailEventParams) error {
+						require.Equal(t, mailEventFailed, arg.Status)
+						return nil
+					})
+			},
+		},
+		{
+			name: "attemptsExhausted",
+			msg: Message{
+				OutboxID: 3,
+				Attempt:  2,
+				Retry:    &RetryPolicy{MaxAttempts: 2},
+			},
+			err: &textproto.Error{Code: 421, Msg: "service not available"},
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					MarkMailOutboxDead(gomock.Any(), gomock.Any()).
+					Times(1).
+					Return(nil)
+
+				store.EXPECT().
+					InsertMailEvent(gomock.Any(), gomock.Any()).
+					Times(1).
+					DoAndReturn(func(_ any, arg data.InsertMailEventParams) error {
+						require.Equal(t, mailEventFailed, arg.Status)
+						return nil
+					})
+			},
+		},
+	}
+
+	for _, ft := range failOutboxTests {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := mockdb.NewMockStore(ctrl)
+		ft.buildStubs(store)
+
+		testApp.Store = store
+
+		testApp.failOutbox(ft.msg, ft.err)
+	}
+}
+
+func TestPollOutboxBackpressure(t *testing.T) {
+	outboxRows := func(ids ...int64) []data.MailOutbox {
+		var rows []data.MailOutbox
+		for _, id := range ids {
+			payloads, err := outboxPayload(Message{
+				To:       []string{"user@example.com"},
+				Template: mailTemplateConfirmation,
+			})
+			require.NoError(t, err)
+			rows = append(rows, data.MailOutbox{ID: id, Payload: payloads[0]})
+		}
+		return rows
+	}
+
+	pollTests := []struct {
+		name       string
+		queued     int
+		buildStubs func(store *mockdb.MockStore)
+		dispatched int
+	}{
+		{
+			name:   "queueIsFull",
+			queued: 2,
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					ClaimMailOutbox(gomock.Any(), gomock.Any()).
+					Times(0)
+			},
+			dispatched: 0,
+		},
+		{
+			name:   "batchIsLimitedByFreeSlots",
+			queued: 1,
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					ClaimMailOutbox(gomock.Any(), gomock.Any()).
+					Times(1).
+					DoAndReturn(func(_ any, arg data.ClaimMailOutboxParams) ([]data.MailOutbox, error) {
+						require.Equal(t, int32(1), arg.BatchSize)
+						return outboxRows(1), nil
+					})
+
+				store.EXPECT().
+					InsertMailEvent(gomock.Any(), gomock.Any()).
+					Times(1).
+					DoAndReturn(func(_ any, arg data.InsertMailEventParams) error {
+						require.Equal(t, mailEventSending, arg.Status)
+						return nil
+					})
+			},
+			dispatched: 1,
+		},
+		{
+			name:   "restOfBatchIsReleased",
+			queued: 1,
+			buildStubs: func(store *mockdb.MockStore) {
+				// rows claimed by the previous lease do not fit into the queue
+				store.EXPECT().
+					ClaimMailOutbox(gomock.Any(), gomock.Any()).
+					Times(1).
+					Return(outboxRows(1, 2, 3), nil)
+
+				store.EXPECT().
+					ReleaseMailOutbox(gomock.Any(), gomock.Any()).
+					Times(2).
+					DoAndReturn(func(_ any, id int64) error {
+						require.Contains(t, []int64{2, 3}, id)
+						return nil
+					})
+
+				store.EXPECT().
+					InsertMailEvent(gomock.Any(), gomock.Any()).
+					Times(4).
+					DoAndReturn(func(_ any, arg data.InsertMailEventParams) error {
+						if arg.Status == mailEventReleased {
+							require.Contains(t, arg.Detail.String, errMailQueueFull.Error())
+						}
+						return nil
+					})
+			},
+			dispatched: 1,
+		},
+	}
+
+	for _, pt := range pollTests {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := mockdb.NewMockStore(ctrl)
+		pt.buildStubs(store)
+		store.EXPECT().
+			GetSuppressedEmails(gomock.Any(), gomock.Any()).
+			AnyTimes().
+			Return([]string{}, nil)
+
+		app := Server{
+			Config: utils.Config{
+				MailEnqueueTimeout: 10 * time.Millisecond,
+			},
+			Store: store,
+			Mail: Mail{
+				MailerChan: make(chan Message, 2),
+			},
+		}
+		for range pt.queued {
+			app.Mail.MailerChan <- Message{}
+		}
+
+		app.pollOutbox()
+		require.Len(t, app.Mail.MailerChan, pt.queued+pt.dispatched, fmt.Sprintf("test name: %s", pt.name))
+	}
+}
+
+func TestPollOutboxLanes(t *testing.T) {
+	payloads, err := outboxPayload(Message{
+		To:       []string{"user@example.com"},
+		Template: mailTemplateDefault,
+	})
+	require.NoError(t, err)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	store := mockdb.NewMockStore(ctrl)
+	// the transactional lane is full, so only bulk messages are claimed
+	store.EXPECT().
+		ClaimMailOutbox(gomock.Any(), gomock.Any()).
+		Times(1).
+		DoAndReturn(func(_ any, arg data.ClaimMailOutboxParams) ([]data.MailOutbox, error) {
+			require.Equal(t, outboxPriority(mailPriorityBulk), arg.Priority)
+			require.Equal(t, int32(3), arg.BatchSize)
+			return []data.MailOutbox{{ID: 1, Payload: payloads[0]}}, nil
+		})
+	store.EXPECT().
+		GetSuppressedEmails(gomock.Any(), gomock.Any()).
+		AnyTimes().
+		Return([]string{}, nil)
+	store.EXPECT().
+		GetUnsubscribedEmails(gomock.Any(), gomock.Any()).
+		AnyTimes().
+		Return([]pgtype.Text{}, nil)
+	store.EXPECT().
+		ReleaseMailOutbox(gomock.Any(), gomock.Any()).
+		Times(0)
+	store.EXPECT().
+		InsertMailEvent(gomock.Any(), gomock.Any()).
+		Times(1).
+		DoAndReturn(func(_ any, arg data.InsertMailEventParams) error {
+			require.Equal(t, mailEventSending, arg.Status)
+			return nil
+		})
+
+	app := Server{
+		Config: utils.Config{
+			MailEnqueueTimeout: 10 * time.Millisecond,
+		},
+		Store: store,
+		URLs:  testApp.URLs,
+		Keys:  testApp.Keys,
+		Mail: Mail{
+			MailerChan: make(chan Message, 2),
+			BulkChan:   make(chan Message, 3),
+		},
+	}
+	app.Mail.MailerChan <- Message{}
+	app.Mail.MailerChan <- Message{}
+
+	app.pollOutbox()
+	require.Len(t, app.Mail.MailerChan, 2)
+	require.Len(t, app.Mail.BulkChan, 1)
+}
+
+func TestPollOutboxSkipped(t *testing.T) {
+	claimed := func(msg Message) []data.MailOutbox {
+		payloads, err := outboxPayload(msg)
+		require.NoError(t, err)
+		return []data.MailOutbox{{ID: 7, Payload: payloads[0]}}
+	}
+
+	skipTests := []struct {
+		name           string
+		buildStubs     func(store *mockdb.MockStore)
+		expectedDetail string
+	}{
+		{
+			name: "optedOut",
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					ClaimMailOutbox(gomock.Any(), gomock.Any()).
+					Times(1).
+					Return(claimed(Message{To: []string{"user@example.com"}, Template: mailTemplateDefault}), nil)
+				store.EXPECT().
+					GetSuppressedEmails(gomock.Any(), gomock.Any()).
+					Times(1).
+					Return([]string{}, nil)
+				store.EXPECT().
+					GetUnsubscribedEmails(gomock.Any(), gomock.Any()).
+					Times(1).
+					Return([]pgtype.Text{{String: "user@example.com", Valid: true}}, nil)
+			},
+			expectedDetail: "recipients opted out of product emails",
+		},
+		{
+			name: "suppressed",
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					ClaimMailOutbox(gomock.Any(), gomock.Any()).
+					Times(1).
+					Return(claimed(Message{To: []string{"user@example.com"}, Template: mailTemplateConfirmation}), nil)
+				store.EXPECT().
+					GetSuppressedEmails(gomock.Any(), gomock.Any()).
+					Times(1).
+					Return([]string{"user@example.com"}, nil)
+				store.EXPECT().
+					GetUnsubscribedEmails(gomock.Any(), gomock.Any()).
+					Times(0)
+			},
+			expectedDetail: "recipient addresses are suppressed",
+		},
+	}
+
+	for _, st := range skipTests {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := mockdb.NewMockStore(ctrl)
+		st.buildStubs(store)
+		// skipped messages are never reported as sent
+		store.EXPECT().
+			MarkMailOutboxSent(gomock.Any(), gomock.Any()).
+			Times(0)
+		store.EXPECT().
+			MarkMailOutboxSkipped(gomock.Any(), gomock.Eq(int64(7))).
+			Times(1).
+			Return(nil)
+		store.EXPECT().
+			InsertMailEvent(gomock.Any(), gomock.Any()).
+			Times(1).
+			DoAndReturn(func(_ any, arg data.InsertMailEventParams) error {
+				require.Equal(t, mailEventSkipped, arg.Status, fmt.Sprintf("test name: %s", st.name))
+				require.Equal(t, st.expectedDetail, arg.Detail.String, fmt.Sprintf("test name: %s", st.name))
+				return nil
+			})
+
+		app := Server{
+			Store: store,
+			Mail: Mail{
+				MailerChan: make(chan Message, 2),
+			},
+		}
+
+		app.pollOutbox()
+		require.Len(t, app.Mail.MailerChan, 0, fmt.Sprintf("test name: %s", st.name))
+	}
+}
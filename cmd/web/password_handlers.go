@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"time"
+
+	data "github.com/dubass83/go-concurrency-project/data/sqlc"
+	"github.com/dubass83/go-concurrency-project/utils"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/rs/zerolog/log"
+)
+
+const defaultPasswordResetTTL = 30 * time.Minute
+
+// passwordResetTTL return how long password reset links are valid
+func (app *Server) passwordResetTTL() time.Duration {
+	if app.Config.PasswordResetTTL <= 0 {
+		return defaultPasswordResetTTL
+	}
+	return app.Config.PasswordResetTTL
+}
+
+// passwordVersion return the version of the password which reset tokens are bound to,
+// the hash is salted so every change of the password revoke outstanding tokens
+func passwordVersion(u data.User) string {
+	sum := sha256.Sum256([]byte(u.Password.String))
+	return hex.EncodeToString(sum[:8])
+}
+
+// passwordResetMessage return the email with the single-use password reset link.
+// The link is built only from the public base url, never from the request
+func (app *Server) passwordResetMessage(u data.User) (Message, error) {
+	token, err := app.Tokens.IssueVersion(tokenPasswordReset, u.Email.String, passwordVersion(u), app.passwordResetTTL())
+	if err != nil {
+		return Message{}, err
+	}
+	link := app.URLs.Link(nil, "/password/reset?token="+token)
+
+	return Message{
+		To:       []string{u.Email.String},
+		Template: mailTemplatePasswordReset,
+		Locale:   u.Locale,
+		Data:     template.HTML(link),
+	}, nil
+}
+
+func (app *Server) ForgotPasswordPage(w http.ResponseWriter, r *http.Request) {
+	app.render(w, r, "password-forgot.page.gohtml", &TemplateData{
+		StringMap: map[string]string{
+			"email": r.URL.Query().Get("email"),
+		},
+	})
+}
+
+// PostForgotPassword send the password reset link. The reply is the same
+// for unknown accounts, so the form can not be used to look up users
+func (app *Server) PostForgotPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse the form from the request")
+		http.Redirect(w, r, "/password/forgot", http.StatusSeeOther)
+		return
+	}
+
+	email := r.Form.Get("email")
+	if email == "" {
+		app.Session.Put(r.Context(), "error", "Email is required.")
+		http.Redirect(w, r, "/password/forgot", http.StatusSeeOther)
+		return
+	}
+
+	u, err := app.Store.GetUserByEmail(r.Context(), pgtype.Text{
+		String: email,
+		Valid:  true,
+	})
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		log.Error().Err(err).Msg("failed to get user for the password reset link")
+		app.Session.Put(r.Context(), "error", "Unable to send the password reset link.")
+		http.Redirect(w, r, "/password/forgot", http.StatusSeeOther)
+		return
+	}
+	if err == nil {
+		msg, err := app.passwordResetMessage(u)
+		if err == nil {
+			err = app.enqueueMail(r.Context(), msg)
+		}
+		if err != nil {
+			log.Error().Err(err).Int32("user_id", u.ID).Msg("failed to queue password reset email")
+			app.Session.Put(r.Context(), "error", "Unable to send the password reset link.")
+			http.Redirect(w, r, "/password/forgot", http.StatusSeeOther)
+			return
+		}
+	}
+
+	app.Session.Put(r.Context(), "flash", "If the account exists, a password reset link is sent. Check your email.")
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// checkResetToken verify the reset link without using it and return the user of it,
+// the reader is redirected to request a new link when it is not valid. Links issued
+// before the password was changed are rejected
+func (app *Server) checkResetToken(w http.ResponseWriter, r *http.Request, token string) (data.User, bool) {
+	claims, err := app.Tokens.Check(tokenPasswordReset, token)
+	if errors.Is(err, errTokenExpired) {
+		app.Session.Put(r.Context(), "error", "The password reset link is expired. Request a new one.")
+		http.Redirect(w, r, "/password/forgot?email="+url.QueryEscape(claims.Subject), http.StatusSeeOther)
+		return data.User{}, false
+	}
+	if err != nil {
+		app.Session.Put(r.Context(), "error", "Invalid password reset link.")
+		http.Redirect(w, r, "/password/forgot", http.StatusSeeOther)
+		return data.User{}, false
+	}
+
+	u, err := app.Store.GetUserByEmail(r.Context(), pgtype.Text{
+		String: claims.Subject,
+		Valid:  true,
+	})
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			log.Error().Err(err).Msg("failed to get user for the password reset")
+		}
+		app.Session.Put(r.Context(), "error", "Invalid password reset link.")
+		http.Redirect(w, r, "/password/forgot", http.StatusSeeOther)
+		return data.User{}, false
+	}
+	if claims.Version != passwordVersion(u) {
+		app.Session.Put(r.Context(), "error", "The password reset link is no longer valid. Request a new one.")
+		http.Redirect(w, r, "/password/forgot", http.StatusSeeOther)
+		return data.User{}, false
+	}
+	return u, true
+}
+
+func (app *Server) ResetPasswordPage(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	u, ok := app.checkResetToken(w, r, token)
+	if !ok {
+		return
+	}
+
+	app.render(w, r, "password-reset.page.gohtml", &TemplateData{
+		StringMap: map[string]string{
+			"email": u.Email.String,
+			"token": token,
+		},
+	})
+}
+
+// PostResetPassword change the password with the reset link and log the user out
+// of every session, so whoever knew the old password loses the access. The new
+// password revoke the rest of the reset links sent to the user
+func (app *Server) PostResetPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse the form from the request")
+		http.Redirect(w, r, "/password/forgot", http.StatusSeeOther)
+		return
+	}
+
+	token := r.Form.Get("token")
+	u, ok := app.checkResetToken(w, r, token)
+	if !ok {
+		return
+	}
+
+	password := r.Form.Get("password")
+	if password == "" || password != r.Form.Get("verify-password") {
+		app.Session.Put(r.Context(), "error", "Passwords are empty or do not match.")
+		http.Redirect(w, r, "/password/reset?token="+token, http.StatusSeeOther)
+		return
+	}
+
+	// the link can be used only once
+	claims, err := app.Tokens.Consume(r.Context(), tokenPasswordReset, token)
+	if errors.Is(err, errTokenUsed) {
+		app.Session.Put(r.Context(), "error", "The password reset link is already used.")
+		http.Redirect(w, r, "/password/forgot", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Int32("user_id", u.ID).Msg("failed to consume password reset token")
+		app.Session.Put(r.Context(), "error", "Unable to reset the password, try again later.")
+		http.Redirect(w, r, "/password/forgot", http.StatusSeeOther)
+		return
+	}
+
+	err = utils.ResetPassword(password, u.ID, app.Store)
+	if err != nil {
+		log.Error().Err(err).Int32("user_id", u.ID).Msg("failed to reset the password")
+		// the password is not changed, so the link is returned to the user
+		if err := app.Tokens.Release(r.Context(), claims); err != nil {
+			log.Error().Err(err).Int32("user_id", u.ID).Msg("failed to release password reset token")
+		}
+		app.Session.Put(r.Context(), "error", "Unable to reset the password, try again later.")
+		http.Redirect(w, r, "/password/reset?token="+token, http.StatusSeeOther)
+		return
+	}
+
+	if err := app.destroyUserSessions(r.Context(), u.ID); err != nil {
+		log.Error().Err(err).Int32("user_id", u.ID).Msg("failed to log out sessions after the password reset")
+	}
+	// the session of this request is committed after the handler, so it is renewed as well
+	_ = app.Session.Destroy(r.Context())
+	_ = app.Session.RenewToken(r.Context())
+
+	log.Info().Int32("user_id", u.ID).Msg("password is reset")
+	app.Session.Put(r.Context(), "flash", "Password is changed. Log in with the new password.")
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// destroyUserSessions log the user out of every session. Sessions are not indexed
+// by the user, so all of them are scanned
+func (app *Server) destroyUserSessions(ctx context.Context, userID int32) error {
+	return app.Session.Iterate(ctx, func(ctx context.Context) error {
+		if id, ok := app.Session.Get(ctx, "userID").(int32); ok && id == userID {
+			return app.Session.Destroy(ctx)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	mockdb "github.com/dubass83/go-concurrency-project/data/mock"
+	data "github.com/dubass83/go-concurrency-project/data/sqlc"
+	"github.com/dubass83/go-concurrency-project/utils"
+	"github.com/golang/mock/gomock"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForgotPassword(t *testing.T) {
+	user := utils.RandomUser("Qw12345678!")
+
+	forgotTests := []struct {
+		name               string
+		email              string
+		expectedStatusCode int
+		expectedSessionKey string
+		buildStubs         func(store *mockdb.MockStore)
+	}{
+		{
+			name:               "sent",
+			email:              user.Email.String,
+			expectedStatusCode: http.StatusSeeOther,
+			expectedSessionKey: "flash",
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					GetUserByEmail(gomock.Any(), gomock.Eq(user.Email)).
+					Times(1).
+					Return(user, nil)
+				store.EXPECT().
+					EnqueueMailTx(gomock.Any(), gomock.Any()).
+					Times(1).
+					DoAndReturn(func(_ any, payloads [][]byte) (data.EnqueueMailTxResult, error) {
+						require.Len(t, payloads, 1)
+						msg, err := messageFromOutbox(data.MailOutbox{Payload: payloads[0]})
+						require.NoError(t, err)
+						require.Equal(t, mailTemplatePasswordReset, msg.Template)
+						require.Equal(t, []string{user.Email.String}, msg.To)
+						require.Contains(t, msg.Data, testApp.URLs.Link(nil, "/password/reset?token="), "the link does not follow the Host header")
+						return data.EnqueueMailTxResult{}, nil
+					})
+			},
+		},
+		{
+			name:               "throttled",
+			email:              user.Email.String,
+			expectedStatusCode: http.StatusSeeOther,
+			expectedSessionKey: "flash",
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					GetUserByEmail(gomock.Any(), gomock.Eq(user.Email)).
+					Times(1).
+					Return(user, nil)
+				store.EXPECT().
+					EnqueueMailTx(gomock.Any(), gomock.Any()).
+					Times(0)
+			},
+		},
+		{
+			name:               "unknown",
+			email:              "nobody@example.com",
+			expectedStatusCode: http.StatusSeeOther,
+			expectedSessionKey: "flash",
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					GetUserByEmail(gomock.Any(), gomock.Any()).
+					Times(1).
+					Return(data.User{}, pgx.ErrNoRows)
+				store.EXPECT().
+					EnqueueMailTx(gomock.Any(), gomock.Any()).
+					Times(0)
+			},
+		},
+		{
+			name:               "emptyEmail",
+			expectedStatusCode: http.StatusSeeOther,
+			expectedSessionKey: "error",
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					GetUserByEmail(gomock.Any(), gomock.Any()).
+					Times(0)
+			},
+		},
+	}
+
+	for _, ft := range forgotTests {
+		rr := httptest.NewRecorder()
+		postedData := url.Values{"email": {ft.email}}
+		req, _ := http.NewRequest("POST", "/password/forgot", strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Host = "evil.example"
+
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := mockdb.NewMockStore(ctrl)
+		ft.buildStubs(store)
+
+		testApp.Store = store
+
+		testApp.PostForgotPassword(rr, req)
+
+		require.Equal(t, ft.expectedStatusCode, rr.Code, fmt.Sprintf("test name: %s", ft.name))
+		if len(ft.expectedSessionKey) > 0 {
+			require.True(t, testApp.Session.Exists(ctx, ft.expectedSessionKey), fmt.Sprintf("test name: %s", ft.name))
+		}
+	}
+}
+
+func TestResetPasswordPage(t *testing.T) {
+	user := utils.RandomUser("Qw12345678!")
+	changed := user
+	changed.Password.String = "$2a$12$changed"
+
+	issue := func(purpose string, ttl time.Duration) string {
+		token, err := testApp.Tokens.IssueVersion(purpose, user.Email.String, passwordVersion(user), ttl)
+		require.NoError(t, err)
+		return "/password/reset?token=" + token
+	}
+	getUser := func(u data.User) func(store *mockdb.MockStore) {
+		return func(store *mockdb.MockStore) {
+			store.EXPECT().
+				GetUserByEmail(gomock.Any(), gomock.Eq(user.Email)).
+				Times(1).
+				Return(u, nil)
+		}
+	}
+	noUser := func(store *mockdb.MockStore) {
+		store.EXPECT().
+			GetUserByEmail(gomock.Any(), gomock.Any()).
+			Times(0)
+	}
+
+	pageTests := []struct {
+		name               string
+		url                string
+		buildStubs         func(store *mockdb.MockStore)
+		expectedStatusCode int
+		expectedHTML       string
+		expectedLocation   string
+	}{
+		{
+			name:               "valid",
+			url:                issue(tokenPasswordReset, time.Hour),
+			buildStubs:         getUser(user),
+			expectedStatusCode: http.StatusOK,
+			expectedHTML:       "Choose a new password for " + user.Email.String,
+		},
+		{
+			name:               "passwordChanged",
+			url:                issue(tokenPasswordReset, time.Hour),
+			buildStubs:         getUser(changed),
+			expectedStatusCode: http.StatusSeeOther,
+			expectedLocation:   "/password/forgot",
+		},
+		{
+			name:               "expired",
+			url:                issue(tokenPasswordReset, -time.Minute),
+			buildStubs:         noUser,
+			expectedStatusCode: http.StatusSeeOther,
+			expectedLocation:   "/password/forgot?email=" + url.QueryEscape(user.Email.String),
+		},
+		{
+			name:               "wrongPurpose",
+			url:                issue(tokenActivation, time.Hour),
+			buildStubs:         noUser,
+			expectedStatusCode: http.StatusSeeOther,
+			expectedLocation:   "/password/forgot",
+		},
+	}
+
+	for _, pt := range pageTests {
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", pt.url, nil)
+
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := mockdb.NewMockStore(ctrl)
+		pt.buildStubs(store)
+
+		testApp.Store = store
+
+		testApp.ResetPasswordPage(rr, req)
+
+		require.Equal(t, pt.expectedStatusCode, rr.Code, fmt.Sprintf("test name: %s", pt.name))
+		if len(pt.expectedHTML) > 0 {
+			require.Contains(t, rr.Body.String(), pt.expectedHTML, fmt.Sprintf("test name: %s", pt.name))
+		}
+		if len(pt.expectedLocation) > 0 {
+			require.Equal(t, pt.expectedLocation, rr.Header().Get("Location"), fmt.Sprintf("test name: %s", pt.name))
+		}
+	}
+}
+
+func TestPostResetPassword(t *testing.T) {
+	user := utils.RandomUser("Qw12345678!")
+
+	issue := func(purpose string) string {
+		token, err := testApp.Tokens.IssueVersion(purpose, user.Email.String, passwordVersion(user), time.Hour)
+		require.NoError(t, err)
+		return token
+	}
+	valid := issue(tokenPasswordReset)
+	// a second link sent before the password was reset
+	outstanding := issue(tokenPasswordReset)
+	changed := user
+	changed.Password.String = "$2a$12$changed"
+
+	resetTests := []struct {
+		name               string
+		token              string
+		password           string
+		verifyPassword     string
+		expectedStatusCode int
+		expectedLocation   string
+		buildStubs         func(store *mockdb.MockStore)
+	}{
+		{
+			name:               "mismatch",
+			token:              valid,
+			password:           "new-password",
+			verifyPassword:     "other-password",
+			expectedStatusCode: http.StatusSeeOther,
+			expectedLocation:   "/password/reset?token=" + valid,
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					GetUserByEmail(gomock.Any(), gomock.Eq(user.Email)).
+					Times(1).
+					Return(user, nil)
+				store.EXPECT().
+					UpdateUser(gomock.Any(), gomock.Any()).
+					Times(0)
+			},
+		},
+		{
+			name:               "updateFails",
+			token:              valid,
+			password:           "new-password",
+			verifyPassword:     "new-password",
+			expectedStatusCode: http.StatusSeeOther,
+			expectedLocation:   "/password/reset?token=" + valid,
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					GetUserByEmail(gomock.Any(), gomock.Eq(user.Email)).
+					Times(1).
+					Return(user, nil)
+				store.EXPECT().
+					UpdateUser(gomock.Any(), gomock.Any()).
+					Times(1).
+					Return(data.User{}, errors.New("connection refused"))
+			},
+		},
+		{
+			// the link of the failed update is still valid
+			name:               "reset",
+			token:              valid,
+			password:           "new-password",
+			verifyPassword:     "new-password",
+			expectedStatusCode: http.StatusSeeOther,
+			expectedLocation:   "/login",
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					GetUserByEmail(gomock.Any(), gomock.Eq(user.Email)).
+					Times(1).
+					Return(user, nil)
+				store.EXPECT().
+					UpdateUser(gomock.Any(), gomock.Any()).
+					Times(1).
+					DoAndReturn(func(_ any, arg data.UpdateUserParams) (data.User, error) {
+						require.Equal(t, user.ID, arg.ID)
+						require.NoError(t, utils.CheckPassword("new-password", arg.Password.String))
+						return user, nil
+					})
+			},
+		},
+		{
+			name:               "usedTwice",
+			token:              valid,
+			password:           "new-password",
+			verifyPassword:     "new-password",
+			expectedStatusCode: http.StatusSeeOther,
+			expectedLocation:   "/password/forgot",
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					GetUserByEmail(gomock.Any(), gomock.Eq(user.Email)).
+					Times(1).
+					Return(user, nil)
+				store.EXPECT().
+					UpdateUser(gomock.Any(), gomock.Any()).
+					Times(0)
+			},
+		},
+		{
+			name:               "passwordChanged",
+			token:              outstanding,
+			password:           "other-password",
+			verifyPassword:     "other-password",
+			expectedStatusCode: http.StatusSeeOther,
+			expectedLocation:   "/password/forgot",
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					GetUserByEmail(gomock.Any(), gomock.Eq(user.Email)).
+					Times(1).
+					Return(changed, nil)
+				store.EXPECT().
+					UpdateUser(gomock.Any(), gomock.Any()).
+					Times(0)
+			},
+		},
+		{
+			name:               "wrongPurpose",
+			token:              issue(tokenActivation),
+			password:           "new-password",
+			verifyPassword:     "new-password",
+			expectedStatusCode: http.StatusSeeOther,
+			expectedLocation:   "/password/forgot",
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					GetUserByEmail(gomock.Any(), gomock.Any()).
+					Times(0)
+			},
+		},
+	}
+
+	for _, rt := range resetTests {
+		rr := httptest.NewRecorder()
+		postedData := url.Values{
+			"token":           {rt.token},
+			"password":        {rt.password},
+			"verify-password": {rt.verifyPassword},
+		}
+		req, _ := http.NewRequest("POST", "/password/reset", strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := mockdb.NewMockStore(ctrl)
+		rt.buildStubs(store)
+
+		testApp.Store = store
+
+		testApp.PostResetPassword(rr, req)
+
+		require.Equal(t, rt.expectedStatusCode, rr.Code, fmt.Sprintf("test name: %s", rt.name))
+		require.Equal(t, rt.expectedLocation, rr.Header().Get("Location"), fmt.Sprintf("test name: %s", rt.name))
+	}
+}
+
+func TestDestroyUserSessions(t *testing.T) {
+	// login creates a committed session of the user
+	login := func(userID int32) string {
+		ctx, err := testApp.Session.Load(context.Background(), "")
+		require.NoError(t, err)
+		testApp.Session.Put(ctx, "userID", userID)
+		token, _, err := testApp.Session.Commit(ctx)
+		require.NoError(t, err)
+		return token
+	}
+	loggedIn := func(token string) bool {
+		ctx, err := testApp.Session.Load(context.Background(), token)
+		require.NoError(t, err)
+		return testApp.Session.Exists(ctx, "userID")
+	}
+
+	laptop := login(1001)
+	phone := login(1001)
+	other := login(1002)
+
+	require.NoError(t, testApp.destroyUserSessions(context.Background(), 1001))
+	require.False(t, loggedIn(laptop))
+	require.False(t, loggedIn(phone))
+	require.True(t, loggedIn(other), "sessions of other users are kept")
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	data "github.com/dubass83/go-concurrency-project/data/sqlc"
+	"github.com/rs/zerolog/log"
+)
+
+// categories of emails, users can opt out of every category which is not mandatory
+const (
+	mailCategorySecurity = "security"
+	mailCategoryBilling  = "billing"
+	mailCategoryProduct  = "product"
+)
+
+type mailCategory struct {
+	Name        string
+	Title       string
+	Description string
+	Mandatory   bool
+}
+
+// mailCategories are shown on the preferences page in this order
+var mailCategories = []mailCategory{
+	{
+		Name:        mailCategorySecurity,
+		Title:       "Security",
+		Description: "Account activation and log in alerts, they are always sent.",
+		Mandatory:   true,
+	},
+	{
+		Name:        mailCategoryBilling,
+		Title:       "Billing",
+		Description: "Invoices and manuals of your plans.",
+	},
+	{
+		Name:        mailCategoryProduct,
+		Title:       "Product updates",
+		Description: "News about the service.",
+	},
+}
+
+// mailTemplateCategories is the category of messages which do not set it,
+// templates missing here are product updates
+var mailTemplateCategories = map[string]string{
+	mailTemplateConfirmation:  mailCategorySecurity,
+	mailTemplateFailedLogin:   mailCategorySecurity,
+	mailTemplatePasswordReset: mailCategorySecurity,
+	mailTemplateInvoice:       mailCategoryBilling,
+	mailTemplateManual:        mailCategoryBilling,
+}
+
+func findMailCategory(name string) (mailCategory, bool) {
+	for _, c := range mailCategories {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return mailCategory{}, false
+}
+
+// messageCategory return the category of the message
+func messageCategory(msg Message) string {
+	if msg.Category != "" {
+		return msg.Category
+	}
+	if category, ok := mailTemplateCategories[mailTemplateBase(msg.Template)]; ok {
+		return category
+	}
+	return mailCategoryProduct
+}
+
+// mandatoryCategory report if users can not opt out of the category,
+// unknown categories are never skipped
+func mandatoryCategory(name string) bool {
+	c, ok := findMailCategory(name)
+	return !ok || c.Mandatory
+}
+
+// filterUnsubscribed remove recipients which opted out of the category of the message,
+// it report false when nobody is left
+func (app *Server) filterUnsubscribed(ctx context.Context, msg Message) (Message, bool, error) {
+	category := messageCategory(msg)
+	if mandatoryCategory(category) || len(msg.To) == 0 {
+		return msg, true, nil
+	}
+
+	emails := make([]string, 0, len(msg.To))
+	for _, recipient := range msg.To {
+		emails = append(emails, strings.ToLower(strings.TrimSpace(recipient)))
+	}
+	rows, err := app.Store.GetUnsubscribedEmails(ctx, data.GetUnsubscribedEmailsParams{
+		Category: category,
+		Emails:   emails,
+	})
+	if err != nil {
+		return msg, false, fmt.Errorf("failed to check notification preferences: %s", err)
+	}
+
+	unsubscribed := make(map[string]bool, len(rows))
+	for _, email := range rows {
+		unsubscribed[strings.ToLower(email.String)] = true
+	}
+
+	allowed := make([]string, 0, len(msg.To))
+	for i, recipient := range msg.To {
+		if unsubscribed[emails[i]] {
+			mailMetrics.Add("unsubscribed", 1)
+			log.Info().
+				Str("recipient", recipient).
+				Str("category", category).
+				Msg("email is skipped, recipient opted out of the category")
+			continue
+		}
+		allowed = append(allowed, recipient)
+	}
+
+	msg.To = allowed
+	return msg, len(allowed) > 0, nil
+}
+
+// unsubscribeURL return the signed link which opt the recipient out of the category
+func (app *Server) unsubscribeURL(email, category string) string {
+	// only the path is signed, so the link is verified whatever host it is opened on
+	signed := app.GenerateTokenFromString(fmt.Sprintf("/unsubscribe?email=%s&category=%s",
+		url.QueryEscape(email),
+		url.QueryEscape(category),
+	))
+	return app.URLs.Link(nil, signed)
+}
+
+// withUnsubscribe add the unsubscribe link to messages which the recipient can opt out of
+func (app *Server) withUnsubscribe(msg Message) Message {
+	category := messageCategory(msg)
+	// the link is personal, it can not be shared by several recipients
+	if mandatoryCategory(category) || len(msg.To) != 1 {
+		return msg
+	}
+	msg.UnsubscribeURL = app.unsubscribeURL(msg.To[0], category)
+	return msg
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	data "github.com/dubass83/go-concurrency-project/data/sqlc"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/rs/zerolog/log"
+)
+
+type formatedPreference struct {
+	Name        string
+	Title       string
+	Description string
+	Mandatory   bool
+	Subscribed  bool
+}
+
+// verifyUnsubscribe check the signature of the unsubscribe link
+// and return the email and the category from it
+func (app *Server) verifyUnsubscribe(r *http.Request) (string, mailCategory, bool) {
+	if !app.verifyRequest(r) {
+		return "", mailCategory{}, false
+	}
+
+	category, ok := findMailCategory(r.URL.Query().Get("category"))
+	if !ok || category.Mandatory {
+		return "", mailCategory{}, false
+	}
+	return r.URL.Query().Get("email"), category, true
+}
+
+// Unsubscribe ask to confirm the link opened from an email
+func (app *Server) Unsubscribe(w http.ResponseWriter, r *http.Request) {
+	email, category, ok := app.verifyUnsubscribe(r)
+	if !ok {
+		app.Session.Put(r.Context(), "error", "Invalid unsubscribe link.")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	app.render(w, r, "unsubscribe.page.gohtml", &TemplateData{
+		StringMap: map[string]string{
+			"email":    email,
+			"category": category.Title,
+			"action":   r.URL.RequestURI(),
+		},
+	})
+}
+
+// PostUnsubscribe opt the recipient out of the category, it accept the confirmation form
+// and the one-click request sent by mail clients (RFC 8058)
+func (app *Server) PostUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		log.Error().Err(err).Msg("failed to parse the form from the request")
+	}
+	oneClick := r.PostForm.Get("List-Unsubscribe") == "One-Click"
+
+	email, category, ok := app.verifyUnsubscribe(r)
+	if !ok {
+		if oneClick {
+			http.Error(w, "invalid unsubscribe link", http.StatusBadRequest)
+			return
+		}
+		app.Session.Put(r.Context(), "error", "Invalid unsubscribe link.")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	err := app.unsubscribe(r.Context(), email, category.Name)
+	if oneClick {
+		if err != nil {
+			http.Error(w, "unable to unsubscribe", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if err != nil {
+		app.Session.Put(r.Context(), "error", "Unable to unsubscribe.")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	app.Session.Put(r.Context(), "flash", fmt.Sprintf("You are unsubscribed from %s emails.", category.Title))
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (app *Server) unsubscribe(ctx context.Context, email, category string) error {
+	u, err := app.Store.GetUserByEmail(ctx, pgtype.Text{
+		String: email,
+		Valid:  true,
+	})
+	if err != nil {
+		log.Error().Err(err).Str("email", email).Msg("failed to find the user to unsubscribe")
+		return err
+	}
+
+	_, err = app.Store.UpsertNotificationPreference(ctx, data.UpsertNotificationPreferenceParams{
+		UserID:     u.ID,
+		Category:   category,
+		Subscribed: false,
+	})
+	if err != nil {
+		log.Error().Err(err).Int32("user_id", u.ID).Str("category", category).Msg("failed to unsubscribe the user")
+		return err
+	}
+	return nil
+}
+
+// Preferences show email categories the user is subscribed to and the language of emails
+func (app *Server) Preferences(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.Session.Get(r.Context(), "user").(data.User)
+	if !ok {
+		app.Session.Put(r.Context(), "error", "Log in first!")
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	prefs, err := app.Store.GetNotificationPreferences(r.Context(), user.ID)
+	if err != nil {
+		log.Error().Err(err).Int32("user_id", user.ID).Msg("failed to get notification preferences")
+		app.Session.Put(r.Context(), "error", "Unable to get your email preferences.")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	app.render(w, r, "preferences.page.gohtml", &TemplateData{
+		StringMap: map[string]string{
+			"locale": user.Locale,
+		},
+		DataMap: map[string]any{
+			"preferences": preferencesFormatted(prefs),
+			"locales":     supportedLocales,
+		},
+	})
+}
+
+// PostPreferences save the categories checked on the preferences page
+func (app *Server) PostPreferences(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.Session.Get(r.Context(), "user").(data.User)
+	if !ok {
+		app.Session.Put(r.Context(), "error", "Log in first!")
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		log.Error().Err(err).Msg("failed to parse the form from the request")
+	}
+
+	for _, c := range mailCategories {
+		if c.Mandatory {
+			continue
+		}
+		_, err := app.Store.UpsertNotificationPreference(r.Context(), data.UpsertNotificationPreferenceParams{
+			UserID:     user.ID,
+			Category:   c.Name,
+			Subscribed: r.PostForm.Has(c.Name),
+		})
+		if err != nil {
+			log.Error().Err(err).Int32("user_id", user.ID).Str("category", c.Name).Msg("failed to save notification preference")
+			app.Session.Put(r.Context(), "error", "Unable to save your email preferences.")
+			http.Redirect(w, r, "/members/preferences", http.StatusSeeOther)
+			return
+		}
+	}
+
+	if locale, ok := supportedLocale(r.PostForm.Get("locale")); ok && locale != user.Locale {
+		updated, err := app.Store.UpdateUser(r.Context(), data.UpdateUserParams{
+			ID: user.ID,
+			Locale: pgtype.Text{
+				String: locale,
+				Valid:  true,
+			},
+		})
+		if err != nil {
+			log.Error().Err(err).Int32("user_id", user.ID).Msg("failed to save the locale of the user")
+			app.Session.Put(r.Context(), "error", "Unable to save your email preferences.")
+			http.Redirect(w, r, "/members/preferences", http.StatusSeeOther)
+			return
+		}
+		app.Session.Put(r.Context(), "user", updated)
+	}
+
+	app.Session.Put(r.Context(), "flash", "Email preferences are saved.")
+	http.Redirect(w, r, "/members/preferences", http.StatusSeeOther)
+}
+
+// preferencesFormatted return all categories, users are subscribed to categories without a row
+func preferencesFormatted(prefs []data.NotificationPreference) []formatedPreference {
+	subscribed := make(map[string]bool, len(prefs))
+	for _, p := range prefs {
+		subscribed[p.Category] = p.Subscribed
+	}
+
+	result := make([]formatedPreference, 0, len(mailCategories))
+	for _, c := range mailCategories {
+		s, ok := subscribed[c.Name]
+		result = append(result, formatedPreference{
+			Name:        c.Name,
+			Title:       c.Title,
+			Description: c.Description,
+			Mandatory:   c.Mandatory,
+			Subscribed:  c.Mandatory || !ok || s,
+		})
+	}
+	return result
+}
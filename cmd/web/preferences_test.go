@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	mockdb "github.com/dubass83/go-concurrency-project/data/mock"
+	data "github.com/dubass83/go-concurrency-project/data/sqlc"
+	"github.com/dubass83/go-concurrency-project/utils"
+	"github.com/golang/mock/gomock"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageCategory(t *testing.T) {
+	require.Equal(t, mailCategorySecurity, messageCategory(Message{Template: mailTemplateFailedLogin}))
+	require.Equal(t, mailCategoryBilling, messageCategory(Message{Template: mailTemplateInvoice}))
+	require.Equal(t, mailCategoryProduct, messageCategory(Message{}))
+	require.Equal(t, mailCategoryBilling, messageCategory(Message{Category: mailCategoryBilling}))
+
+	require.True(t, mandatoryCategory(mailCategorySecurity))
+	require.True(t, mandatoryCategory("unknown"), "unknown categories can not be skipped")
+	require.False(t, mandatoryCategory(mailCategoryProduct))
+}
+
+func TestFilterUnsubscribed(t *testing.T) {
+
+	filterTests := []struct {
+		name       string
+		msg        Message
+		expectedTo []string
+		expectedOk bool
+		buildStubs func(store *mockdb.MockStore)
+	}{
+		{
+			name:       "mandatory",
+			msg:        Message{To: []string{"user@example.com"}, Template: mailTemplateFailedLogin},
+			expectedTo: []string{"user@example.com"},
+			expectedOk: true,
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					GetUnsubscribedEmails(gomock.Any(), gomock.Any()).
+					Times(0)
+			},
+		},
+		{
+			name:       "optedOut",
+			msg:        Message{To: []string{"User@Example.com", "admin@example.com"}, Template: mailTemplateInvoice},
+			expectedTo: []string{"admin@example.com"},
+			expectedOk: true,
+			buildStubs: func(store *mockdb.MockStore) {
+				arg := data.GetUnsubscribedEmailsParams{
+					Category: mailCategoryBilling,
+					Emails:   []string{"user@example.com", "admin@example.com"},
+				}
+				store.EXPECT().
+					GetUnsubscribedEmails(gomock.Any(), gomock.Eq(arg)).
+					Times(1).
+					Return([]pgtype.Text{{String: "user@example.com", Valid: true}}, nil)
+			},
+		},
+		{
+			name:       "nobodyLeft",
+			msg:        Message{To: []string{"user@example.com"}},
+			expectedTo: []string{},
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					GetUnsubscribedEmails(gomock.Any(), gomock.Any()).
+					Times(1).
+					Return([]pgtype.Text{{String: "user@example.com", Valid: true}}, nil)
+			},
+		},
+	}
+
+	for _, ft := range filterTests {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := mockdb.NewMockStore(ctrl)
+		ft.buildStubs(store)
+
+		testApp.Store = store
+
+		msg, ok, err := testApp.filterUnsubscribed(context.Background(), ft.msg)
+		require.NoError(t, err, fmt.Sprintf("test name: %s", ft.name))
+		require.Equal(t, ft.expectedOk, ok, fmt.Sprintf("test name: %s", ft.name))
+		require.Equal(t, ft.expectedTo, msg.To, fmt.Sprintf("test name: %s", ft.name))
+	}
+}
+
+func TestListUnsubscribeHeaders(t *testing.T) {
+	sender := NewMemorySender(newMsgBuilder(testApp.Config, testApp.Mail.Templates), 1)
+
+	msg := testApp.withUnsubscribe(Message{
+		To:       []string{"user@example.com"},
+		Template: mailTemplateInvoice,
+		Data:     "$10.00",
+	})
+	require.NotEmpty(t, msg.UnsubscribeURL)
+	require.NoError(t, sender.SendEmail(msg))
+
+	captured := sender.Messages()[0]
+	headers, _ := splitMessage(captured.Raw)
+	value, ok := findHeader(headers, "List-Unsubscribe")
+	require.True(t, ok)
+	require.Equal(t, fmt.Sprintf("<%s>", msg.UnsubscribeURL), strings.TrimSpace(strings.ReplaceAll(value, "\r\n", "")))
+	value, ok = findHeader(headers, "List-Unsubscribe-Post")
+	require.True(t, ok)
+	require.Equal(t, " List-Unsubscribe=One-Click", value)
+	require.Contains(t, captured.Plain, msg.UnsubscribeURL, "the link is shown in the body as well")
+
+	msg = testApp.withUnsubscribe(Message{
+		To:       []string{"user@example.com"},
+		Template: mailTemplateConfirmation,
+	})
+	require.Empty(t, msg.UnsubscribeURL, "security emails can not be unsubscribed")
+
+	msg = testApp.withUnsubscribe(Message{
+		To: []string{"user@example.com", "admin@example.com"},
+	})
+	require.Empty(t, msg.UnsubscribeURL, "the link is personal")
+}
+
+func TestUnsubscribeHandler(t *testing.T) {
+
+	user := utils.RandomUser("Qw12345678!")
+
+	link, err := url.Parse(testApp.unsubscribeURL(user.Email.String, mailCategoryProduct))
+	require.NoError(t, err)
+	signed := link.RequestURI()
+
+	securityLink, err := url.Parse(testApp.unsubscribeURL(user.Email.String, mailCategorySecurity))
+	require.NoError(t, err)
+
+	// links sent before only the path was signed carry the signature of the public base url
+	legacyLink, err := url.Parse(testApp.GenerateTokenFromString(testApp.URLs.Link(nil,
+		"/unsubscribe?email="+url.QueryEscape(user.Email.String)+"&category="+mailCategoryProduct)))
+	require.NoError(t, err)
+
+	unsubscribeTests := []struct {
+		name               string
+		method             string
+		url                string
+		postedData         url.Values
+		expectedStatusCode int
+		expectedHTML       string
+		expectedSessionKey string
+		buildStubs         func(store *mockdb.MockStore)
+	}{
+		{
+			name:               "confirmPage",
+			method:             "GET",
+			url:                signed,
+			expectedStatusCode: http.StatusOK,
+			expectedHTML:       "Product updates",
+			buildStubs:         func(store *mockdb.MockStore) {},
+		},
+		{
+			name:               "otherHost",
+			method:             "GET",
+			url:                "http://app.internal:9090" + signed,
+			expectedStatusCode: http.StatusOK,
+			expectedHTML:       "Product updates",
+			buildStubs:         func(store *mockdb.MockStore) {},
+		},
+		{
+			name:               "legacyLink",
+			method:             "GET",
+			url:                legacyLink.RequestURI(),
+			expectedStatusCode: http.StatusOK,
+			expectedHTML:       "Product updates",
+			buildStubs:         func(store *mockdb.MockStore) {},
+		},
+		{
+			name:               "tamperedLink",
+			method:             "GET",
+			url:                strings.Replace(signed, "product", "billing", 1),
+			expectedStatusCode: http.StatusSeeOther,
+			expectedSessionKey: "error",
+			buildStubs:         func(store *mockdb.MockStore) {},
+		},
+		{
+			name:               "mandatoryCategory",
+			method:             "GET",
+			url:                securityLink.RequestURI(),
+			expectedStatusCode: http.StatusSeeOther,
+			expectedSessionKey: "error",
+			buildStubs:         func(store *mockdb.MockStore) {},
+		},
+		{
+			name:               "confirmForm",
+			method:             "POST",
+			url:                signed,
+			expectedStatusCode: http.StatusSeeOther,
+			expectedSessionKey: "flash",
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					GetUserByEmail(gomock.Any(), gomock.Eq(user.Email)).
+					Times(1).
+					Return(user, nil)
+
+				arg := data.UpsertNotificationPreferenceParams{
+					UserID:     user.ID,
+					Category:   mailCategoryProduct,
+					Subscribed: false,
+				}
+				store.EXPECT().
+					UpsertNotificationPreference(gomock.Any(), gomock.Eq(arg)).
+					Times(1).
+					Return(data.NotificationPreference{}, nil)
+			},
+		},
+		{
+			name:   "oneClick",
+			method: "POST",
+			url:    signed,
+			postedData: url.Values{
+				"List-Unsubscribe": {"One-Click"},
+			},
+			expectedStatusCode: http.StatusOK,
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					GetUserByEmail(gomock.Any(), gomock.Eq(user.Email)).
+					Times(1).
+					Return(user, nil)
+
+				store.EXPECT().
+					UpsertNotificationPreference(gomock.Any(), gomock.Any()).
+					Times(1).
+					Return(data.NotificationPreference{}, nil)
+			},
+		},
+		{
+			name:   "oneClickInvalidLink",
+			method: "POST",
+			url:    "/unsubscribe?email=user%40example.com&category=product",
+			postedData: url.Values{
+				"List-Unsubscribe": {"One-Click"},
+			},
+			expectedStatusCode: http.StatusBadRequest,
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					UpsertNotificationPreference(gomock.Any(), gomock.Any()).
+					Times(0)
+			},
+		},
+	}
+
+	for _, ut := range unsubscribeTests {
+
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest(ut.method, ut.url, strings.NewReader(ut.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := mockdb.NewMockStore(ctrl)
+		ut.buildStubs(store)
+
+		testApp.Store = store
+
+		handler := http.HandlerFunc(testApp.Unsubscribe)
+		if ut.method == "POST" {
+			handler = testApp.PostUnsubscribe
+		}
+		handler.ServeHTTP(rr, req)
+
+		require.Equal(t, ut.expectedStatusCode, rr.Code, fmt.Sprintf("test name: %s", ut.name))
+
+		if len(ut.expectedHTML) > 0 {
+			require.Contains(t, rr.Body.String(), ut.expectedHTML, fmt.Sprintf("test name: %s", ut.name))
+		}
+		if len(ut.expectedSessionKey) > 0 {
+			require.True(t, testApp.Session.Exists(ctx, ut.expectedSessionKey), fmt.Sprintf("test name: %s", ut.name))
+		}
+	}
+}
+
+func TestPreferencesHandler(t *testing.T) {
+	user := utils.RandomUser("Qw12345678!")
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	store := mockdb.NewMockStore(ctrl)
+	store.EXPECT().
+		GetNotificationPreferences(gomock.Any(), gomock.Eq(user.ID)).
+		Times(1).
+		Return([]data.NotificationPreference{
+			{UserID: user.ID, Category: mailCategoryProduct, Subscribed: false},
+		}, nil)
+	store.EXPECT().
+		UpsertNotificationPreference(gomock.Any(), gomock.Eq(data.UpsertNotificationPreferenceParams{
+			UserID:     user.ID,
+			Category:   mailCategoryBilling,
+			Subscribed: true,
+		})).
+		Times(1).
+		Return(data.NotificationPreference{}, nil)
+	store.EXPECT().
+		UpsertNotificationPreference(gomock.Any(), gomock.Eq(data.UpsertNotificationPreferenceParams{
+			UserID:     user.ID,
+			Category:   mailCategoryProduct,
+			Subscribed: false,
+		})).
+		Times(1).
+		Return(data.NotificationPreference{}, nil)
+
+	store.EXPECT().
+		UpdateUser(gomock.Any(), gomock.Eq(data.UpdateUserParams{
+			ID:     user.ID,
+			Locale: pgtype.Text{String: "uk", Valid: true},
+		})).
+		Times(1).
+		Return(user, nil)
+
+	testApp.Store = store
+
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/members/preferences", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	testApp.Session.Put(ctx, "userID", user.ID)
+	testApp.Session.Put(ctx, "user", user)
+
+	testApp.Preferences(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Contains(t, rr.Body.String(), "Product updates")
+
+	prefs := preferencesFormatted([]data.NotificationPreference{
+		{UserID: user.ID, Category: mailCategoryProduct, Subscribed: false},
+	})
+	require.Len(t, prefs, len(mailCategories))
+	require.True(t, prefs[0].Subscribed, "security emails are always sent")
+	require.True(t, prefs[1].Subscribed, "users are subscribed by default")
+	require.False(t, prefs[2].Subscribed)
+
+	rr = httptest.NewRecorder()
+	postedData := url.Values{
+		mailCategoryBilling: {"on"},
+		"locale":            {"uk"},
+	}
+	req, _ = http.NewRequest("POST", "/members/preferences", strings.NewReader(postedData.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	testApp.Session.Put(ctx, "user", user)
+
+	testApp.PostPreferences(rr, req)
+	require.Equal(t, http.StatusSeeOther, rr.Code)
+	require.True(t, testApp.Session.Exists(ctx, "flash"))
+}
@@ -0,0 +1,41 @@
+package main
+
+// priority lanes of the mail queue, every lane has its own mail workers
+const (
+	mailPriorityTransactional = "transactional"
+	mailPriorityBulk          = "bulk"
+)
+
+// mailPriorities are listed in the order of dispatching
+var mailPriorities = []string{mailPriorityTransactional, mailPriorityBulk}
+
+// messagePriority return the lane of the message, account-critical mail is transactional
+// unless it carries attachments, everything else is bulk
+func messagePriority(msg Message) string {
+	if msg.Priority != "" {
+		return msg.Priority
+	}
+	if len(msg.AttachFiles)+len(msg.AttachmentMap)+len(msg.Attachments) > 0 {
+		return mailPriorityBulk
+	}
+	if messageCategory(msg) == mailCategorySecurity {
+		return mailPriorityTransactional
+	}
+	return mailPriorityBulk
+}
+
+// outboxPriority return the value of the priority column of the mail outbox for the lane
+func outboxPriority(priority string) int16 {
+	if priority == mailPriorityTransactional {
+		return 0
+	}
+	return 1
+}
+
+// laneChan return the channel of the priority lane
+func (app *Server) laneChan(priority string) chan Message {
+	if priority == mailPriorityTransactional {
+		return app.Mail.MailerChan
+	}
+	return app.Mail.BulkChan
+}
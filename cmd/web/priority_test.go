@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessagePriority(t *testing.T) {
+	priorityTests := []struct {
+		name     string
+		msg      Message
+		expected string
+	}{
+		{
+			name:     "activation",
+			msg:      Message{Template: mailTemplateConfirmation},
+			expected: mailPriorityTransactional,
+		},
+		{
+			name:     "localizedFailedLogin",
+			msg:      Message{Template: mailTemplateFailedLogin, Locale: "uk"},
+			expected: mailPriorityTransactional,
+		},
+		{
+			name: "invoice",
+			msg: Message{
+				Template:      mailTemplateInvoice,
+				AttachmentMap: map[string]string{"Invoice.pdf": "./tmp/invoice.pdf"},
+			},
+			expected: mailPriorityBulk,
+		},
+		{
+			name: "securityWithAttachment",
+			msg: Message{
+				Category:    mailCategorySecurity,
+				AttachFiles: []string{"./tmp/report.pdf"},
+			},
+			expected: mailPriorityBulk,
+		},
+		{
+			name:     "productUpdate",
+			msg:      Message{Template: "mail"},
+			expected: mailPriorityBulk,
+		},
+		{
+			name:     "explicitPriority",
+			msg:      Message{Template: "mail", Priority: mailPriorityTransactional},
+			expected: mailPriorityTransactional,
+		},
+	}
+
+	for _, pt := range priorityTests {
+		require.Equal(t, pt.expected, messagePriority(pt.msg), fmt.Sprintf("test name: %s", pt.name))
+	}
+}
+
+func TestOutboxPayloadPriority(t *testing.T) {
+	payloads, err := outboxPayload(
+		Message{Template: mailTemplateConfirmation},
+		Message{Template: mailTemplateManual},
+	)
+	require.NoError(t, err)
+	require.Len(t, payloads, 2)
+	// the outbox claim messages by the stored priority
+	require.Contains(t, string(payloads[0]), `"Priority":"transactional"`)
+	require.Contains(t, string(payloads[1]), `"Priority":"bulk"`)
+}
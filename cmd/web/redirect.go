@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	netmail "net/mail"
+	"strings"
+
+	"github.com/dubass83/go-concurrency-project/utils"
+)
+
+const headerOriginalTo = "X-Original-To"
+
+// productionEnviroment never redirect emails, the rest of ENVIROMENT values
+// like devel and tests must not reach real customers
+const productionEnviroment = "production"
+
+func isProduction(enviroment string) bool {
+	return strings.EqualFold(enviroment, productionEnviroment)
+}
+
+// MailRedirect keep emails of non-production environments away from real customers,
+// recipients outside of the allowed domains are replaced with the catch-all address
+type MailRedirect struct {
+	CatchAll       string
+	AllowedDomains []string
+}
+
+// newMailRedirect return nil in production or when the redirection is not configured
+func newMailRedirect(conf utils.Config) *MailRedirect {
+	if isProduction(conf.Enviroment) {
+		return nil
+	}
+
+	rd := &MailRedirect{
+		CatchAll: strings.TrimSpace(conf.MailRedirectTo),
+	}
+	for _, domain := range strings.Split(conf.MailRedirectAllowedDomains, ",") {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain != "" {
+			rd.AllowedDomains = append(rd.AllowedDomains, domain)
+		}
+	}
+	if rd.CatchAll == "" && len(rd.AllowedDomains) == 0 {
+		return nil
+	}
+	return rd
+}
+
+// allowed report if the email can be delivered to the address as is
+func (rd *MailRedirect) allowed(address string) bool {
+	if strings.EqualFold(address, rd.CatchAll) {
+		return true
+	}
+	if a, err := netmail.ParseAddress(address); err == nil {
+		address = a.Address
+	}
+	i := strings.LastIndex(address, "@")
+	if i < 0 {
+		return false
+	}
+	domain := strings.ToLower(address[i+1:])
+	for _, d := range rd.AllowedDomains {
+		if domain == d {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply rewrite To, CC and BCC of the message, the replaced recipients are kept
+// in OriginalTo. Recipients are dropped when the catch-all address is not set.
+func (rd *MailRedirect) Apply(email Message) (Message, error) {
+	seen := make(map[string]bool)
+	rewrite := func(addresses []string) []string {
+		var result []string
+		for _, address := range addresses {
+			if !rd.allowed(address) {
+				email.OriginalTo = append(email.OriginalTo, address)
+				address = rd.CatchAll
+			}
+			key := strings.ToLower(address)
+			if address == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			result = append(result, address)
+		}
+		return result
+	}
+
+	email.To = rewrite(email.To)
+	email.CC = rewrite(email.CC)
+	email.BCC = rewrite(email.BCC)
+
+	if len(email.To)+len(email.CC)+len(email.BCC) == 0 {
+		return email, fmt.Errorf("no recipients are allowed outside of production, original recipients: %s",
+			strings.Join(email.OriginalTo, ", "))
+	}
+	return email, nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/dubass83/go-concurrency-project/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewMailRedirect(t *testing.T) {
+	conf := utils.Config{
+		Enviroment:                 "staging",
+		MailRedirectTo:             "qa@dubass83.xyz",
+		MailRedirectAllowedDomains: " Dubass83.xyz, example.org ,",
+	}
+
+	rd := newMailRedirect(conf)
+	require.NotNil(t, rd)
+	require.Equal(t, "qa@dubass83.xyz", rd.CatchAll)
+	require.Equal(t, []string{"dubass83.xyz", "example.org"}, rd.AllowedDomains)
+
+	conf.Enviroment = productionEnviroment
+	require.Nil(t, newMailRedirect(conf), "production emails are never redirected")
+
+	require.Nil(t, newMailRedirect(utils.Config{Enviroment: "staging"}), "redirection is not configured")
+}
+
+func TestMailRedirectApply(t *testing.T) {
+
+	redirectTests := []struct {
+		name               string
+		redirect           MailRedirect
+		msg                Message
+		expectedTo         []string
+		expectedCC         []string
+		expectedBCC        []string
+		expectedOriginalTo []string
+		expectedError      bool
+	}{
+		{
+			name:     "catchAll",
+			redirect: MailRedirect{CatchAll: "qa@dubass83.xyz"},
+			msg: Message{
+				To:  []string{"customer@example.com"},
+				CC:  []string{"other@example.com"},
+				BCC: []string{"qa@dubass83.xyz"},
+			},
+			expectedTo:         []string{"qa@dubass83.xyz"},
+			expectedOriginalTo: []string{"customer@example.com", "other@example.com"},
+		},
+		{
+			name: "allowedDomain",
+			redirect: MailRedirect{
+				CatchAll:       "qa@dubass83.xyz",
+				AllowedDomains: []string{"dubass83.xyz"},
+			},
+			msg: Message{
+				To: []string{"Dev <dev@Dubass83.xyz>", "customer@example.com"},
+			},
+			expectedTo:         []string{"Dev <dev@Dubass83.xyz>", "qa@dubass83.xyz"},
+			expectedOriginalTo: []string{"customer@example.com"},
+		},
+		{
+			name:     "dropWithoutCatchAll",
+			redirect: MailRedirect{AllowedDomains: []string{"dubass83.xyz"}},
+			msg: Message{
+				To: []string{"dev@dubass83.xyz"},
+				CC: []string{"customer@example.com"},
+			},
+			expectedTo:         []string{"dev@dubass83.xyz"},
+			expectedOriginalTo: []string{"customer@example.com"},
+		},
+		{
+			name:     "nobodyAllowed",
+			redirect: MailRedirect{AllowedDomains: []string{"dubass83.xyz"}},
+			msg: Message{
+				To: []string{"customer@example.com"},
+			},
+			expectedError: true,
+		},
+	}
+
+	for _, rt := range redirectTests {
+		msg, err := rt.redirect.Apply(rt.msg)
+		if rt.expectedError {
+			require.Error(t, err, fmt.Sprintf("test name: %s", rt.name))
+			continue
+		}
+		require.NoError(t, err, fmt.Sprintf("test name: %s", rt.name))
+		require.Equal(t, rt.expectedTo, msg.To, fmt.Sprintf("test name: %s", rt.name))
+		require.Equal(t, rt.expectedCC, msg.CC, fmt.Sprintf("test name: %s", rt.name))
+		require.Equal(t, rt.expectedBCC, msg.BCC, fmt.Sprintf("test name: %s", rt.name))
+		require.Equal(t, rt.expectedOriginalTo, msg.OriginalTo, fmt.Sprintf("test name: %s", rt.name))
+	}
+}
+
+func TestRedirectedMail(t *testing.T) {
+	builder := newMsgBuilder(testApp.Config, testApp.Mail.Templates)
+	builder.Redirect = &MailRedirect{CatchAll: "qa@dubass83.xyz"}
+	sender := NewMemorySender(builder, 1)
+
+	err := sender.SendEmail(Message{
+		To:   []string{"customer@example.com"},
+		Data: "Hello world",
+	})
+	require.NoError(t, err)
+
+	captured := sender.Messages()[0]
+	require.Equal(t, []string{"qa@dubass83.xyz"}, captured.To)
+	require.Contains(t, string(captured.Raw), "X-Original-To: customer@example.com")
+	require.Contains(t, string(captured.Raw), "To: <qa@dubass83.xyz>")
+
+	builder.Redirect = &MailRedirect{AllowedDomains: []string{"dubass83.xyz"}}
+	err = NewMemorySender(builder, 1).SendEmail(Message{
+		To: []string{"customer@example.com"},
+	})
+	require.True(t, isPermanentMailError(err), "email is not sent again")
+}
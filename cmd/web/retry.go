@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"net/textproto"
+	"time"
+
+	"github.com/wneessen/go-mail"
+)
+
+const (
+	defaultRetryMaxAttempts = 5
+	defaultRetryBaseDelay   = 30 * time.Second
+	defaultRetryMaxDelay    = time.Hour
+)
+
+// RetryPolicy describe how many times and how often a message is retried.
+// Zero values are replaced by the defaults from the config
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// permanentMailError mark errors which will not go away on retry,
+// for example a missing template or an invalid recipient address
+type permanentMailError struct {
+	err error
+}
+
+func (e *permanentMailError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentMailError) Unwrap() error {
+	return e.err
+}
+
+func permanent(err error) error {
+	return &permanentMailError{err: err}
+}
+
+// isPermanentMailError report if the message should go to the dead letters
+// without retrying. 5xx smtp replies are permanent, 4xx replies and
+// network errors are transient
+func isPermanentMailError(err error) bool {
+	var pe *permanentMailError
+	if errors.As(err, &pe) {
+		return true
+	}
+
+	var se *mail.SendError
+	if errors.As(err, &se) {
+		switch se.Reason {
+		case mail.ErrGetSender, mail.ErrGetRcpts:
+			return true
+		}
+		return se.ErrorCode() >= 500
+	}
+
+	var te *textproto.Error
+	if errors.As(err, &te) {
+		return te.Code >= 500
+	}
+
+	return false
+}
+
+// retryPolicy return the policy of the message completed with the defaults
+func (app *Server) retryPolicy(msg Message) RetryPolicy {
+	policy := RetryPolicy{
+		MaxAttempts: app.Config.MailRetryMaxAttempts,
+		BaseDelay:   app.Config.MailRetryBaseDelay,
+		MaxDelay:    app.Config.MailRetryMaxDelay,
+	}
+	if msg.Retry != nil {
+		if msg.Retry.MaxAttempts != 0 {
+			policy.MaxAttempts = msg.Retry.MaxAttempts
+		}
+		if msg.Retry.BaseDelay != 0 {
+			policy.BaseDelay = msg.Retry.BaseDelay
+		}
+		if msg.Retry.MaxDelay != 0 {
+			policy.MaxDelay = msg.Retry.MaxDelay
+		}
+	}
+	if policy.MaxAttempts == 0 {
+		policy.MaxAttempts = defaultRetryMaxAttempts
+	}
+	if policy.BaseDelay == 0 {
+		policy.BaseDelay = defaultRetryBaseDelay
+	}
+	if policy.MaxDelay == 0 {
+		policy.MaxDelay = defaultRetryMaxDelay
+	}
+	return policy
+}
+
+// Backoff return the delay before the next attempt. The delay grows
+// exponentially with every attempt and half of it is randomized,
+// so failed messages are not retried all at the same time
+func (policy RetryPolicy) Backoff(attempt int) time.Duration {
+	delay := policy.BaseDelay
+	for i := 1; i < attempt && delay < policy.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
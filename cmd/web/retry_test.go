@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsPermanentMailError(t *testing.T) {
+
+	mailErrorTests := []struct {
+		name      string
+		err       error
+		permanent bool
+	}{
+		{
+			name:      "mailboxUnavailable",
+			err:       &textproto.Error{Code: 550, Msg: "mailbox unavailable"},
+			permanent: true,
+		},
+		{
+			name:      "greylisted",
+			err:       &textproto.Error{Code: 451, Msg: "try again later"},
+			permanent: false,
+		},
+		{
+			name:      "networkError",
+			err:       &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")},
+			permanent: false,
+		},
+		{
+			name:      "missingTemplate",
+			err:       permanent(errors.New("failed to generate plain text message")),
+			permanent: true,
+		},
+		{
+			name:      "wrappedPermanent",
+			err:       fmt.Errorf("sending: %w", &textproto.Error{Code: 554}),
+			permanent: true,
+		},
+	}
+
+	for _, mt := range mailErrorTests {
+		require.Equal(t, mt.permanent, isPermanentMailError(mt.err), fmt.Sprintf("test name: %s", mt.name))
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{
+		MaxAttempts: 5,
+		BaseDelay:   10 * time.Second,
+		MaxDelay:    time.Minute,
+	}
+
+	backoffTests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{attempt: 1, max: 10 * time.Second},
+		{attempt: 2, max: 20 * time.Second},
+		{attempt: 3, max: 40 * time.Second},
+		{attempt: 4, max: time.Minute},
+		{attempt: 10, max: time.Minute},
+	}
+
+	for _, bt := range backoffTests {
+		for range 20 {
+			delay := policy.Backoff(bt.attempt)
+			require.GreaterOrEqual(t, delay, bt.max/2, fmt.Sprintf("attempt: %d", bt.attempt))
+			require.LessOrEqual(t, delay, bt.max, fmt.Sprintf("attempt: %d", bt.attempt))
+		}
+	}
+}
+
+func TestRetryPolicyDefaults(t *testing.T) {
+	policy := testApp.retryPolicy(Message{})
+	require.Equal(t, testApp.Config.MailRetryMaxAttempts, policy.MaxAttempts)
+	require.Equal(t, testApp.Config.MailRetryBaseDelay, policy.BaseDelay)
+
+	policy = testApp.retryPolicy(Message{
+		Retry: &RetryPolicy{MaxAttempts: 1},
+	})
+	require.Equal(t, 1, policy.MaxAttempts)
+	require.Equal(t, testApp.Config.MailRetryMaxDelay, policy.MaxDelay)
+}
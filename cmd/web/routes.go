@@ -1,6 +1,7 @@
 package main
 
 import (
+	"expvar"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
@@ -15,9 +16,25 @@ func (app *Server) MountHandlers() {
 	app.Router.Get("/register", app.RegisterPage)
 	app.Router.Post("/register", app.PostRegisterPage)
 	app.Router.Get("/activate", app.ActivateAccount)
-	app.Router.Get("/test-email", app.SendTestEmail)
+	app.Router.Get("/activate/resend", app.ResendActivationPage)
+	app.Router.Post("/activate/resend", app.PostResendActivation)
+	app.Router.Get("/password/forgot", app.ForgotPasswordPage)
+	app.Router.Post("/password/forgot", app.PostForgotPassword)
+	app.Router.Get("/password/reset", app.ResetPasswordPage)
+	app.Router.Post("/password/reset", app.PostResetPassword)
+	app.Router.Get("/unsubscribe", app.Unsubscribe)
+	app.Router.Post("/unsubscribe", app.PostUnsubscribe)
+	app.Router.Get("/health", app.Health)
+	app.Router.Post("/webhooks/mail", app.MailWebhook)
+	app.Router.Get("/mail/open", app.MailOpen)
+	app.Router.Get("/mail/click", app.MailClick)
 
 	app.Router.Mount("/members", app.AuthRouter())
+	app.Router.Mount("/admin", app.AdminRouter())
+
+	if app.devMode() {
+		app.Router.Mount("/dev", app.DevRouter())
+	}
 }
 
 func (app *Server) AuthRouter() http.Handler {
@@ -26,6 +43,41 @@ func (app *Server) AuthRouter() http.Handler {
 
 	mux.Get("/plans", app.ChooseSubscription)
 	mux.Get("/subscribe", app.SubscribeToPlan)
+	mux.Get("/preferences", app.Preferences)
+	mux.Post("/preferences", app.PostPreferences)
+
+	return mux
+}
+
+func (app *Server) AdminRouter() http.Handler {
+	mux := chi.NewRouter()
+	mux.Use(app.Auth)
+	mux.Use(app.Admin)
+
+	mux.Get("/mail/dead-letters", app.DeadLetters)
+	mux.Post("/mail/dead-letters/redrive", app.RedriveDeadLetter)
+	mux.Get("/mail/messages", app.MailMessages)
+	mux.Get("/mail/message", app.MailMessage)
+	mux.Get("/mail/templates", app.MailTemplatePreview)
+	mux.Post("/mail/templates", app.PostMailTemplatePreview)
+	mux.Post("/mail/templates/send", app.SendTestMailTemplate)
+	mux.Get("/mail/broadcasts", app.MailBroadcasts)
+	mux.Post("/mail/broadcasts", app.PostMailBroadcast)
+	mux.Post("/mail/broadcasts/cancel", app.CancelMailBroadcast)
+	mux.Get("/mail/broadcasts/progress", app.MailBroadcastProgress)
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	return mux
+}
+
+// DevRouter serve pages which must never be available in production
+func (app *Server) DevRouter() http.Handler {
+	mux := chi.NewRouter()
+
+	mux.Get("/mailbox", app.Mailbox)
+	mux.Get("/mailbox/message", app.MailboxMessage)
+	mux.Post("/mailbox/clear", app.ClearMailbox)
+	mux.Post("/mailbox/event", app.SimulateMailEvent)
 
 	return mux
 }
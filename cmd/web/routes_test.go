@@ -12,9 +12,31 @@ var routes = []string{
 	"/logout",
 	"/register",
 	"/activate",
-	"/test-email",
+	"/activate/resend",
+	"/password/forgot",
+	"/password/reset",
 	"/members/plans",
 	"/members/subscribe",
+	"/members/preferences",
+	"/unsubscribe",
+	"/health",
+	"/webhooks/mail",
+	"/mail/open",
+	"/mail/click",
+	"/admin/mail/dead-letters",
+	"/admin/mail/dead-letters/redrive",
+	"/admin/mail/messages",
+	"/admin/mail/message",
+	"/admin/mail/templates",
+	"/admin/mail/templates/send",
+	"/admin/mail/broadcasts",
+	"/admin/mail/broadcasts/cancel",
+	"/admin/mail/broadcasts/progress",
+	"/admin/debug/vars",
+	"/dev/mailbox",
+	"/dev/mailbox/message",
+	"/dev/mailbox/clear",
+	"/dev/mailbox/event",
 }
 
 func TestRoutesExist(t *testing.T) {
@@ -16,6 +16,9 @@ type Server struct {
 	Store       data.Store
 	Wait        *sync.WaitGroup
 	Mail        Mail
+	URLs        *URLBuilder
+	Keys        *SigningKeys
+	Tokens      *TokenService
 	ErrChan     chan error
 	ErrChanDone chan bool
 }
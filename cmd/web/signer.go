@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
@@ -10,14 +12,20 @@ import (
 
 // GenerateTokenFromString generates a signed token
 func (app *Server) GenerateTokenFromString(data string) string {
-	secretKey := []byte(app.Config.TokenSecret)
+	return signURL(app.Keys.Active(), data)
+}
+
+// signURL sign the url with the key, the key id is added as the kid parameter
+// and the signature as the hash parameter
+func signURL(key SigningKey, data string) string {
+	secretKey := []byte(key.Secret)
 	var urlToSign string
 
 	s := goalone.New(secretKey, goalone.Timestamp)
 	if strings.Contains(data, "?") {
-		urlToSign = fmt.Sprintf("%s&hash=", data)
+		urlToSign = fmt.Sprintf("%s&kid=%s&hash=", data, key.ID)
 	} else {
-		urlToSign = fmt.Sprintf("%s?hash=", data)
+		urlToSign = fmt.Sprintf("%s?kid=%s&hash=", data, key.ID)
 	}
 
 	tokenBytes := s.Sign([]byte(urlToSign))
@@ -26,10 +34,30 @@ func (app *Server) GenerateTokenFromString(data string) string {
 	return token
 }
 
+// tokenKey return the key named by the kid parameter of the signed url
+func (app *Server) tokenKey(token string) (SigningKey, bool) {
+	u, err := url.Parse(token)
+	if err != nil {
+		return SigningKey{}, false
+	}
+	return app.Keys.Lookup(u.Query().Get("kid"))
+}
+
+// verifyRequest verify the signed link of the request. Links are signed without the
+// base url, links signed with it before are verified against the public base url
+func (app *Server) verifyRequest(r *http.Request) bool {
+	uri := r.URL.RequestURI()
+	return app.VerifyToken(uri) || app.VerifyToken(app.URLs.Link(nil, uri))
+}
+
 // VerifyToken verifies a signed token
 func (app *Server) VerifyToken(token string) bool {
-	secretKey := []byte(app.Config.TokenSecret)
-	s := goalone.New(secretKey, goalone.Timestamp)
+	key, ok := app.tokenKey(token)
+	if !ok {
+		// the key is unknown or retired
+		return false
+	}
+	s := goalone.New([]byte(key.Secret), goalone.Timestamp)
 	_, err := s.Unsign([]byte(token))
 
 	if err != nil {
@@ -44,8 +72,11 @@ func (app *Server) VerifyToken(token string) bool {
 
 // Expired checks to see if a token has expired
 func (app *Server) TokenExpired(token string, minutesUntilExpire int) bool {
-	secretKey := []byte(app.Config.TokenSecret)
-	s := goalone.New(secretKey, goalone.Timestamp)
+	key, ok := app.tokenKey(token)
+	if !ok {
+		return true
+	}
+	s := goalone.New([]byte(key.Secret), goalone.Timestamp)
 	ts := s.Parse([]byte(token))
 
 	// time.Duration(seconds)*time.Second
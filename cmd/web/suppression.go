@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	netmail "net/mail"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// reasons of suppressed addresses
+const (
+	suppressionBounce    = "bounce"
+	suppressionComplaint = "complaint"
+)
+
+// normalizeAddress return the lower case address without the display name
+func normalizeAddress(address string) string {
+	if a, err := netmail.ParseAddress(address); err == nil {
+		address = a.Address
+	}
+	return strings.ToLower(strings.TrimSpace(address))
+}
+
+// filterSuppressed remove recipients which hard-bounced or complained,
+// it report false when nobody is left
+func (app *Server) filterSuppressed(ctx context.Context, msg Message) (Message, bool, error) {
+	emails := make([]string, 0, len(msg.To)+len(msg.CC)+len(msg.BCC))
+	for _, recipients := range [][]string{msg.To, msg.CC, msg.BCC} {
+		for _, recipient := range recipients {
+			emails = append(emails, normalizeAddress(recipient))
+		}
+	}
+	if len(emails) == 0 {
+		return msg, false, nil
+	}
+
+	rows, err := app.Store.GetSuppressedEmails(ctx, emails)
+	if err != nil {
+		return msg, false, fmt.Errorf("failed to check suppressed addresses: %s", err)
+	}
+	if len(rows) == 0 {
+		return msg, true, nil
+	}
+
+	suppressed := make(map[string]bool, len(rows))
+	for _, email := range rows {
+		suppressed[email] = true
+	}
+	filter := func(recipients []string) []string {
+		var allowed []string
+		for _, recipient := range recipients {
+			if suppressed[normalizeAddress(recipient)] {
+				mailMetrics.Add("suppressed", 1)
+				log.Info().
+					Str("recipient", recipient).
+					Int64("outbox_id", msg.OutboxID).
+					Msg("email is skipped, recipient address is suppressed")
+				continue
+			}
+			allowed = append(allowed, recipient)
+		}
+		return allowed
+	}
+
+	msg.To = filter(msg.To)
+	msg.CC = filter(msg.CC)
+	msg.BCC = filter(msg.BCC)
+	return msg, len(msg.To)+len(msg.CC)+len(msg.BCC) > 0, nil
+}
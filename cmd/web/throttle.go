@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dubass83/go-concurrency-project/utils"
+	"github.com/gomodule/redigo/redis"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultFailedLoginThrottle   = 15 * time.Minute
+	defaultActivationThrottle    = 5 * time.Minute
+	defaultPasswordResetThrottle = 5 * time.Minute
+	mailThrottlePrefix           = "mail-throttle:"
+)
+
+// MailThrottle limit how often the same email is sent to one recipient
+type MailThrottle interface {
+	// Allow report if the email can be sent now and
+	// start a new window for the recipient and template
+	Allow(ctx context.Context, recipient, template string, window time.Duration) (bool, error)
+}
+
+// mailThrottleWindows return how often every throttled template can be sent to one recipient
+func mailThrottleWindows(conf utils.Config) map[string]time.Duration {
+	failedLogin := conf.MailThrottleFailedLogin
+	if failedLogin == 0 {
+		failedLogin = defaultFailedLoginThrottle
+	}
+	activation := conf.MailThrottleActivation
+	if activation == 0 {
+		activation = defaultActivationThrottle
+	}
+	passwordReset := conf.MailThrottlePasswordReset
+	if passwordReset == 0 {
+		passwordReset = defaultPasswordResetThrottle
+	}
+	// activation emails of new accounts are stored with the user and skip the throttle,
+	// only links requested again on /activate/resend are limited
+	return map[string]time.Duration{
+		mailTemplateFailedLogin:   failedLogin,
+		mailTemplateConfirmation:  activation,
+		mailTemplatePasswordReset: passwordReset,
+	}
+}
+
+func mailThrottleKey(recipient, template string) string {
+	return fmt.Sprintf("%s%s:%s", mailThrottlePrefix, template, strings.ToLower(strings.TrimSpace(recipient)))
+}
+
+// RedisMailThrottle keep throttle windows in redis, so they are shared by all instances of the app
+type RedisMailThrottle struct {
+	Pool *redis.Pool
+}
+
+func (t *RedisMailThrottle) Allow(ctx context.Context, recipient, template string, window time.Duration) (bool, error) {
+	conn, err := t.Pool.GetContext(ctx)
+	if err != nil {
+		return true, fmt.Errorf("failed to get redis connection: %s", err)
+	}
+	defer conn.Close()
+
+	// the key is set only if the window is not started yet
+	_, err = redis.String(conn.Do("SET", mailThrottleKey(recipient, template), 1, "PX", window.Milliseconds(), "NX"))
+	if err == redis.ErrNil {
+		return false, nil
+	}
+	if err != nil {
+		return true, fmt.Errorf("failed to set mail throttle key: %s", err)
+	}
+	return true, nil
+}
+
+// throttleMail remove recipients which already got the same email in the throttle window,
+// it report false when nobody is left. Throttle errors do not block the email.
+func (app *Server) throttleMail(ctx context.Context, msg Message) (Message, bool) {
+	window := app.Mail.ThrottleWindows[msg.Template]
+	if window == 0 || app.Mail.Throttle == nil {
+		return msg, true
+	}
+
+	allowed := make([]string, 0, len(msg.To))
+	for _, recipient := range msg.To {
+		ok, err := app.Mail.Throttle.Allow(ctx, recipient, msg.Template, window)
+		if err != nil {
+			log.Error().Err(err).Str("template", msg.Template).Msg("failed to check mail throttle")
+		}
+		if !ok {
+			mailMetrics.Add("throttled", 1)
+			log.Info().
+				Str("recipient", recipient).
+				Str("template", msg.Template).
+				Dur("window", window).
+				Msg("email is suppressed by the throttle")
+			continue
+		}
+		allowed = append(allowed, recipient)
+	}
+
+	msg.To = allowed
+	return msg, len(allowed) > 0
+}
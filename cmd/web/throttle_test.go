@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"sync"
+	"testing"
+	"time"
+
+	mockdb "github.com/dubass83/go-concurrency-project/data/mock"
+	data "github.com/dubass83/go-concurrency-project/data/sqlc"
+	"github.com/dubass83/go-concurrency-project/utils"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryMailThrottle(t *testing.T) {
+	throttle := newMemoryMailThrottle()
+	ctx := context.Background()
+
+	ok, err := throttle.Allow(ctx, "user@example.com", mailTemplateFailedLogin, 50*time.Millisecond)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	ok, _ = throttle.Allow(ctx, "User@Example.com ", mailTemplateFailedLogin, 50*time.Millisecond)
+	require.False(t, ok, "recipient address is case insensitive")
+
+	ok, _ = throttle.Allow(ctx, "user@example.com", mailTemplateInvoice, 50*time.Millisecond)
+	require.True(t, ok, "every template has its own window")
+
+	time.Sleep(60 * time.Millisecond)
+	ok, _ = throttle.Allow(ctx, "user@example.com", mailTemplateFailedLogin, 50*time.Millisecond)
+	require.True(t, ok, "window is over")
+}
+
+func TestEnqueueThrottledMail(t *testing.T) {
+	recipient := utils.RandomEmail()
+	other := utils.RandomEmail()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	store := mockdb.NewMockStore(ctrl)
+	store.EXPECT().
+		EnqueueMailTx(gomock.Any(), gomock.Any()).
+		Times(2).
+		Return(data.EnqueueMailTxResult{}, nil)
+
+	testApp.Store = store
+
+	before := int64(0)
+	if throttled, ok := mailMetrics.Get("throttled").(*expvar.Int); ok {
+		before = throttled.Value()
+	}
+
+	msg := Message{
+		To:       []string{recipient},
+		Subject:  "Failed log in attempt",
+		Template: mailTemplateFailedLogin,
+	}
+	// the first notice is sent, the second one is suppressed
+	require.NoError(t, testApp.enqueueMail(context.Background(), msg))
+	require.NoError(t, testApp.enqueueMail(context.Background(), msg))
+
+	// the notice is still sent to other recipients
+	msg.To = []string{recipient, other}
+	filtered, ok := testApp.throttleMail(context.Background(), msg)
+	require.True(t, ok)
+	require.Equal(t, []string{other}, filtered.To)
+
+	// templates without a window are not throttled
+	_, ok = testApp.throttleMail(context.Background(), Message{To: []string{recipient}, Template: mailTemplateInvoice})
+	require.True(t, ok)
+	require.NoError(t, testApp.enqueueMail(context.Background(), Message{To: []string{recipient}, Template: mailTemplateInvoice}))
+
+	after := mailMetrics.Get("throttled").(*expvar.Int).Value()
+	require.Equal(t, before+2, after, "suppressed sends are counted")
+}
+
+// memoryMailThrottle keep throttle windows in the process
+type memoryMailThrottle struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+}
+
+func newMemoryMailThrottle() *memoryMailThrottle {
+	return &memoryMailThrottle{
+		until: make(map[string]time.Time),
+	}
+}
+
+func (t *memoryMailThrottle) Allow(_ context.Context, recipient, template string, window time.Duration) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := mailThrottleKey(recipient, template)
+	now := time.Now()
+	if now.Before(t.until[key]) {
+		return false, nil
+	}
+	t.until[key] = now.Add(window)
+	return true, nil
+}
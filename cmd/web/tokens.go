@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	goalone "github.com/bwmarrin/go-alone"
+	"github.com/gomodule/redigo/redis"
+)
+
+// purposes of signed tokens, a token issued for one purpose is rejected by the rest
+const (
+	tokenActivation    = "activation"
+	tokenPasswordReset = "password-reset"
+	tokenEmailChange   = "email-change"
+	tokenMagicLogin    = "magic-login"
+)
+
+const tokenNoncePrefix = "token-nonce:"
+
+var (
+	errTokenInvalid = errors.New("token is invalid")
+	errTokenExpired = errors.New("token is expired")
+	errTokenUsed    = errors.New("token is already used")
+)
+
+// TokenClaims is the signed content of the token
+type TokenClaims struct {
+	Purpose   string `json:"purpose"`
+	Subject   string `json:"sub"`
+	Nonce     string `json:"nonce"`
+	ExpiresAt int64  `json:"exp"`
+	// Version bind the token to the state of the subject, the caller reject
+	// tokens whose version is not current any more
+	Version string `json:"ver,omitempty"`
+}
+
+// NonceLedger remember nonces of used tokens until the tokens expire
+type NonceLedger interface {
+	// Use mark the nonce as used, it report false when it was used before
+	Use(ctx context.Context, nonce string, ttl time.Duration) (bool, error)
+	// Release forget the nonce, so the token can be used again
+	Release(ctx context.Context, nonce string) error
+}
+
+// TokenService issue tokens bound to a purpose, a subject and an expiry.
+// Tokens are signed with a key derived for every purpose and can be consumed only once
+type TokenService struct {
+	Keys   *SigningKeys
+	Ledger NonceLedger
+	now    func() time.Time
+}
+
+func NewTokenService(keys *SigningKeys, ledger NonceLedger) *TokenService {
+	return &TokenService{
+		Keys:   keys,
+		Ledger: ledger,
+		now:    time.Now,
+	}
+}
+
+// signer return the signer of tokens of the purpose
+func signer(key SigningKey, purpose string) *goalone.Sword {
+	mac := hmac.New(sha256.New, []byte(key.Secret))
+	mac.Write([]byte("token:" + purpose))
+	return goalone.New(mac.Sum(nil))
+}
+
+// Issue return the url-safe token of the subject which is valid for ttl,
+// the token starts with the id of the active key
+func (ts *TokenService) Issue(purpose, subject string, ttl time.Duration) (string, error) {
+	return ts.IssueVersion(purpose, subject, "", ttl)
+}
+
+// IssueVersion return the token bound to the version of the subject
+func (ts *TokenService) IssueVersion(purpose, subject, version string, ttl time.Duration) (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate token nonce: %s", err)
+	}
+	payload, err := json.Marshal(TokenClaims{
+		Purpose:   purpose,
+		Subject:   subject,
+		Nonce:     hex.EncodeToString(nonce),
+		ExpiresAt: ts.now().Add(ttl).Unix(),
+		Version:   version,
+	})
+	if err != nil {
+		return "", err
+	}
+	data := base64.RawURLEncoding.EncodeToString(payload)
+	key := ts.Keys.Active()
+	return key.ID + "." + string(signer(key, purpose).Sign([]byte(data))), nil
+}
+
+// tokenKey split the key id from the token and return the key named by it.
+// Tokens issued before key ids were added have only the data and the signature
+func (ts *TokenService) tokenKey(token string) (SigningKey, string, bool) {
+	id := ""
+	if strings.Count(token, ".") > 1 {
+		id, token, _ = strings.Cut(token, ".")
+	}
+	key, ok := ts.Keys.Lookup(id)
+	return key, token, ok
+}
+
+// Check verify the token without using it. Claims of expired tokens are returned
+// together with errTokenExpired, so the subject can be offered a new token
+func (ts *TokenService) Check(purpose, token string) (TokenClaims, error) {
+	key, token, ok := ts.tokenKey(token)
+	if !ok {
+		return TokenClaims{}, errTokenInvalid
+	}
+	data, err := signer(key, purpose).Unsign([]byte(token))
+	if err != nil {
+		return TokenClaims{}, errTokenInvalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(string(data))
+	if err != nil {
+		return TokenClaims{}, errTokenInvalid
+	}
+	var claims TokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Purpose != purpose || claims.Nonce == "" {
+		return TokenClaims{}, errTokenInvalid
+	}
+	if !ts.now().Before(time.Unix(claims.ExpiresAt, 0)) {
+		return claims, errTokenExpired
+	}
+	return claims, nil
+}
+
+// Consume verify the token and record its nonce, every token is accepted only once.
+// Ledger errors reject the token, because reuse can not be ruled out
+func (ts *TokenService) Consume(ctx context.Context, purpose, token string) (TokenClaims, error) {
+	claims, err := ts.Check(purpose, token)
+	if err != nil {
+		return claims, err
+	}
+
+	ttl := time.Unix(claims.ExpiresAt, 0).Sub(ts.now())
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	ok, err := ts.Ledger.Use(ctx, purpose+":"+claims.Nonce, ttl)
+	if err != nil {
+		return claims, fmt.Errorf("failed to record token nonce: %s", err)
+	}
+	if !ok {
+		return claims, errTokenUsed
+	}
+	return claims, nil
+}
+
+// Release return the consumed token, so it can be used again when the action
+// it authorized failed
+func (ts *TokenService) Release(ctx context.Context, claims TokenClaims) error {
+	return ts.Ledger.Release(ctx, claims.Purpose+":"+claims.Nonce)
+}
+
+// RedisNonceLedger keep used nonces in redis, so they are shared by all instances of the app
+type RedisNonceLedger struct {
+	Pool *redis.Pool
+}
+
+func (l *RedisNonceLedger) Use(ctx context.Context, nonce string, ttl time.Duration) (bool, error) {
+	conn, err := l.Pool.GetContext(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to get redis connection: %s", err)
+	}
+	defer conn.Close()
+
+	// the key is set only by the first use of the token
+	_, err = redis.String(conn.Do("SET", tokenNoncePrefix+nonce, 1, "PX", ttl.Milliseconds(), "NX"))
+	if err == redis.ErrNil {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to set token nonce key: %s", err)
+	}
+	return true, nil
+}
+
+func (l *RedisNonceLedger) Release(ctx context.Context, nonce string) error {
+	conn, err := l.Pool.GetContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get redis connection: %s", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", tokenNoncePrefix+nonce); err != nil {
+		return fmt.Errorf("failed to delete token nonce key: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dubass83/go-concurrency-project/utils"
+	"github.com/stretchr/testify/require"
+)
+
+// failingLedger return the error for every nonce
+type failingLedger struct {
+	err error
+}
+
+func (l failingLedger) Use(context.Context, string, time.Duration) (bool, error) {
+	return false, l.err
+}
+
+func (l failingLedger) Release(context.Context, string) error {
+	return l.err
+}
+
+// testKeys return the signing keys with the single default key
+func testKeys(t *testing.T, secret string) *SigningKeys {
+	keys, err := newSigningKeys(utils.Config{TokenSecret: secret})
+	require.NoError(t, err)
+	return keys
+}
+
+func TestTokenService(t *testing.T) {
+	ctx := context.Background()
+	tokens := NewTokenService(testKeys(t, "secret"), newMemoryNonceLedger())
+
+	token, err := tokens.Issue(tokenPasswordReset, "user@example.com", time.Hour)
+	require.NoError(t, err)
+
+	claims, err := tokens.Check(tokenPasswordReset, token)
+	require.NoError(t, err)
+	require.Equal(t, "user@example.com", claims.Subject)
+	require.Equal(t, tokenPasswordReset, claims.Purpose)
+
+	_, err = tokens.Check(tokenMagicLogin, token)
+	require.ErrorIs(t, err, errTokenInvalid, "the token is bound to its purpose")
+
+	_, err = NewTokenService(testKeys(t, "other"), newMemoryNonceLedger()).Check(tokenPasswordReset, token)
+	require.ErrorIs(t, err, errTokenInvalid)
+
+	tampered := strings.Replace(token, token[8:12], "AAAA", 1)
+	_, err = tokens.Check(tokenPasswordReset, tampered)
+	require.ErrorIs(t, err, errTokenInvalid)
+
+	_, err = tokens.Check(tokenPasswordReset, "")
+	require.ErrorIs(t, err, errTokenInvalid)
+
+	// checking does not use the token
+	_, err = tokens.Check(tokenPasswordReset, token)
+	require.NoError(t, err)
+
+	claims, err = tokens.Consume(ctx, tokenPasswordReset, token)
+	require.NoError(t, err)
+	require.Equal(t, "user@example.com", claims.Subject)
+
+	_, err = tokens.Consume(ctx, tokenPasswordReset, token)
+	require.ErrorIs(t, err, errTokenUsed, "the token is accepted only once")
+
+	require.NoError(t, tokens.Release(ctx, claims))
+	_, err = tokens.Consume(ctx, tokenPasswordReset, token)
+	require.NoError(t, err, "released tokens are accepted again")
+
+	other, err := tokens.Issue(tokenPasswordReset, "user@example.com", time.Hour)
+	require.NoError(t, err)
+	require.NotEqual(t, token, other, "every token has its own nonce")
+	_, err = tokens.Consume(ctx, tokenPasswordReset, other)
+	require.NoError(t, err)
+}
+
+func TestTokenServiceExpired(t *testing.T) {
+	tokens := NewTokenService(testKeys(t, "secret"), newMemoryNonceLedger())
+	token, err := tokens.Issue(tokenEmailChange, "user@example.com", time.Hour)
+	require.NoError(t, err)
+
+	tokens.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+
+	claims, err := tokens.Check(tokenEmailChange, token)
+	require.ErrorIs(t, err, errTokenExpired)
+	require.Equal(t, "user@example.com", claims.Subject, "claims of expired tokens are returned")
+
+	_, err = tokens.Consume(context.Background(), tokenEmailChange, token)
+	require.ErrorIs(t, err, errTokenExpired)
+}
+
+func TestTokenServiceLedgerError(t *testing.T) {
+	tokens := NewTokenService(testKeys(t, "secret"), failingLedger{err: errors.New("connection refused")})
+	token, err := tokens.Issue(tokenActivation, "user@example.com", time.Hour)
+	require.NoError(t, err)
+
+	_, err = tokens.Consume(context.Background(), tokenActivation, token)
+	require.Error(t, err, "tokens are rejected when reuse can not be ruled out")
+	require.NotErrorIs(t, err, errTokenUsed)
+}
+
+// memoryNonceLedger keep used nonces in the process
+type memoryNonceLedger struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+}
+
+func newMemoryNonceLedger() *memoryNonceLedger {
+	return &memoryNonceLedger{
+		until: make(map[string]time.Time),
+	}
+}
+
+func (l *memoryNonceLedger) Use(_ context.Context, nonce string, ttl time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Before(l.until[nonce]) {
+		return false, nil
+	}
+	l.until[nonce] = now.Add(ttl)
+	return true, nil
+}
+
+func (l *memoryNonceLedger) Release(_ context.Context, nonce string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.until, nonce)
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/dubass83/go-concurrency-project/utils"
+)
+
+// kinds of tracking events
+const (
+	trackingOpen  = "open"
+	trackingClick = "click"
+)
+
+// MailTracking rewrite links of html emails through the signed click redirect
+// and embed the open pixel, so reads are recorded by the message id
+type MailTracking struct {
+	URLs *URLBuilder
+	Keys *SigningKeys
+}
+
+// newMailTracking return nil when MAIL_TRACKING is disabled,
+// links are built and signed with the urls and keys of the server
+func newMailTracking(conf utils.Config, keys *SigningKeys, urls *URLBuilder) *MailTracking {
+	if !conf.MailTracking {
+		return nil
+	}
+	return &MailTracking{URLs: urls, Keys: keys}
+}
+
+// Tracker return the tracker of the message, only messages of the mail outbox
+// have the message id so the rest are not tracked. Security messages carry
+// one-time tokens in their links, so they are never redirected or recorded
+func (mt *MailTracking) Tracker(email Message) *messageTracker {
+	if mt == nil || email.MessageID == "" || messageCategory(email) == mailCategorySecurity {
+		return nil
+	}
+	return &messageTracker{
+		tracking:  mt,
+		messageID: email.MessageID,
+		skip:      email.UnsubscribeURL,
+	}
+}
+
+// clickURL return the signed redirect to the link
+func (mt *MailTracking) clickURL(messageID, link string) string {
+	// messages are tracked by the outbox outside of requests
+	return mt.URLs.Link(nil, signURL(mt.Keys.Active(), fmt.Sprintf("/mail/click?id=%s&url=%s",
+		url.QueryEscape(messageID),
+		url.QueryEscape(link),
+	)))
+}
+
+// openURL return the signed link of the tracking pixel
+func (mt *MailTracking) openURL(messageID string) string {
+	return mt.URLs.Link(nil, signURL(mt.Keys.Active(), "/mail/open?id="+url.QueryEscape(messageID)))
+}
+
+// messageTracker rewrite the html body of a single message
+type messageTracker struct {
+	tracking  *MailTracking
+	messageID string
+	// skip is the unsubscribe link, it must work without the redirect
+	skip string
+}
+
+// apply redirect web links of the html through the click endpoint and add the pixel
+// to the end of the body, a nil tracker return the html as is
+func (t *messageTracker) apply(body string) (string, error) {
+	if t == nil {
+		return body, nil
+	}
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse html for tracking: %s", err)
+	}
+	doc.Find("a[href]").Each(func(_ int, a *goquery.Selection) {
+		href, _ := a.Attr("href")
+		if href == t.skip || !webLink(href) {
+			return
+		}
+		a.SetAttr("href", t.tracking.clickURL(t.messageID, href))
+	})
+	doc.Find("body").AppendHtml(fmt.Sprintf(
+		`<img src="%s" width="1" height="1" alt="" style="display:none">`,
+		html.EscapeString(t.tracking.openURL(t.messageID)),
+	))
+	return doc.Html()
+}
+
+// webLink report if the link is an absolute http or https url,
+// other links like mailto: are never redirected
+func webLink(link string) bool {
+	u, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
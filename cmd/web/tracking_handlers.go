@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	data "github.com/dubass83/go-concurrency-project/data/sqlc"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/rs/zerolog/log"
+)
+
+// trackingPixel is the transparent 1x1 gif returned by the open endpoint
+var trackingPixel = []byte{
+	0x47, 0x49, 0x46, 0x38, 0x39, 0x61, 0x01, 0x00, 0x01, 0x00, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0xff, 0xff, 0xff, 0x21, 0xf9, 0x04, 0x01, 0x00, 0x00, 0x00, 0x00, 0x2c, 0x00, 0x00, 0x00, 0x00,
+	0x01, 0x00, 0x01, 0x00, 0x00, 0x02, 0x02, 0x44, 0x01, 0x00, 0x3b,
+}
+
+// verifyTracking check the signature of the tracking link and return the message id from it
+func (app *Server) verifyTracking(r *http.Request) (string, bool) {
+	if !app.verifyRequest(r) {
+		return "", false
+	}
+	id := r.URL.Query().Get("id")
+	return id, id != ""
+}
+
+// MailOpen record the open of the html email and return the tracking pixel,
+// the pixel is returned for invalid links as well so mail clients never show a broken image
+func (app *Server) MailOpen(w http.ResponseWriter, r *http.Request) {
+	if id, ok := app.verifyTracking(r); ok {
+		app.recordTracking(r.Context(), id, trackingOpen, "")
+	}
+
+	w.Header().Set("Content-Type", "image/gif")
+	w.Header().Set("Cache-Control", "no-store, max-age=0")
+	_, _ = w.Write(trackingPixel)
+}
+
+// MailClick record the click of the link and redirect to it,
+// links with invalid signatures are never followed
+func (app *Server) MailClick(w http.ResponseWriter, r *http.Request) {
+	id, ok := app.verifyTracking(r)
+	link := r.URL.Query().Get("url")
+	if !ok || !webLink(link) {
+		http.Error(w, "invalid link", http.StatusBadRequest)
+		return
+	}
+	app.recordTracking(r.Context(), id, trackingClick, link)
+
+	http.Redirect(w, r, link, http.StatusFound)
+}
+
+// recordTracking store the event, failures are only logged so the reader is not affected
+func (app *Server) recordTracking(ctx context.Context, messageID, kind, link string) {
+	err := app.Store.InsertMailTrackingEvent(ctx, data.InsertMailTrackingEventParams{
+		MessageID: messageID,
+		Kind:      kind,
+		Url: pgtype.Text{
+			String: link,
+			Valid:  link != "",
+		},
+	})
+	if err != nil {
+		log.Error().Err(err).Str("message_id", messageID).Str("kind", kind).Msg("failed to record tracking event")
+		return
+	}
+	mailMetrics.Add("tracking_"+kind, 1)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	mockdb "github.com/dubass83/go-concurrency-project/data/mock"
+	data "github.com/dubass83/go-concurrency-project/data/sqlc"
+	"github.com/dubass83/go-concurrency-project/utils"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMailTracking(t *testing.T) {
+	require.Nil(t, newMailTracking(utils.Config{}, testApp.Keys, testApp.URLs), "tracking is disabled by default")
+
+	builder := newMsgBuilder(testApp.Config, testApp.Mail.Templates)
+	builder.Tracking = newMailTracking(utils.Config{MailTracking: true}, testApp.Keys, testApp.URLs)
+	sender := NewMemorySender(builder, 2)
+
+	link := "https://example.com/news?id=42"
+	err := sender.SendEmail(Message{
+		MessageID: "5b0e3c1a",
+		To:        []string{"user@example.com"},
+		Template:  mailTemplateDefault,
+		Data:      template.HTML(`<a href="` + link + `">news</a>`),
+	})
+	require.NoError(t, err)
+
+	html := sender.Messages()[0].HTML
+	require.Contains(t, html, "http://localhost:8080/mail/click?id=5b0e3c1a&amp;url=https%3A%2F%2Fexample.com%2Fnews")
+	require.Contains(t, html, `<img src="http://localhost:8080/mail/open?id=5b0e3c1a&amp;kid=default&amp;hash=`)
+	require.NotContains(t, html, `href="`+link+`"`, "the link is redirected")
+	require.Contains(t, sender.Messages()[0].Plain, link, "plain text is not tracked")
+
+	err = sender.SendEmail(Message{
+		To:       []string{"user@example.com"},
+		Template: mailTemplateDefault,
+		Data:     template.HTML(`<a href="` + link + `">news</a>`),
+	})
+	require.NoError(t, err)
+	require.NotContains(t, sender.Messages()[0].HTML, "/mail/open", "messages without id are not tracked")
+
+	activation := "https://example.com/activate?token=abc"
+	err = sender.SendEmail(Message{
+		MessageID: "5b0e3c1b",
+		To:        []string{"user@example.com"},
+		Template:  mailTemplateConfirmation,
+		Data:      template.HTML(activation),
+	})
+	require.NoError(t, err)
+	html = sender.Messages()[0].HTML
+	require.NotContains(t, html, "/mail/click", "links with tokens are not redirected")
+	require.NotContains(t, html, "/mail/open", "security messages are not tracked")
+	require.Contains(t, html, activation)
+}
+
+func TestMessageTrackerSkip(t *testing.T) {
+	tracking := &MailTracking{URLs: testApp.URLs, Keys: testApp.Keys}
+	tracker := tracking.Tracker(Message{
+		MessageID:      "5b0e3c1a",
+		UnsubscribeURL: "http://localhost:8080/unsubscribe?email=user@example.com",
+	})
+
+	html, err := tracker.apply(`<html><body>` +
+		`<a href="http://localhost:8080/unsubscribe?email=user@example.com">unsubscribe</a>` +
+		`<a href="mailto:support@example.com">support</a>` +
+		`<a href="#top">top</a>` +
+		`</body></html>`)
+	require.NoError(t, err)
+	require.Contains(t, html, `href="http://localhost:8080/unsubscribe?email=user@example.com"`)
+	require.Contains(t, html, `href="mailto:support@example.com"`)
+	require.Contains(t, html, `href="#top"`)
+	require.NotContains(t, html, "/mail/click")
+
+	require.Nil(t, tracking.Tracker(Message{}))
+	var none *messageTracker
+	html, err = none.apply("<p>hello</p>")
+	require.NoError(t, err)
+	require.Equal(t, "<p>hello</p>", html)
+}
+
+func TestMailTrackingHandlers(t *testing.T) {
+	tracking := &MailTracking{URLs: testApp.URLs, Keys: testApp.Keys}
+	requestURI := func(signed string) string {
+		return strings.TrimPrefix(signed, testApp.URLs.Link(nil, ""))
+	}
+	link := "https://example.com/invoices/1"
+	clickURL := requestURI(tracking.clickURL("5b0e3c1a", link))
+	openURL := requestURI(tracking.openURL("5b0e3c1a"))
+
+	trackingTests := []struct {
+		name               string
+		url                string
+		buildStubs         func(store *mockdb.MockStore)
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{
+			name: "click",
+			url:  clickURL,
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					InsertMailTrackingEvent(gomock.Any(), gomock.Any()).
+					Times(1).
+					DoAndReturn(func(_ context.Context, arg data.InsertMailTrackingEventParams) error {
+						require.Equal(t, "5b0e3c1a", arg.MessageID)
+						require.Equal(t, trackingClick, arg.Kind)
+						require.Equal(t, link, arg.Url.String)
+						return nil
+					})
+			},
+			expectedStatusCode: http.StatusFound,
+			expectedLocation:   link,
+		},
+		{
+			name: "clickTampered",
+			url:  strings.Replace(clickURL, "example.com", "evil.example", 1),
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					InsertMailTrackingEvent(gomock.Any(), gomock.Any()).
+					Times(0)
+			},
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name: "open",
+			url:  openURL,
+			buildStubs: func(store *mockdb.MockStore) {
+				arg := data.InsertMailTrackingEventParams{
+					MessageID: "5b0e3c1a",
+					Kind:      trackingOpen,
+				}
+				store.EXPECT().
+					InsertMailTrackingEvent(gomock.Any(), gomock.Eq(arg)).
+					Times(1).
+					Return(nil)
+			},
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name: "openTampered",
+			url:  strings.Replace(openURL, "5b0e3c1a", "00000000", 1),
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					InsertMailTrackingEvent(gomock.Any(), gomock.Any()).
+					Times(0)
+			},
+			expectedStatusCode: http.StatusOK,
+		},
+	}
+
+	for _, tt := range trackingTests {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := mockdb.NewMockStore(ctrl)
+		tt.buildStubs(store)
+
+		testApp.Store = store
+
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", tt.url, nil)
+
+		testApp.Router.ServeHTTP(rr, req)
+
+		require.Equal(t, tt.expectedStatusCode, rr.Code, fmt.Sprintf("test name: %s", tt.name))
+		if len(tt.expectedLocation) > 0 {
+			require.Equal(t, tt.expectedLocation, rr.Header().Get("Location"), fmt.Sprintf("test name: %s", tt.name))
+		}
+		if strings.HasPrefix(tt.url, "/mail/open") {
+			require.Equal(t, "image/gif", rr.Header().Get("Content-Type"), fmt.Sprintf("test name: %s", tt.name))
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+
+	"github.com/dubass83/go-concurrency-project/utils"
+)
+
+const (
+	headerForwardedProto = "X-Forwarded-Proto"
+	headerForwardedHost  = "X-Forwarded-Host"
+)
+
+// URLBuilder build absolute links of emails. The public base url is PUBLIC_BASE_URL,
+// without it the base is taken from forwarded headers of TRUSTED_PROXIES and the Host
+// header of the request is never used, as any client can set it
+type URLBuilder struct {
+	// Base is PUBLIC_BASE_URL without the trailing slash, empty when it is not set
+	Base string
+	// Fallback is the base of links when Base is empty and the request is not forwarded
+	Fallback       string
+	TrustedProxies []netip.Prefix
+}
+
+func newURLBuilder(conf utils.Config) (*URLBuilder, error) {
+	ub := &URLBuilder{
+		Fallback: fmt.Sprintf("http://localhost:%s", conf.WebPort),
+	}
+
+	if base := strings.TrimSpace(conf.PublicBaseURL); base != "" {
+		u, err := url.Parse(base)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PUBLIC_BASE_URL: %s", err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid PUBLIC_BASE_URL %s, expected http(s)://host[/path]", base)
+		}
+		if u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+			return nil, fmt.Errorf("invalid PUBLIC_BASE_URL %s, query, fragment and user info are not allowed", base)
+		}
+		ub.Base = strings.TrimSuffix(u.String(), "/")
+	}
+
+	for _, entry := range strings.Split(conf.TrustedProxies, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		prefix, err := parseProxy(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %s: %s", entry, err)
+		}
+		ub.TrustedProxies = append(ub.TrustedProxies, prefix)
+	}
+	return ub, nil
+}
+
+// parseProxy accept the address or the network of proxies
+func parseProxy(entry string) (netip.Prefix, error) {
+	if strings.Contains(entry, "/") {
+		prefix, err := netip.ParsePrefix(entry)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+}
+
+// Link return the absolute url of the path, r is the request which the link
+// is built for or nil for links built by background jobs
+func (ub *URLBuilder) Link(r *http.Request, path string) string {
+	return ub.base(r) + path
+}
+
+// base return the public base url for the request
+func (ub *URLBuilder) base(r *http.Request) string {
+	if ub.Base != "" {
+		return ub.Base
+	}
+	if r == nil || !ub.trusted(r) {
+		return ub.Fallback
+	}
+	host := firstForwarded(r.Header.Get(headerForwardedHost))
+	if !validHost(host) {
+		return ub.Fallback
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := firstForwarded(r.Header.Get(headerForwardedProto)); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	return scheme + "://" + host
+}
+
+// trusted report if the request is sent by one of the trusted proxies
+func (ub *URLBuilder) trusted(r *http.Request) bool {
+	if len(ub.TrustedProxies) == 0 {
+		return false
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range ub.TrustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// firstForwarded return the value added by the proxy closest to the client
+func firstForwarded(value string) string {
+	first, _, _ := strings.Cut(value, ",")
+	return strings.ToLower(strings.TrimSpace(first))
+}
+
+// validHost reject forwarded hosts which would change more than the host of the link
+func validHost(host string) bool {
+	if host == "" || strings.ContainsAny(host, "/?#@\\ ") {
+		return false
+	}
+	u, err := url.Parse("http://" + host)
+	return err == nil && u.Host == host
+}
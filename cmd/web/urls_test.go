@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/dubass83/go-concurrency-project/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewURLBuilder(t *testing.T) {
+	builderTests := []struct {
+		name         string
+		conf         utils.Config
+		expectedBase string
+		expectedErr  string
+	}{
+		{
+			name: "fallback",
+			conf: utils.Config{WebPort: "8080"},
+		},
+		{
+			name:         "publicBaseURL",
+			conf:         utils.Config{PublicBaseURL: "https://example.com/"},
+			expectedBase: "https://example.com",
+		},
+		{
+			name:         "pathPrefix",
+			conf:         utils.Config{PublicBaseURL: "https://example.com/app/"},
+			expectedBase: "https://example.com/app",
+		},
+		{
+			name:        "noScheme",
+			conf:        utils.Config{PublicBaseURL: "example.com"},
+			expectedErr: "invalid PUBLIC_BASE_URL example.com",
+		},
+		{
+			name:        "query",
+			conf:        utils.Config{PublicBaseURL: "https://example.com/?a=b"},
+			expectedErr: "query, fragment and user info are not allowed",
+		},
+		{
+			name:        "invalidProxy",
+			conf:        utils.Config{TrustedProxies: "10.0.0.1,proxy.local"},
+			expectedErr: "invalid trusted proxy proxy.local",
+		},
+	}
+
+	for _, bt := range builderTests {
+		ub, err := newURLBuilder(bt.conf)
+		if bt.expectedErr != "" {
+			require.ErrorContains(t, err, bt.expectedErr, fmt.Sprintf("test name: %s", bt.name))
+			continue
+		}
+		require.NoError(t, err, fmt.Sprintf("test name: %s", bt.name))
+		require.Equal(t, bt.expectedBase, ub.Base, fmt.Sprintf("test name: %s", bt.name))
+	}
+}
+
+func TestURLBuilderLink(t *testing.T) {
+	newRequest := func(remoteAddr string, headers map[string]string) *http.Request {
+		req, _ := http.NewRequest("GET", "http://app.internal:8080/unsubscribe?email=user%40example.com&hash=abc", nil)
+		req.RemoteAddr = remoteAddr
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		return req
+	}
+	forwarded := map[string]string{
+		headerForwardedProto: "https",
+		headerForwardedHost:  "example.com, proxy.internal",
+	}
+
+	linkTests := []struct {
+		name         string
+		conf         utils.Config
+		req          *http.Request
+		expectedLink string
+	}{
+		{
+			name:         "noRequest",
+			conf:         utils.Config{WebPort: "8080"},
+			expectedLink: "http://localhost:8080/activate?token=abc",
+		},
+		{
+			name:         "requestHost",
+			conf:         utils.Config{WebPort: "8080"},
+			req:          newRequest("192.0.2.10:51000", nil),
+			expectedLink: "http://localhost:8080/activate?token=abc",
+		},
+		{
+			name:         "untrustedProxy",
+			conf:         utils.Config{WebPort: "8080", TrustedProxies: "10.0.0.0/8"},
+			req:          newRequest("192.0.2.10:51000", forwarded),
+			expectedLink: "http://localhost:8080/activate?token=abc",
+		},
+		{
+			name:         "trustedProxy",
+			conf:         utils.Config{WebPort: "8080", TrustedProxies: "10.0.0.0/8"},
+			req:          newRequest("10.1.2.3:51000", forwarded),
+			expectedLink: "https://example.com/activate?token=abc",
+		},
+		{
+			name:         "trustedProxyAddress",
+			conf:         utils.Config{WebPort: "8080", TrustedProxies: "::1"},
+			req:          newRequest("[::1]:51000", forwarded),
+			expectedLink: "https://example.com/activate?token=abc",
+		},
+		{
+			name: "invalidForwardedHost",
+			conf: utils.Config{WebPort: "8080", TrustedProxies: "10.0.0.0/8"},
+			req: newRequest("10.1.2.3:51000", map[string]string{
+				headerForwardedProto: "gopher",
+				headerForwardedHost:  "evil.example/phish?",
+			}),
+			expectedLink: "http://localhost:8080/activate?token=abc",
+		},
+		{
+			name:         "noForwardedHost",
+			conf:         utils.Config{WebPort: "8080", TrustedProxies: "10.0.0.0/8"},
+			req:          newRequest("10.1.2.3:51000", map[string]string{headerForwardedProto: "https"}),
+			expectedLink: "http://localhost:8080/activate?token=abc",
+		},
+		{
+			name:         "publicBaseURL",
+			conf:         utils.Config{PublicBaseURL: "https://example.com/app", TrustedProxies: "10.0.0.0/8"},
+			req:          newRequest("10.1.2.3:51000", map[string]string{headerForwardedHost: "evil.example"}),
+			expectedLink: "https://example.com/app/activate?token=abc",
+		},
+	}
+
+	for _, lt := range linkTests {
+		ub, err := newURLBuilder(lt.conf)
+		require.NoError(t, err, fmt.Sprintf("test name: %s", lt.name))
+		require.Equal(t, lt.expectedLink, ub.Link(lt.req, "/activate?token=abc"), fmt.Sprintf("test name: %s", lt.name))
+	}
+
+	ub, err := newURLBuilder(utils.Config{WebPort: "8080", TrustedProxies: "10.0.0.0/8"})
+	require.NoError(t, err)
+	req := newRequest("10.1.2.3:51000", map[string]string{headerForwardedHost: "example.com"})
+	req.TLS = &tls.ConnectionState{}
+	require.Equal(t, "https://example.com/activate?token=abc", ub.Link(req, "/activate?token=abc"), "the scheme of the connection is kept without the forwarded one")
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	data "github.com/dubass83/go-concurrency-project/data/sqlc"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/rs/zerolog/log"
+)
+
+const headerMailSignature = "X-Mail-Signature"
+
+// maxMailWebhookSize limit the body of the webhook request
+const maxMailWebhookSize = 1 << 20
+
+// types of events reported by the mail provider
+const (
+	mailWebhookBounce    = "bounce"
+	mailWebhookComplaint = "complaint"
+)
+
+// types of bounces, soft bounces are temporary and the address is kept
+const (
+	bounceHard = "hard"
+	bounceSoft = "soft"
+)
+
+// MailWebhookEvent is a bounce or complaint reported by the mail provider
+type MailWebhookEvent struct {
+	Type       string `json:"type"`
+	BounceType string `json:"bounce_type,omitempty"`
+	Email      string `json:"email"`
+	MessageID  string `json:"message_id,omitempty"`
+	Detail     string `json:"detail,omitempty"`
+}
+
+type mailWebhookPayload struct {
+	Events []MailWebhookEvent `json:"events"`
+}
+
+// signMailWebhook return the value of the X-Mail-Signature header,
+// it is the hex HMAC-SHA256 of the body with MAIL_WEBHOOK_SECRET
+func signMailWebhook(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// MailWebhook accept bounces and complaints posted by the mail provider. Hard bounces and
+// complaints suppress the address, so the mail pipeline never send to it again
+func (app *Server) MailWebhook(w http.ResponseWriter, r *http.Request) {
+	secret := app.Config.MailWebhookSecret
+	if secret == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxMailWebhookSize))
+	if err != nil {
+		http.Error(w, "unable to read the request", http.StatusBadRequest)
+		return
+	}
+	expected := signMailWebhook(secret, payload)
+	if !hmac.Equal([]byte(expected), []byte(r.Header.Get(headerMailSignature))) {
+		log.Warn().Str("remote_addr", r.RemoteAddr).Msg("mail webhook with invalid signature")
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	var body mailWebhookPayload
+	if err := json.Unmarshal(payload, &body); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	for _, event := range body.Events {
+		if err := app.handleMailWebhookEvent(r.Context(), event); err != nil {
+			// the provider retry the whole request, upserts are safe to repeat
+			log.Error().Err(err).Str("email", event.Email).Msg("failed to handle mail webhook event")
+			http.Error(w, "unable to handle the event", http.StatusInternalServerError)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleMailWebhookEvent suppress the address when it must not receive emails anymore,
+// bounces without the type are treated as hard ones
+func (app *Server) handleMailWebhookEvent(ctx context.Context, event MailWebhookEvent) error {
+	email := normalizeAddress(event.Email)
+	if email == "" {
+		log.Warn().Str("type", event.Type).Msg("mail webhook event without email is ignored")
+		return nil
+	}
+
+	var status, reason string
+	switch event.Type {
+	case mailWebhookBounce:
+		status = mailEventBounced
+		if event.BounceType != bounceSoft {
+			reason = suppressionBounce
+		}
+	case mailWebhookComplaint:
+		status = mailEventComplained
+		reason = suppressionComplaint
+	default:
+		log.Warn().Str("type", event.Type).Str("email", email).Msg("unknown mail webhook event is ignored")
+		return nil
+	}
+	mailMetrics.Add(status, 1)
+
+	if reason != "" {
+		_, err := app.Store.UpsertMailSuppression(ctx, data.UpsertMailSuppressionParams{
+			Email:  email,
+			Reason: reason,
+			Detail: pgtype.Text{
+				String: event.Detail,
+				Valid:  event.Detail != "",
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to suppress address: %s", err)
+		}
+		log.Info().Str("email", email).Str("reason", reason).Msg("address is suppressed")
+	}
+
+	event.Email = email
+	app.recordWebhookEvent(ctx, event, status)
+	return nil
+}
+
+// recordWebhookEvent add the event to the history of the message reported by the provider
+func (app *Server) recordWebhookEvent(ctx context.Context, event MailWebhookEvent, status string) {
+	messageID := outboxMessageID(event.MessageID)
+	if messageID == "" {
+		return
+	}
+	row, err := app.Store.GetMailOutboxByMessageID(ctx, messageID)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			log.Error().Err(err).Str("message_id", messageID).Msg("failed to get outbox message of the webhook event")
+		}
+		return
+	}
+
+	detail := event.Email
+	if event.BounceType != "" {
+		detail = fmt.Sprintf("%s bounce of %s", event.BounceType, detail)
+	}
+	if event.Detail != "" {
+		detail = fmt.Sprintf("%s: %s", detail, event.Detail)
+	}
+	app.recordMailEvent(row.ID, status, detail)
+}
+
+// outboxMessageID return the id of the outbox message from the Message-ID header
+func outboxMessageID(header string) string {
+	id := strings.Trim(strings.TrimSpace(header), "<>")
+	if i := strings.LastIndex(id, "@"); i >= 0 {
+		id = id[:i]
+	}
+	return id
+}
+
+// sampleMailWebhook return the payload the mail provider post for the message,
+// it stands in for the provider in tests and on the dev mailbox page
+func sampleMailWebhook(eventType, messageID string, emails ...string) []byte {
+	body := mailWebhookPayload{}
+	for _, email := range emails {
+		event := MailWebhookEvent{
+			Type:      eventType,
+			Email:     email,
+			MessageID: messageID,
+		}
+		switch eventType {
+		case mailWebhookBounce:
+			event.BounceType = bounceHard
+			event.Detail = "550 5.1.1 The email account that you tried to reach does not exist"
+		case mailWebhookComplaint:
+			event.Detail = "abuse"
+		}
+		body.Events = append(body.Events, event)
+	}
+	payload, _ := json.Marshal(body)
+	return payload
+}
+
+// postMailWebhook sign the payload and post it to the webhook like the mail provider
+func postMailWebhook(url, secret string, payload []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(headerMailSignature, signMailWebhook(secret, payload))
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("mail webhook returned %s", resp.Status)
+	}
+	return nil
+}
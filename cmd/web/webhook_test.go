@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	mockdb "github.com/dubass83/go-concurrency-project/data/mock"
+	data "github.com/dubass83/go-concurrency-project/data/sqlc"
+	"github.com/golang/mock/gomock"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMailWebhook(t *testing.T) {
+	secret := testApp.Config.MailWebhookSecret
+	require.NotEmpty(t, secret, "MAIL_WEBHOOK_SECRET is set in test_conf")
+
+	bounce := sampleMailWebhook(mailWebhookBounce, "<abc123@example.com>", "User@Example.com")
+	complaint := sampleMailWebhook(mailWebhookComplaint, "", "user@example.com")
+	softBounce := []byte(`{"events":[{"type":"bounce","bounce_type":"soft","email":"user@example.com","message_id":"abc123@example.com"}]}`)
+
+	webhookTests := []struct {
+		name               string
+		payload            []byte
+		signature          string
+		secret             string
+		buildStubs         func(store *mockdb.MockStore)
+		expectedStatusCode int
+	}{
+		{
+			name:      "hardBounce",
+			payload:   bounce,
+			signature: signMailWebhook(secret, bounce),
+			secret:    secret,
+			buildStubs: func(store *mockdb.MockStore) {
+				arg := data.UpsertMailSuppressionParams{
+					Email:  "user@example.com",
+					Reason: suppressionBounce,
+					Detail: pgtype.Text{
+						String: "550 5.1.1 The email account that you tried to reach does not exist",
+						Valid:  true,
+					},
+				}
+				store.EXPECT().
+					UpsertMailSuppression(gomock.Any(), gomock.Eq(arg)).
+					Times(1).
+					Return(data.MailSuppression{Email: arg.Email, Reason: arg.Reason}, nil)
+				store.EXPECT().
+					GetMailOutboxByMessageID(gomock.Any(), gomock.Eq("abc123")).
+					Times(1).
+					Return(data.MailOutbox{ID: 7, MessageID: "abc123"}, nil)
+				store.EXPECT().
+					InsertMailEvent(gomock.Any(), gomock.Any()).
+					Times(1).
+					DoAndReturn(func(_ context.Context, arg data.InsertMailEventParams) error {
+						require.Equal(t, int64(7), arg.OutboxID)
+						require.Equal(t, mailEventBounced, arg.Status)
+						require.Contains(t, arg.Detail.String, "hard bounce of user@example.com")
+						return nil
+					})
+			},
+			expectedStatusCode: http.StatusNoContent,
+		},
+		{
+			name:      "softBounce",
+			payload:   softBounce,
+			signature: signMailWebhook(secret, softBounce),
+			secret:    secret,
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					UpsertMailSuppression(gomock.Any(), gomock.Any()).
+					Times(0)
+				store.EXPECT().
+					GetMailOutboxByMessageID(gomock.Any(), gomock.Eq("abc123")).
+					Times(1).
+					Return(data.MailOutbox{}, pgx.ErrNoRows)
+			},
+			expectedStatusCode: http.StatusNoContent,
+		},
+		{
+			name:      "complaint",
+			payload:   complaint,
+			signature: signMailWebhook(secret, complaint),
+			secret:    secret,
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					UpsertMailSuppression(gomock.Any(), gomock.Any()).
+					Times(1).
+					DoAndReturn(func(_ context.Context, arg data.UpsertMailSuppressionParams) (data.MailSuppression, error) {
+						require.Equal(t, suppressionComplaint, arg.Reason)
+						return data.MailSuppression{Email: arg.Email, Reason: arg.Reason}, nil
+					})
+				store.EXPECT().
+					GetMailOutboxByMessageID(gomock.Any(), gomock.Any()).
+					Times(0)
+			},
+			expectedStatusCode: http.StatusNoContent,
+		},
+		{
+			name:      "invalidSignature",
+			payload:   bounce,
+			signature: signMailWebhook("wrong secret", bounce),
+			secret:    secret,
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					UpsertMailSuppression(gomock.Any(), gomock.Any()).
+					Times(0)
+			},
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:      "invalidPayload",
+			payload:   []byte("not json"),
+			signature: signMailWebhook(secret, []byte("not json")),
+			secret:    secret,
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					UpsertMailSuppression(gomock.Any(), gomock.Any()).
+					Times(0)
+			},
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:      "storeError",
+			payload:   complaint,
+			signature: signMailWebhook(secret, complaint),
+			secret:    secret,
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					UpsertMailSuppression(gomock.Any(), gomock.Any()).
+					Times(1).
+					Return(data.MailSuppression{}, errors.New("connection refused"))
+			},
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+		{
+			name:      "disabled",
+			payload:   bounce,
+			signature: signMailWebhook("", bounce),
+			secret:    "",
+			buildStubs: func(store *mockdb.MockStore) {
+				store.EXPECT().
+					UpsertMailSuppression(gomock.Any(), gomock.Any()).
+					Times(0)
+			},
+			expectedStatusCode: http.StatusNotFound,
+		},
+	}
+
+	defer func() {
+		testApp.Config.MailWebhookSecret = secret
+	}()
+
+	for _, wt := range webhookTests {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := mockdb.NewMockStore(ctrl)
+		wt.buildStubs(store)
+
+		testApp.Store = store
+		testApp.Config.MailWebhookSecret = wt.secret
+
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/webhooks/mail", strings.NewReader(string(wt.payload)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(headerMailSignature, wt.signature)
+
+		testApp.Router.ServeHTTP(rr, req)
+
+		require.Equal(t, wt.expectedStatusCode, rr.Code, fmt.Sprintf("test name: %s", wt.name))
+	}
+}
+
+func TestFilterSuppressed(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	store := mockdb.NewMockStore(ctrl)
+	store.EXPECT().
+		GetSuppressedEmails(gomock.Any(), gomock.Eq([]string{"user@example.com", "admin@example.com", "bcc@example.com"})).
+		Times(1).
+		Return([]string{"user@example.com", "bcc@example.com"}, nil)
+	store.EXPECT().
+		GetSuppressedEmails(gomock.Any(), gomock.Any()).
+		Times(1).
+		Return([]string{"user@example.com"}, nil)
+
+	testApp.Store = store
+
+	msg, ok, err := testApp.filterSuppressed(context.Background(), Message{
+		To:  []string{"User <User@Example.com>", "admin@example.com"},
+		BCC: []string{"bcc@example.com"},
+	})
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, []string{"admin@example.com"}, msg.To)
+	require.Empty(t, msg.BCC)
+
+	_, ok, err = testApp.filterSuppressed(context.Background(), Message{To: []string{"user@example.com"}})
+	require.NoError(t, err)
+	require.False(t, ok, "nobody is left")
+}
+
+// TestSimulateMailEvent post the sample bounce of the captured message to the running app,
+// like the mail provider does
+func TestSimulateMailEvent(t *testing.T) {
+	server := httptest.NewServer(testApp.Router)
+	defer server.Close()
+
+	port := testApp.Config.WebPort
+	defer func() {
+		testApp.Config.WebPort = port
+	}()
+	testApp.Config.WebPort = server.URL[strings.LastIndex(server.URL, ":")+1:]
+
+	mailbox := testApp.Mail.Mailbox
+	mailbox.Clear()
+	defer mailbox.Clear()
+	err := mailbox.SendEmail(Message{
+		To:        []string{"bounce@example.com"},
+		Subject:   "Yuor invoice",
+		MessageID: "abc123",
+	})
+	require.NoError(t, err)
+	captured := mailbox.Messages()[0]
+	require.NotEmpty(t, captured.MessageID)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	store := mockdb.NewMockStore(ctrl)
+	store.EXPECT().
+		UpsertMailSuppression(gomock.Any(), gomock.Any()).
+		Times(1).
+		DoAndReturn(func(_ context.Context, arg data.UpsertMailSuppressionParams) (data.MailSuppression, error) {
+			require.Equal(t, "bounce@example.com", arg.Email)
+			require.Equal(t, suppressionBounce, arg.Reason)
+			return data.MailSuppression{Email: arg.Email, Reason: arg.Reason}, nil
+		})
+	store.EXPECT().
+		GetMailOutboxByMessageID(gomock.Any(), gomock.Eq("abc123")).
+		Times(1).
+		Return(data.MailOutbox{}, pgx.ErrNoRows)
+
+	testApp.Store = store
+
+	form := url.Values{
+		"id":   {fmt.Sprint(captured.ID)},
+		"type": {mailWebhookBounce},
+	}
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/dev/mailbox/event", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+
+	testApp.SimulateMailEvent(rr, req)
+
+	require.Equal(t, http.StatusSeeOther, rr.Code)
+	require.False(t, testApp.Session.Exists(ctx, "error"))
+	require.True(t, testApp.Session.Exists(ctx, "flash"))
+
+	// the webhook is disabled without the secret, so nothing is posted
+	secret := testApp.Config.MailWebhookSecret
+	defer func() {
+		testApp.Config.MailWebhookSecret = secret
+	}()
+	testApp.Config.MailWebhookSecret = ""
+
+	rr = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/dev/mailbox/event", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+
+	testApp.SimulateMailEvent(rr, req)
+
+	require.Equal(t, http.StatusSeeOther, rr.Code)
+	require.True(t, testApp.Session.Exists(ctx, "error"))
+}
+
+func TestOutboxMessageID(t *testing.T) {
+	require.Equal(t, "abc123", outboxMessageID("<abc123@example.com>"))
+	require.Equal(t, "abc123", outboxMessageID("abc123"))
+	require.Empty(t, outboxMessageID(""))
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"sync"
+	"time"
+
+	"github.com/dubass83/go-concurrency-project/utils"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultMailWorkers        = 4
+	defaultMailBulkWorkers    = 2
+	defaultMailQueueSize      = 100
+	defaultMailEnqueueTimeout = time.Second
+)
+
+// errMailQueueFull is returned when mail workers do not take the message in time
+var errMailQueueFull = errors.New("mail queue is full")
+
+// mailMetrics are published with the other expvar variables on /admin/debug/vars
+var mailMetrics = expvar.NewMap("mail")
+
+// ListenForMail start a fixed number of mail workers for every priority lane and
+// wait until the app is shutting down
+func (app *Server) ListenForMail() {
+	workers := map[string]int{
+		mailPriorityTransactional: app.Config.MailWorkers,
+		mailPriorityBulk:          app.Config.MailBulkWorkers,
+	}
+	if workers[mailPriorityTransactional] <= 0 {
+		workers[mailPriorityTransactional] = defaultMailWorkers
+	}
+	if workers[mailPriorityBulk] <= 0 {
+		workers[mailPriorityBulk] = defaultMailBulkWorkers
+	}
+
+	mailMetrics.Set("queue_depth", expvar.Func(func() any {
+		return app.laneStats(func(lane chan Message) int { return len(lane) })
+	}))
+	mailMetrics.Set("queue_capacity", expvar.Func(func() any {
+		return app.laneStats(func(lane chan Message) int { return cap(lane) })
+	}))
+	workersVar := new(expvar.Map)
+	for priority, n := range workers {
+		count := new(expvar.Int)
+		count.Set(int64(n))
+		workersVar.Set(priority, count)
+	}
+	mailMetrics.Set("workers", workersVar)
+
+	stop := make(chan struct{})
+	finished := make(chan struct{})
+	wg := sync.WaitGroup{}
+	id := 0
+	for _, priority := range mailPriorities {
+		for range workers[priority] {
+			wg.Add(1)
+			go app.mailWorker(id, priority, stop, &wg)
+			id++
+		}
+	}
+
+	done := app.Mail.DoneChan
+	for {
+		select {
+		case err := <-app.Mail.ErrChan:
+			log.Error().Err(err).Msg("failed to send email")
+		case <-done:
+			// keep reading errors until workers finish in-flight messages
+			done = nil
+			close(stop)
+			go func() {
+				wg.Wait()
+				close(finished)
+			}()
+		case <-finished:
+			log.Info().Msg("all mail workers are stopped")
+			return
+		}
+	}
+}
+
+// laneStats return the value of every priority lane for the metrics
+func (app *Server) laneStats(stat func(chan Message) int) map[string]int {
+	stats := make(map[string]int, len(mailPriorities))
+	for _, priority := range mailPriorities {
+		stats[priority] = stat(app.laneChan(priority))
+	}
+	return stats
+}
+
+// mailWorker send messages of its lane one by one using its own connection to the mail server
+func (app *Server) mailWorker(id int, priority string, stop <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	worker := app.Mail.Sender.NewWorker()
+	defer func() {
+		if err := worker.Close(); err != nil {
+			log.Error().Err(err).Int("worker", id).Str("priority", priority).Msg("failed to close mail worker")
+		}
+	}()
+
+	for {
+		// messages stay in the queue while the mail server is down
+		if !app.Mail.Breaker.wait(stop) {
+			return
+		}
+		msg, ok := app.nextMail(priority, stop)
+		if !ok {
+			return
+		}
+		mailMetrics.Add("in_flight", 1)
+		app.deliverMail(worker, msg)
+		mailMetrics.Add("in_flight", -1)
+	}
+}
+
+// nextMail wait for the next message of the lane, bulk workers take waiting
+// transactional messages first, transactional workers never take bulk ones
+func (app *Server) nextMail(priority string, stop <-chan struct{}) (Message, bool) {
+	if priority == mailPriorityTransactional {
+		select {
+		case msg := <-app.Mail.MailerChan:
+			return msg, true
+		case <-stop:
+			return Message{}, false
+		}
+	}
+
+	select {
+	case msg := <-app.Mail.MailerChan:
+		return msg, true
+	default:
+	}
+	select {
+	case msg := <-app.Mail.MailerChan:
+		return msg, true
+	case msg := <-app.Mail.BulkChan:
+		return msg, true
+	case <-stop:
+		return Message{}, false
+	}
+}
+
+// mailQueueSize return the number of messages of every lane waiting for a free mail worker
+func mailQueueSize(conf utils.Config) int {
+	if conf.MailQueueSize <= 0 {
+		return defaultMailQueueSize
+	}
+	return conf.MailQueueSize
+}
+
+// dispatchMail hand the message to mail workers of its lane, when the lane is full it wait
+// for MAIL_ENQUEUE_TIMEOUT and return errMailQueueFull instead of blocking the caller
+func (app *Server) dispatchMail(ctx context.Context, msg Message) error {
+	priority := messagePriority(msg)
+	lane := app.laneChan(priority)
+	select {
+	case lane <- msg:
+		return nil
+	default:
+	}
+
+	mailMetrics.Add("queue_saturated", 1)
+	timeout := app.Config.MailEnqueueTimeout
+	if timeout <= 0 {
+		timeout = defaultMailEnqueueTimeout
+	}
+	log.Warn().
+		Str("priority", priority).
+		Int("queue_depth", len(lane)).
+		Int("queue_capacity", cap(lane)).
+		Dur("timeout", timeout).
+		Msg("mail queue is full, waiting for a free mail worker")
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case lane <- msg:
+		return nil
+	case <-timer.C:
+		mailMetrics.Add("queue_full", 1)
+		return errMailQueueFull
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dubass83/go-concurrency-project/utils"
+	"github.com/stretchr/testify/require"
+)
+
+// countingSender record how many messages are sent at the same time
+type countingSender struct {
+	inFlight atomic.Int32
+	maxSeen  atomic.Int32
+	workers  atomic.Int32
+	closed   atomic.Int32
+	sent     sync.WaitGroup
+}
+
+func (s *countingSender) SendEmail(email Message) error {
+	n := s.inFlight.Add(1)
+	for {
+		max := s.maxSeen.Load()
+		if n <= max || s.maxSeen.CompareAndSwap(max, n) {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	s.inFlight.Add(-1)
+	s.sent.Done()
+	return nil
+}
+
+func (s *countingSender) NewWorker() EmailWorker {
+	s.workers.Add(1)
+	return s
+}
+
+func (s *countingSender) Close() error {
+	s.closed.Add(1)
+	return nil
+}
+
+func TestListenForMail(t *testing.T) {
+	sender := &countingSender{}
+	app := Server{
+		Config: utils.Config{
+			MailWorkers:     3,
+			MailBulkWorkers: 2,
+		},
+		Mail: Mail{
+			MailerChan: make(chan Message, 100),
+			BulkChan:   make(chan Message, 100),
+			ErrChan:    make(chan error),
+			DoneChan:   make(chan bool),
+			Sender:     sender,
+		},
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		app.ListenForMail()
+		close(stopped)
+	}()
+
+	sender.sent.Add(20)
+	for i := range 20 {
+		if i%2 == 0 {
+			app.Mail.MailerChan <- Message{Subject: "test"}
+		} else {
+			app.Mail.BulkChan <- Message{Subject: "test"}
+		}
+	}
+	sender.sent.Wait()
+
+	app.Mail.DoneChan <- true
+	<-stopped
+
+	require.Equal(t, int32(5), sender.workers.Load())
+	require.Equal(t, int32(5), sender.closed.Load(), "every worker must close its connection")
+	require.LessOrEqual(t, sender.maxSeen.Load(), int32(5))
+	require.Greater(t, sender.maxSeen.Load(), int32(1), "messages should be sent concurrently")
+}
+
+func TestDispatchMail(t *testing.T) {
+	app := Server{
+		Config: utils.Config{
+			MailEnqueueTimeout: 20 * time.Millisecond,
+		},
+		Mail: Mail{
+			MailerChan: make(chan Message, 1),
+		},
+	}
+
+	err := app.dispatchMail(context.Background(), Message{Subject: "first", Priority: mailPriorityTransactional})
+	require.NoError(t, err)
+
+	start := time.Now()
+	err = app.dispatchMail(context.Background(), Message{Subject: "second", Priority: mailPriorityTransactional})
+	require.ErrorIs(t, err, errMailQueueFull)
+	require.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond, "dispatch must wait for the timeout")
+
+	// a worker take the message while dispatch is waiting
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		<-app.Mail.MailerChan
+	}()
+	app.Config.MailEnqueueTimeout = time.Second
+	err = app.dispatchMail(context.Background(), Message{Subject: "third", Priority: mailPriorityTransactional})
+	require.NoError(t, err)
+	require.Equal(t, "third", (<-app.Mail.MailerChan).Subject)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	app.Mail.MailerChan <- Message{Subject: "fourth", Priority: mailPriorityTransactional}
+	err = app.dispatchMail(ctx, Message{Subject: "fifth", Priority: mailPriorityTransactional})
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestNextMail(t *testing.T) {
+	app := Server{
+		Mail: Mail{
+			MailerChan: make(chan Message, 2),
+			BulkChan:   make(chan Message, 2),
+		},
+	}
+	stop := make(chan struct{})
+
+	app.Mail.BulkChan <- Message{Subject: "manual"}
+	app.Mail.MailerChan <- Message{Subject: "activation"}
+
+	msg, ok := app.nextMail(mailPriorityBulk, stop)
+	require.True(t, ok)
+	require.Equal(t, "activation", msg.Subject, "bulk workers must take transactional messages first")
+
+	msg, ok = app.nextMail(mailPriorityBulk, stop)
+	require.True(t, ok)
+	require.Equal(t, "manual", msg.Subject)
+
+	app.Mail.BulkChan <- Message{Subject: "invoice"}
+	close(stop)
+	_, ok = app.nextMail(mailPriorityTransactional, stop)
+	require.False(t, ok, "transactional workers must not take bulk messages")
+	require.Len(t, app.Mail.BulkChan, 1)
+}
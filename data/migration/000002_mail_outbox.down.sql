@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS public.mail_outbox_status_available_at_idx;
+
+ALTER TABLE public.mail_outbox
+DROP CONSTRAINT IF EXISTS mail_outbox_pkey;
+
+DROP TABLE IF EXISTS public.mail_outbox;
+
+DROP SEQUENCE IF EXISTS public.mail_outbox_id_seq;
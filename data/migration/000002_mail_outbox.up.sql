@@ -0,0 +1,36 @@
+--
+-- Name: mail_outbox; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.mail_outbox (
+    id bigint NOT NULL,
+    payload jsonb NOT NULL,
+    status character varying(16) DEFAULT 'pending' NOT NULL,
+    attempts integer DEFAULT 0 NOT NULL,
+    last_error text,
+    available_at timestamp without time zone DEFAULT (now()) NOT NULL,
+    locked_until timestamp without time zone,
+    created_at timestamp without time zone DEFAULT (now()),
+    updated_at timestamp without time zone DEFAULT '0001-01-01'
+);
+
+
+--
+-- Name: mail_outbox_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+ALTER TABLE public.mail_outbox ALTER COLUMN id ADD GENERATED ALWAYS AS IDENTITY (
+    SEQUENCE NAME public.mail_outbox_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1
+);
+
+
+ALTER TABLE ONLY public.mail_outbox
+    ADD CONSTRAINT mail_outbox_pkey PRIMARY KEY (id);
+
+
+CREATE INDEX mail_outbox_status_available_at_idx ON public.mail_outbox USING btree (status, available_at);
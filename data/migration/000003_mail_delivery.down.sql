@@ -0,0 +1,16 @@
+DROP INDEX IF EXISTS public.mail_events_outbox_id_idx;
+
+ALTER TABLE public.mail_events
+DROP CONSTRAINT IF EXISTS mail_events_outbox_id_fkey;
+
+ALTER TABLE public.mail_events
+DROP CONSTRAINT IF EXISTS mail_events_pkey;
+
+DROP TABLE IF EXISTS public.mail_events;
+
+DROP SEQUENCE IF EXISTS public.mail_events_id_seq;
+
+DROP INDEX IF EXISTS public.mail_outbox_message_id_idx;
+
+ALTER TABLE public.mail_outbox
+DROP COLUMN IF EXISTS message_id;
@@ -0,0 +1,46 @@
+--
+-- Name: mail_outbox message_id; every message get its own id used in the Message-ID header
+--
+
+ALTER TABLE public.mail_outbox
+    ADD COLUMN message_id character varying(64) DEFAULT (gen_random_uuid())::text NOT NULL;
+
+CREATE UNIQUE INDEX mail_outbox_message_id_idx ON public.mail_outbox USING btree (message_id);
+
+
+--
+-- Name: mail_events; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.mail_events (
+    id bigint NOT NULL,
+    outbox_id bigint NOT NULL,
+    status character varying(16) NOT NULL,
+    detail text,
+    created_at timestamp without time zone DEFAULT (now())
+);
+
+
+--
+-- Name: mail_events_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+ALTER TABLE public.mail_events ALTER COLUMN id ADD GENERATED ALWAYS AS IDENTITY (
+    SEQUENCE NAME public.mail_events_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1
+);
+
+
+ALTER TABLE ONLY public.mail_events
+    ADD CONSTRAINT mail_events_pkey PRIMARY KEY (id);
+
+
+ALTER TABLE ONLY public.mail_events
+    ADD CONSTRAINT mail_events_outbox_id_fkey FOREIGN KEY (outbox_id) REFERENCES public.mail_outbox(id) ON UPDATE RESTRICT ON DELETE CASCADE;
+
+
+CREATE INDEX mail_events_outbox_id_idx ON public.mail_events USING btree (outbox_id);
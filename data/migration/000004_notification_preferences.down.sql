@@ -0,0 +1,7 @@
+ALTER TABLE public.notification_preferences
+DROP CONSTRAINT IF EXISTS notification_preferences_user_id_fkey;
+
+ALTER TABLE public.notification_preferences
+DROP CONSTRAINT IF EXISTS notification_preferences_pkey;
+
+DROP TABLE IF EXISTS public.notification_preferences;
@@ -0,0 +1,6 @@
+--
+-- Name: users locale; emails are sent in the language of the user
+--
+
+ALTER TABLE public.users
+    ADD COLUMN locale character varying(16) DEFAULT 'en'::character varying NOT NULL;
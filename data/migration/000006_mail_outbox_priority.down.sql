@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS public.mail_outbox_priority_id_idx;
+
+ALTER TABLE public.mail_outbox
+DROP COLUMN IF EXISTS priority;
@@ -0,0 +1,11 @@
+--
+-- Name: mail_outbox priority; transactional messages (0) are claimed before bulk ones (1),
+-- the lane is chosen by the app and stored in the payload
+--
+
+ALTER TABLE public.mail_outbox
+    ADD COLUMN priority smallint GENERATED ALWAYS AS (
+        CASE WHEN (payload ->> 'Priority') = 'transactional' THEN 0 ELSE 1 END
+    ) STORED NOT NULL;
+
+CREATE INDEX mail_outbox_priority_id_idx ON public.mail_outbox USING btree (priority, id);
@@ -0,0 +1,4 @@
+ALTER TABLE public.mail_suppressions
+DROP CONSTRAINT IF EXISTS mail_suppressions_pkey;
+
+DROP TABLE IF EXISTS public.mail_suppressions;
@@ -0,0 +1,16 @@
+--
+-- Name: mail_suppressions; Type: TABLE; Schema: public; Owner: -
+-- addresses which hard-bounced or complained, emails are never sent to them
+--
+
+CREATE TABLE public.mail_suppressions (
+    email character varying(255) NOT NULL,
+    reason character varying(16) NOT NULL,
+    detail text,
+    created_at timestamp without time zone DEFAULT (now()),
+    updated_at timestamp without time zone DEFAULT (now())
+);
+
+
+ALTER TABLE ONLY public.mail_suppressions
+    ADD CONSTRAINT mail_suppressions_pkey PRIMARY KEY (email);
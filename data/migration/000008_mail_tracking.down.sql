@@ -0,0 +1,11 @@
+DROP INDEX IF EXISTS public.mail_tracking_events_message_id_idx;
+
+ALTER TABLE public.mail_tracking_events
+DROP CONSTRAINT IF EXISTS mail_tracking_events_message_id_fkey;
+
+ALTER TABLE public.mail_tracking_events
+DROP CONSTRAINT IF EXISTS mail_tracking_events_pkey;
+
+DROP TABLE IF EXISTS public.mail_tracking_events;
+
+DROP SEQUENCE IF EXISTS public.mail_tracking_events_id_seq;
@@ -0,0 +1,37 @@
+--
+-- Name: mail_tracking_events; Type: TABLE; Schema: public; Owner: -
+-- opens and clicks of html emails, reported by the tracking pixel and links
+--
+
+CREATE TABLE public.mail_tracking_events (
+    id bigint NOT NULL,
+    message_id character varying(64) NOT NULL,
+    kind character varying(16) NOT NULL,
+    url text,
+    created_at timestamp without time zone DEFAULT (now())
+);
+
+
+--
+-- Name: mail_tracking_events_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+ALTER TABLE public.mail_tracking_events ALTER COLUMN id ADD GENERATED ALWAYS AS IDENTITY (
+    SEQUENCE NAME public.mail_tracking_events_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1
+);
+
+
+ALTER TABLE ONLY public.mail_tracking_events
+    ADD CONSTRAINT mail_tracking_events_pkey PRIMARY KEY (id);
+
+
+ALTER TABLE ONLY public.mail_tracking_events
+    ADD CONSTRAINT mail_tracking_events_message_id_fkey FOREIGN KEY (message_id) REFERENCES public.mail_outbox(message_id) ON UPDATE RESTRICT ON DELETE CASCADE;
+
+
+CREATE INDEX mail_tracking_events_message_id_idx ON public.mail_tracking_events USING btree (message_id);
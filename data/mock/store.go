@@ -36,6 +36,36 @@ func (m *MockStore) EXPECT() *MockStoreMockRecorder {
 	return m.recorder
 }
 
+// ClaimMailOutbox mocks base method.
+func (m *MockStore) ClaimMailOutbox(arg0 context.Context, arg1 data.ClaimMailOutboxParams) ([]data.MailOutbox, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ClaimMailOutbox", arg0, arg1)
+	ret0, _ := ret[0].([]data.MailOutbox)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ClaimMailOutbox indicates an expected call of ClaimMailOutbox.
+func (mr *MockStoreMockRecorder) ClaimMailOutbox(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClaimMailOutbox", reflect.TypeOf((*MockStore)(nil).ClaimMailOutbox), arg0, arg1)
+}
+
+// CountSegmentUsers mocks base method.
+func (m *MockStore) CountSegmentUsers(arg0 context.Context, arg1 data.CountSegmentUsersParams) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CountSegmentUsers", arg0, arg1)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CountSegmentUsers indicates an expected call of CountSegmentUsers.
+func (mr *MockStoreMockRecorder) CountSegmentUsers(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CountSegmentUsers", reflect.TypeOf((*MockStore)(nil).CountSegmentUsers), arg0, arg1)
+}
+
 // DeletePlan mocks base method.
 func (m *MockStore) DeletePlan(arg0 context.Context, arg1 int32) error {
 	m.ctrl.T.Helper()
@@ -92,6 +122,21 @@ func (mr *MockStoreMockRecorder) DeleteUserPlan(arg0, arg1 interface{}) *gomock.
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteUserPlan", reflect.TypeOf((*MockStore)(nil).DeleteUserPlan), arg0, arg1)
 }
 
+// EnqueueMailTx mocks base method.
+func (m *MockStore) EnqueueMailTx(arg0 context.Context, arg1 [][]byte) (data.EnqueueMailTxResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "EnqueueMailTx", arg0, arg1)
+	ret0, _ := ret[0].(data.EnqueueMailTxResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// EnqueueMailTx indicates an expected call of EnqueueMailTx.
+func (mr *MockStoreMockRecorder) EnqueueMailTx(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EnqueueMailTx", reflect.TypeOf((*MockStore)(nil).EnqueueMailTx), arg0, arg1)
+}
+
 // GetAllPlans mocks base method.
 func (m *MockStore) GetAllPlans(arg0 context.Context, arg1 data.GetAllPlansParams) ([]data.Plan, error) {
 	m.ctrl.T.Helper()
@@ -137,6 +182,96 @@ func (mr *MockStoreMockRecorder) GetAllUsers(arg0, arg1 interface{}) *gomock.Cal
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllUsers", reflect.TypeOf((*MockStore)(nil).GetAllUsers), arg0, arg1)
 }
 
+// GetDeadMailOutbox mocks base method.
+func (m *MockStore) GetDeadMailOutbox(arg0 context.Context, arg1 data.GetDeadMailOutboxParams) ([]data.MailOutbox, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetDeadMailOutbox", arg0, arg1)
+	ret0, _ := ret[0].([]data.MailOutbox)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetDeadMailOutbox indicates an expected call of GetDeadMailOutbox.
+func (mr *MockStoreMockRecorder) GetDeadMailOutbox(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDeadMailOutbox", reflect.TypeOf((*MockStore)(nil).GetDeadMailOutbox), arg0, arg1)
+}
+
+// GetMailEvents mocks base method.
+func (m *MockStore) GetMailEvents(arg0 context.Context, arg1 int64) ([]data.MailEvent, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetMailEvents", arg0, arg1)
+	ret0, _ := ret[0].([]data.MailEvent)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetMailEvents indicates an expected call of GetMailEvents.
+func (mr *MockStoreMockRecorder) GetMailEvents(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMailEvents", reflect.TypeOf((*MockStore)(nil).GetMailEvents), arg0, arg1)
+}
+
+// GetMailOutboxByMessageID mocks base method.
+func (m *MockStore) GetMailOutboxByMessageID(arg0 context.Context, arg1 string) (data.MailOutbox, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetMailOutboxByMessageID", arg0, arg1)
+	ret0, _ := ret[0].(data.MailOutbox)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetMailOutboxByMessageID indicates an expected call of GetMailOutboxByMessageID.
+func (mr *MockStoreMockRecorder) GetMailOutboxByMessageID(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMailOutboxByMessageID", reflect.TypeOf((*MockStore)(nil).GetMailOutboxByMessageID), arg0, arg1)
+}
+
+// GetMailTrackingEvents mocks base method.
+func (m *MockStore) GetMailTrackingEvents(arg0 context.Context, arg1 string) ([]data.MailTrackingEvent, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetMailTrackingEvents", arg0, arg1)
+	ret0, _ := ret[0].([]data.MailTrackingEvent)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetMailTrackingEvents indicates an expected call of GetMailTrackingEvents.
+func (mr *MockStoreMockRecorder) GetMailTrackingEvents(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMailTrackingEvents", reflect.TypeOf((*MockStore)(nil).GetMailTrackingEvents), arg0, arg1)
+}
+
+// GetNotificationPreferences mocks base method.
+func (m *MockStore) GetNotificationPreferences(arg0 context.Context, arg1 int32) ([]data.NotificationPreference, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetNotificationPreferences", arg0, arg1)
+	ret0, _ := ret[0].([]data.NotificationPreference)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetNotificationPreferences indicates an expected call of GetNotificationPreferences.
+func (mr *MockStoreMockRecorder) GetNotificationPreferences(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetNotificationPreferences", reflect.TypeOf((*MockStore)(nil).GetNotificationPreferences), arg0, arg1)
+}
+
+// GetOneMailOutbox mocks base method.
+func (m *MockStore) GetOneMailOutbox(arg0 context.Context, arg1 int64) (data.MailOutbox, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetOneMailOutbox", arg0, arg1)
+	ret0, _ := ret[0].(data.MailOutbox)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetOneMailOutbox indicates an expected call of GetOneMailOutbox.
+func (mr *MockStoreMockRecorder) GetOneMailOutbox(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOneMailOutbox", reflect.TypeOf((*MockStore)(nil).GetOneMailOutbox), arg0, arg1)
+}
+
 // GetOnePlan mocks base method.
 func (m *MockStore) GetOnePlan(arg0 context.Context, arg1 int32) (data.Plan, error) {
 	m.ctrl.T.Helper()
@@ -182,6 +317,51 @@ func (mr *MockStoreMockRecorder) GetOneUserPlan(arg0, arg1 interface{}) *gomock.
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOneUserPlan", reflect.TypeOf((*MockStore)(nil).GetOneUserPlan), arg0, arg1)
 }
 
+// GetSegmentUsers mocks base method.
+func (m *MockStore) GetSegmentUsers(arg0 context.Context, arg1 data.GetSegmentUsersParams) ([]data.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetSegmentUsers", arg0, arg1)
+	ret0, _ := ret[0].([]data.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetSegmentUsers indicates an expected call of GetSegmentUsers.
+func (mr *MockStoreMockRecorder) GetSegmentUsers(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSegmentUsers", reflect.TypeOf((*MockStore)(nil).GetSegmentUsers), arg0, arg1)
+}
+
+// GetSuppressedEmails mocks base method.
+func (m *MockStore) GetSuppressedEmails(arg0 context.Context, arg1 []string) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetSuppressedEmails", arg0, arg1)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetSuppressedEmails indicates an expected call of GetSuppressedEmails.
+func (mr *MockStoreMockRecorder) GetSuppressedEmails(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSuppressedEmails", reflect.TypeOf((*MockStore)(nil).GetSuppressedEmails), arg0, arg1)
+}
+
+// GetUnsubscribedEmails mocks base method.
+func (m *MockStore) GetUnsubscribedEmails(arg0 context.Context, arg1 data.GetUnsubscribedEmailsParams) ([]pgtype.Text, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUnsubscribedEmails", arg0, arg1)
+	ret0, _ := ret[0].([]pgtype.Text)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUnsubscribedEmails indicates an expected call of GetUnsubscribedEmails.
+func (mr *MockStoreMockRecorder) GetUnsubscribedEmails(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUnsubscribedEmails", reflect.TypeOf((*MockStore)(nil).GetUnsubscribedEmails), arg0, arg1)
+}
+
 // GetUserByEmail mocks base method.
 func (m *MockStore) GetUserByEmail(arg0 context.Context, arg1 pgtype.Text) (data.User, error) {
 	m.ctrl.T.Helper()
@@ -197,6 +377,49 @@ func (mr *MockStoreMockRecorder) GetUserByEmail(arg0, arg1 interface{}) *gomock.
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserByEmail", reflect.TypeOf((*MockStore)(nil).GetUserByEmail), arg0, arg1)
 }
 
+// InsertMailEvent mocks base method.
+func (m *MockStore) InsertMailEvent(arg0 context.Context, arg1 data.InsertMailEventParams) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "InsertMailEvent", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// InsertMailEvent indicates an expected call of InsertMailEvent.
+func (mr *MockStoreMockRecorder) InsertMailEvent(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InsertMailEvent", reflect.TypeOf((*MockStore)(nil).InsertMailEvent), arg0, arg1)
+}
+
+// InsertMailOutbox mocks base method.
+func (m *MockStore) InsertMailOutbox(arg0 context.Context, arg1 []byte) (data.MailOutbox, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "InsertMailOutbox", arg0, arg1)
+	ret0, _ := ret[0].(data.MailOutbox)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// InsertMailOutbox indicates an expected call of InsertMailOutbox.
+func (mr *MockStoreMockRecorder) InsertMailOutbox(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InsertMailOutbox", reflect.TypeOf((*MockStore)(nil).InsertMailOutbox), arg0, arg1)
+}
+
+// InsertMailTrackingEvent mocks base method.
+func (m *MockStore) InsertMailTrackingEvent(arg0 context.Context, arg1 data.InsertMailTrackingEventParams) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "InsertMailTrackingEvent", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// InsertMailTrackingEvent indicates an expected call of InsertMailTrackingEvent.
+func (mr *MockStoreMockRecorder) InsertMailTrackingEvent(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InsertMailTrackingEvent", reflect.TypeOf((*MockStore)(nil).InsertMailTrackingEvent), arg0, arg1)
+}
+
 // InsertUser mocks base method.
 func (m *MockStore) InsertUser(arg0 context.Context, arg1 data.InsertUserParams) (data.User, error) {
 	m.ctrl.T.Helper()
@@ -227,6 +450,121 @@ func (mr *MockStoreMockRecorder) InsertUserPlan(arg0, arg1 interface{}) *gomock.
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InsertUserPlan", reflect.TypeOf((*MockStore)(nil).InsertUserPlan), arg0, arg1)
 }
 
+// InsertUserTx mocks base method.
+func (m *MockStore) InsertUserTx(arg0 context.Context, arg1 data.InsertUserTxParams) (data.InsertUserTxResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "InsertUserTx", arg0, arg1)
+	ret0, _ := ret[0].(data.InsertUserTxResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// InsertUserTx indicates an expected call of InsertUserTx.
+func (mr *MockStoreMockRecorder) InsertUserTx(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InsertUserTx", reflect.TypeOf((*MockStore)(nil).InsertUserTx), arg0, arg1)
+}
+
+// MarkMailOutboxDead mocks base method.
+func (m *MockStore) MarkMailOutboxDead(arg0 context.Context, arg1 data.MarkMailOutboxDeadParams) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkMailOutboxDead", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MarkMailOutboxDead indicates an expected call of MarkMailOutboxDead.
+func (mr *MockStoreMockRecorder) MarkMailOutboxDead(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkMailOutboxDead", reflect.TypeOf((*MockStore)(nil).MarkMailOutboxDead), arg0, arg1)
+}
+
+// MarkMailOutboxSent mocks base method.
+func (m *MockStore) MarkMailOutboxSent(arg0 context.Context, arg1 int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkMailOutboxSent", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MarkMailOutboxSent indicates an expected call of MarkMailOutboxSent.
+func (mr *MockStoreMockRecorder) MarkMailOutboxSent(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkMailOutboxSent", reflect.TypeOf((*MockStore)(nil).MarkMailOutboxSent), arg0, arg1)
+}
+
+// MarkMailOutboxSkipped mocks base method.
+func (m *MockStore) MarkMailOutboxSkipped(arg0 context.Context, arg1 int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkMailOutboxSkipped", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MarkMailOutboxSkipped indicates an expected call of MarkMailOutboxSkipped.
+func (mr *MockStoreMockRecorder) MarkMailOutboxSkipped(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkMailOutboxSkipped", reflect.TypeOf((*MockStore)(nil).MarkMailOutboxSkipped), arg0, arg1)
+}
+
+// RedriveMailOutbox mocks base method.
+func (m *MockStore) RedriveMailOutbox(arg0 context.Context, arg1 int64) (data.MailOutbox, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RedriveMailOutbox", arg0, arg1)
+	ret0, _ := ret[0].(data.MailOutbox)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RedriveMailOutbox indicates an expected call of RedriveMailOutbox.
+func (mr *MockStoreMockRecorder) RedriveMailOutbox(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RedriveMailOutbox", reflect.TypeOf((*MockStore)(nil).RedriveMailOutbox), arg0, arg1)
+}
+
+// ReleaseMailOutbox mocks base method.
+func (m *MockStore) ReleaseMailOutbox(arg0 context.Context, arg1 int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReleaseMailOutbox", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReleaseMailOutbox indicates an expected call of ReleaseMailOutbox.
+func (mr *MockStoreMockRecorder) ReleaseMailOutbox(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReleaseMailOutbox", reflect.TypeOf((*MockStore)(nil).ReleaseMailOutbox), arg0, arg1)
+}
+
+// RetryMailOutbox mocks base method.
+func (m *MockStore) RetryMailOutbox(arg0 context.Context, arg1 data.RetryMailOutboxParams) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RetryMailOutbox", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RetryMailOutbox indicates an expected call of RetryMailOutbox.
+func (mr *MockStoreMockRecorder) RetryMailOutbox(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RetryMailOutbox", reflect.TypeOf((*MockStore)(nil).RetryMailOutbox), arg0, arg1)
+}
+
+// SearchMailOutbox mocks base method.
+func (m *MockStore) SearchMailOutbox(arg0 context.Context, arg1 data.SearchMailOutboxParams) ([]data.MailOutbox, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SearchMailOutbox", arg0, arg1)
+	ret0, _ := ret[0].([]data.MailOutbox)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SearchMailOutbox indicates an expected call of SearchMailOutbox.
+func (mr *MockStoreMockRecorder) SearchMailOutbox(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SearchMailOutbox", reflect.TypeOf((*MockStore)(nil).SearchMailOutbox), arg0, arg1)
+}
+
 // SubscribeUserToPlan mocks base method.
 func (m *MockStore) SubscribeUserToPlan(arg0 context.Context, arg1 data.SubscribeUserToPlanParams) (data.SubscribeUserToPlanResult, error) {
 	m.ctrl.T.Helper()
@@ -286,3 +624,33 @@ func (mr *MockStoreMockRecorder) UpdateUserPlan(arg0, arg1 interface{}) *gomock.
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateUserPlan", reflect.TypeOf((*MockStore)(nil).UpdateUserPlan), arg0, arg1)
 }
+
+// UpsertMailSuppression mocks base method.
+func (m *MockStore) UpsertMailSuppression(arg0 context.Context, arg1 data.UpsertMailSuppressionParams) (data.MailSuppression, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpsertMailSuppression", arg0, arg1)
+	ret0, _ := ret[0].(data.MailSuppression)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpsertMailSuppression indicates an expected call of UpsertMailSuppression.
+func (mr *MockStoreMockRecorder) UpsertMailSuppression(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpsertMailSuppression", reflect.TypeOf((*MockStore)(nil).UpsertMailSuppression), arg0, arg1)
+}
+
+// UpsertNotificationPreference mocks base method.
+func (m *MockStore) UpsertNotificationPreference(arg0 context.Context, arg1 data.UpsertNotificationPreferenceParams) (data.NotificationPreference, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpsertNotificationPreference", arg0, arg1)
+	ret0, _ := ret[0].(data.NotificationPreference)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpsertNotificationPreference indicates an expected call of UpsertNotificationPreference.
+func (mr *MockStoreMockRecorder) UpsertNotificationPreference(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpsertNotificationPreference", reflect.TypeOf((*MockStore)(nil).UpsertNotificationPreference), arg0, arg1)
+}
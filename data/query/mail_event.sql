@@ -0,0 +1,13 @@
+-- name: InsertMailEvent :exec
+INSERT INTO mail_events (
+  outbox_id,
+  status,
+  detail
+) VALUES (
+  $1, $2, $3
+);
+
+-- name: GetMailEvents :many
+SELECT * FROM mail_events
+WHERE outbox_id = $1
+ORDER BY id;
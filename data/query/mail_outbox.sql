@@ -0,0 +1,103 @@
+-- name: InsertMailOutbox :one
+INSERT INTO mail_outbox (
+  payload
+) VALUES (
+  $1
+)
+RETURNING *;
+
+-- name: ClaimMailOutbox :many
+UPDATE mail_outbox
+SET
+  status = 'processing',
+  locked_until = now() + make_interval(secs => sqlc.arg('lease_seconds')::int),
+  updated_at = now()
+WHERE id IN (
+  SELECT id FROM mail_outbox
+  WHERE ((status = 'pending' AND available_at <= now())
+     OR (status = 'processing' AND locked_until < now()))
+    AND priority = sqlc.arg('priority')::smallint
+  ORDER BY id
+  LIMIT sqlc.arg('batch_size')::int
+  FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: MarkMailOutboxSent :exec
+UPDATE mail_outbox
+SET
+  status = 'sent',
+  attempts = attempts + 1,
+  locked_until = NULL,
+  updated_at = now()
+WHERE id = $1;
+
+-- name: RetryMailOutbox :exec
+UPDATE mail_outbox
+SET
+  status = 'pending',
+  attempts = attempts + 1,
+  last_error = sqlc.arg('last_error'),
+  available_at = now() + make_interval(secs => sqlc.arg('delay_seconds')::int),
+  locked_until = NULL,
+  updated_at = now()
+WHERE id = sqlc.arg('id');
+
+-- name: ReleaseMailOutbox :exec
+UPDATE mail_outbox
+SET
+  status = 'pending',
+  locked_until = NULL,
+  updated_at = now()
+WHERE id = $1 AND status = 'processing';
+
+-- name: MarkMailOutboxDead :exec
+UPDATE mail_outbox
+SET
+  status = 'dead',
+  attempts = attempts + 1,
+  last_error = $2,
+  locked_until = NULL,
+  updated_at = now()
+WHERE id = $1;
+
+-- name: MarkMailOutboxSkipped :exec
+UPDATE mail_outbox
+SET
+  status = 'skipped',
+  locked_until = NULL,
+  updated_at = now()
+WHERE id = $1;
+
+-- name: GetOneMailOutbox :one
+SELECT * FROM mail_outbox
+WHERE id = $1 LIMIT 1;
+
+-- name: GetDeadMailOutbox :many
+SELECT * FROM mail_outbox
+WHERE status = 'dead'
+ORDER by updated_at DESC
+LIMIT $1
+OFFSET $2;
+
+-- name: RedriveMailOutbox :one
+UPDATE mail_outbox
+SET
+  status = 'pending',
+  attempts = 0,
+  available_at = now(),
+  locked_until = NULL,
+  updated_at = now()
+WHERE id = $1 AND status = 'dead'
+RETURNING *;
+
+-- name: GetMailOutboxByMessageID :one
+SELECT * FROM mail_outbox
+WHERE message_id = $1 LIMIT 1;
+
+-- name: SearchMailOutbox :many
+SELECT * FROM mail_outbox
+WHERE payload->>'To' ILIKE '%' || sqlc.arg('recipient')::text || '%' ESCAPE '\'
+ORDER BY id DESC
+LIMIT sqlc.arg('limit')
+OFFSET sqlc.arg('offset');
@@ -0,0 +1,18 @@
+-- name: UpsertMailSuppression :one
+INSERT INTO mail_suppressions (
+  email,
+  reason,
+  detail
+) VALUES (
+  lower(sqlc.arg('email')), sqlc.arg('reason'), sqlc.arg('detail')
+)
+ON CONFLICT (email) DO UPDATE
+SET
+  reason = EXCLUDED.reason,
+  detail = EXCLUDED.detail,
+  updated_at = now()
+RETURNING *;
+
+-- name: GetSuppressedEmails :many
+SELECT email FROM mail_suppressions
+WHERE email = ANY(sqlc.arg('emails')::text[]);
@@ -0,0 +1,13 @@
+-- name: InsertMailTrackingEvent :exec
+INSERT INTO mail_tracking_events (
+  message_id,
+  kind,
+  url
+) VALUES (
+  $1, $2, $3
+);
+
+-- name: GetMailTrackingEvents :many
+SELECT * FROM mail_tracking_events
+WHERE message_id = $1
+ORDER BY id;
@@ -0,0 +1,25 @@
+-- name: UpsertNotificationPreference :one
+INSERT INTO notification_preferences (
+  user_id,
+  category,
+  subscribed
+) VALUES (
+  $1, $2, $3
+)
+ON CONFLICT (user_id, category) DO UPDATE
+SET
+  subscribed = EXCLUDED.subscribed,
+  updated_at = now()
+RETURNING *;
+
+-- name: GetNotificationPreferences :many
+SELECT * FROM notification_preferences
+WHERE user_id = $1
+ORDER BY category;
+
+-- name: GetUnsubscribedEmails :many
+SELECT u.email FROM notification_preferences p
+JOIN users u ON u.id = p.user_id
+WHERE p.category = sqlc.arg('category')
+  AND NOT p.subscribed
+  AND lower(u.email) = ANY(sqlc.arg('emails')::text[]);
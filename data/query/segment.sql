@@ -0,0 +1,24 @@
+-- name: GetSegmentUsers :many
+SELECT * FROM users u
+WHERE u.id > sqlc.arg('after_id')
+  AND u.email IS NOT NULL
+  AND (sqlc.narg('plan_id')::int IS NULL OR EXISTS (
+    SELECT 1 FROM user_plans up
+    WHERE up.user_id = u.id AND up.plan_id = sqlc.narg('plan_id')
+  ))
+  AND (sqlc.narg('user_active')::int IS NULL OR u.user_active = sqlc.narg('user_active'))
+  AND (sqlc.narg('signed_up_after')::timestamp IS NULL OR u.created_at >= sqlc.narg('signed_up_after'))
+  AND (sqlc.narg('signed_up_before')::timestamp IS NULL OR u.created_at < sqlc.narg('signed_up_before'))
+ORDER BY u.id
+LIMIT sqlc.arg('limit');
+
+-- name: CountSegmentUsers :one
+SELECT count(*) FROM users u
+WHERE u.email IS NOT NULL
+  AND (sqlc.narg('plan_id')::int IS NULL OR EXISTS (
+    SELECT 1 FROM user_plans up
+    WHERE up.user_id = u.id AND up.plan_id = sqlc.narg('plan_id')
+  ))
+  AND (sqlc.narg('user_active')::int IS NULL OR u.user_active = sqlc.narg('user_active'))
+  AND (sqlc.narg('signed_up_after')::timestamp IS NULL OR u.created_at >= sqlc.narg('signed_up_after'))
+  AND (sqlc.narg('signed_up_before')::timestamp IS NULL OR u.created_at < sqlc.narg('signed_up_before'));
@@ -1,8 +1,8 @@
 -- name: InsertUser :one
 INSERT INTO users (
-  email, first_name, last_name, password, user_active
+  email, first_name, last_name, password, user_active, locale
 ) VALUES (
-  $1, $2, $3, $4, $5
+  $1, $2, $3, $4, $5, $6
 )
 RETURNING *;
 
@@ -28,6 +28,7 @@ SET
   email = COALESCE(sqlc.narg('email'), email),
   user_active = COALESCE(sqlc.narg('user_active'), user_active),
   password = COALESCE(sqlc.narg('password'), password),
+  locale = COALESCE(sqlc.narg('locale'), locale),
   updated_at = COALESCE(sqlc.narg('updated_at'), updated_at)
 WHERE
   id = sqlc.arg('id')
@@ -0,0 +1,41 @@
+package data
+
+import (
+	"context"
+	"fmt"
+)
+
+type InsertUserTxParams struct {
+	InsertUserParams
+	// Outbox payloads are stored in the mail outbox with the new user
+	Outbox [][]byte
+}
+
+type InsertUserTxResult struct {
+	User User
+}
+
+func (store *SQLStore) InsertUserTx(
+	ctx context.Context,
+	arg InsertUserTxParams) (InsertUserTxResult, error) {
+
+	var result InsertUserTxResult
+
+	err := store.execTx(ctx, func(q *Queries) error {
+		// 1. Create a new user
+		user, err := q.InsertUser(ctx, arg.InsertUserParams)
+		if err != nil {
+			return fmt.Errorf("inserting user: %w", err)
+		}
+
+		// 2. Enqueue emails for the user
+		if _, err := insertOutbox(ctx, q, arg.Outbox); err != nil {
+			return err
+		}
+
+		result.User = user
+		return nil
+	})
+
+	return result, err
+}
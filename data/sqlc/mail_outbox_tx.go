@@ -0,0 +1,48 @@
+package data
+
+import (
+	"context"
+	"fmt"
+)
+
+// MailEventQueued is the first status in the delivery history of every message
+const MailEventQueued = "queued"
+
+type EnqueueMailTxResult struct {
+	Messages []MailOutbox
+}
+
+// EnqueueMailTx store mail payloads in the outbox together with their queued events
+func (store *SQLStore) EnqueueMailTx(ctx context.Context, payloads [][]byte) (EnqueueMailTxResult, error) {
+	var result EnqueueMailTxResult
+
+	err := store.execTx(ctx, func(q *Queries) error {
+		var err error
+		result.Messages, err = insertOutbox(ctx, q, payloads)
+		return err
+	})
+
+	return result, err
+}
+
+// insertOutbox store mail payloads inside the running transaction,
+// so the emails are sent only if the rest of the transaction is committed
+func insertOutbox(ctx context.Context, q *Queries, payloads [][]byte) ([]MailOutbox, error) {
+	messages := make([]MailOutbox, 0, len(payloads))
+	for _, payload := range payloads {
+		msg, err := q.InsertMailOutbox(ctx, payload)
+		if err != nil {
+			return nil, fmt.Errorf("inserting mail to outbox: %w", err)
+		}
+
+		err = q.InsertMailEvent(ctx, InsertMailEventParams{
+			OutboxID: msg.ID,
+			Status:   MailEventQueued,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("inserting mail event: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
@@ -8,6 +8,51 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+type MailEvent struct {
+	ID        int64            `json:"id"`
+	OutboxID  int64            `json:"outbox_id"`
+	Status    string           `json:"status"`
+	Detail    pgtype.Text      `json:"detail"`
+	CreatedAt pgtype.Timestamp `json:"created_at"`
+}
+
+type MailOutbox struct {
+	ID          int64            `json:"id"`
+	Payload     []byte           `json:"payload"`
+	Status      string           `json:"status"`
+	Attempts    int32            `json:"attempts"`
+	LastError   pgtype.Text      `json:"last_error"`
+	AvailableAt pgtype.Timestamp `json:"available_at"`
+	LockedUntil pgtype.Timestamp `json:"locked_until"`
+	CreatedAt   pgtype.Timestamp `json:"created_at"`
+	UpdatedAt   pgtype.Timestamp `json:"updated_at"`
+	MessageID   string           `json:"message_id"`
+	Priority    int16            `json:"priority"`
+}
+
+type MailSuppression struct {
+	Email     string           `json:"email"`
+	Reason    string           `json:"reason"`
+	Detail    pgtype.Text      `json:"detail"`
+	CreatedAt pgtype.Timestamp `json:"created_at"`
+	UpdatedAt pgtype.Timestamp `json:"updated_at"`
+}
+
+type MailTrackingEvent struct {
+	ID        int64            `json:"id"`
+	MessageID string           `json:"message_id"`
+	Kind      string           `json:"kind"`
+	Url       pgtype.Text      `json:"url"`
+	CreatedAt pgtype.Timestamp `json:"created_at"`
+}
+
+type NotificationPreference struct {
+	UserID     int32            `json:"user_id"`
+	Category   string           `json:"category"`
+	Subscribed bool             `json:"subscribed"`
+	UpdatedAt  pgtype.Timestamp `json:"updated_at"`
+}
+
 type Plan struct {
 	ID         int32            `json:"id"`
 	PlanName   pgtype.Text      `json:"plan_name"`
@@ -26,6 +71,7 @@ type User struct {
 	IsAdmin    pgtype.Int4      `json:"is_admin"`
 	CreatedAt  pgtype.Timestamp `json:"created_at"`
 	UpdatedAt  pgtype.Timestamp `json:"updated_at"`
+	Locale     string           `json:"locale"`
 }
 
 type UserPlan struct {
@@ -11,6 +11,8 @@ import (
 )
 
 type Querier interface {
+	ClaimMailOutbox(ctx context.Context, arg ClaimMailOutboxParams) ([]MailOutbox, error)
+	CountSegmentUsers(ctx context.Context, arg CountSegmentUsersParams) (int64, error)
 	DeletePlan(ctx context.Context, id int32) error
 	DeleteUser(ctx context.Context, id int32) error
 	DeleteUserByID(ctx context.Context, id int32) error
@@ -18,15 +20,36 @@ type Querier interface {
 	GetAllPlans(ctx context.Context, arg GetAllPlansParams) ([]Plan, error)
 	GetAllUserPlans(ctx context.Context, arg GetAllUserPlansParams) ([]UserPlan, error)
 	GetAllUsers(ctx context.Context, arg GetAllUsersParams) ([]User, error)
+	GetDeadMailOutbox(ctx context.Context, arg GetDeadMailOutboxParams) ([]MailOutbox, error)
+	GetMailEvents(ctx context.Context, outboxID int64) ([]MailEvent, error)
+	GetMailOutboxByMessageID(ctx context.Context, messageID string) (MailOutbox, error)
+	GetMailTrackingEvents(ctx context.Context, messageID string) ([]MailTrackingEvent, error)
+	GetNotificationPreferences(ctx context.Context, userID int32) ([]NotificationPreference, error)
+	GetOneMailOutbox(ctx context.Context, id int64) (MailOutbox, error)
 	GetOnePlan(ctx context.Context, id int32) (Plan, error)
 	GetOneUser(ctx context.Context, id int32) (User, error)
 	GetOneUserPlan(ctx context.Context, userID pgtype.Int4) (UserPlan, error)
+	GetSegmentUsers(ctx context.Context, arg GetSegmentUsersParams) ([]User, error)
+	GetSuppressedEmails(ctx context.Context, emails []string) ([]string, error)
+	GetUnsubscribedEmails(ctx context.Context, arg GetUnsubscribedEmailsParams) ([]pgtype.Text, error)
 	GetUserByEmail(ctx context.Context, email pgtype.Text) (User, error)
+	InsertMailEvent(ctx context.Context, arg InsertMailEventParams) error
+	InsertMailOutbox(ctx context.Context, payload []byte) (MailOutbox, error)
+	InsertMailTrackingEvent(ctx context.Context, arg InsertMailTrackingEventParams) error
 	InsertUser(ctx context.Context, arg InsertUserParams) (User, error)
 	InsertUserPlan(ctx context.Context, arg InsertUserPlanParams) (UserPlan, error)
+	MarkMailOutboxDead(ctx context.Context, arg MarkMailOutboxDeadParams) error
+	MarkMailOutboxSent(ctx context.Context, id int64) error
+	MarkMailOutboxSkipped(ctx context.Context, id int64) error
+	RedriveMailOutbox(ctx context.Context, id int64) (MailOutbox, error)
+	ReleaseMailOutbox(ctx context.Context, id int64) error
+	RetryMailOutbox(ctx context.Context, arg RetryMailOutboxParams) error
+	SearchMailOutbox(ctx context.Context, arg SearchMailOutboxParams) ([]MailOutbox, error)
 	UpdatePlan(ctx context.Context, arg UpdatePlanParams) (Plan, error)
 	UpdateUser(ctx context.Context, arg UpdateUserParams) (User, error)
 	UpdateUserPlan(ctx context.Context, arg UpdateUserPlanParams) (UserPlan, error)
+	UpsertMailSuppression(ctx context.Context, arg UpsertMailSuppressionParams) (MailSuppression, error)
+	UpsertNotificationPreference(ctx context.Context, arg UpsertNotificationPreferenceParams) (NotificationPreference, error)
 }
 
 var _ Querier = (*Queries)(nil)
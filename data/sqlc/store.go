@@ -9,6 +9,8 @@ import (
 type Store interface {
 	Querier
 	SubscribeUserToPlan(ctx context.Context, arg SubscribeUserToPlanParams) (SubscribeUserToPlanResult, error)
+	InsertUserTx(ctx context.Context, arg InsertUserTxParams) (InsertUserTxResult, error)
+	EnqueueMailTx(ctx context.Context, payloads [][]byte) (EnqueueMailTxResult, error)
 }
 
 type SQLStore struct {
@@ -11,6 +11,8 @@ import (
 type SubscribeUserToPlanParams struct {
 	UserID int32
 	PlanID int32
+	// Outbox payloads are stored in the mail outbox with the subscription
+	Outbox [][]byte
 }
 
 type SubscribeUserToPlanResult struct {
@@ -35,6 +37,11 @@ func (store *SQLStore) SubscribeUserToPlan(
 			return fmt.Errorf("creating new subscription: %w", err)
 		}
 
+		// 3. Enqueue emails for the new subscription
+		if _, err := insertOutbox(ctx, q, arg.Outbox); err != nil {
+			return err
+		}
+
 		result.UserPlan = userPlan
 		return nil
 	})
@@ -32,7 +32,7 @@ func (q *Queries) DeleteUserByID(ctx context.Context, id int32) error {
 }
 
 const getAllUsers = `-- name: GetAllUsers :many
-SELECT id, email, first_name, last_name, password, user_active, is_admin, created_at, updated_at FROM users
+SELECT id, email, first_name, last_name, password, user_active, is_admin, created_at, updated_at, locale FROM users
 ORDER by last_name
 LIMIT $1
 OFFSET $2
@@ -62,6 +62,7 @@ func (q *Queries) GetAllUsers(ctx context.Context, arg GetAllUsersParams) ([]Use
 			&i.IsAdmin,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.Locale,
 		); err != nil {
 			return nil, err
 		}
@@ -74,7 +75,7 @@ func (q *Queries) GetAllUsers(ctx context.Context, arg GetAllUsersParams) ([]Use
 }
 
 const getOneUser = `-- name: GetOneUser :one
-SELECT id, email, first_name, last_name, password, user_active, is_admin, created_at, updated_at FROM users
+SELECT id, email, first_name, last_name, password, user_active, is_admin, created_at, updated_at, locale FROM users
 WHERE id = $1 LIMIT 1
 `
 
@@ -91,12 +92,13 @@ func (q *Queries) GetOneUser(ctx context.Context, id int32) (User, error) {
 		&i.IsAdmin,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.Locale,
 	)
 	return i, err
 }
 
 const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, email, first_name, last_name, password, user_active, is_admin, created_at, updated_at FROM users
+SELECT id, email, first_name, last_name, password, user_active, is_admin, created_at, updated_at, locale FROM users
 WHERE email = $1 LIMIT 1
 `
 
@@ -113,17 +115,18 @@ func (q *Queries) GetUserByEmail(ctx context.Context, email pgtype.Text) (User,
 		&i.IsAdmin,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.Locale,
 	)
 	return i, err
 }
 
 const insertUser = `-- name: InsertUser :one
 INSERT INTO users (
-  email, first_name, last_name, password, user_active
+  email, first_name, last_name, password, user_active, locale
 ) VALUES (
-  $1, $2, $3, $4, $5
+  $1, $2, $3, $4, $5, $6
 )
-RETURNING id, email, first_name, last_name, password, user_active, is_admin, created_at, updated_at
+RETURNING id, email, first_name, last_name, password, user_active, is_admin, created_at, updated_at, locale
 `
 
 type InsertUserParams struct {
@@ -132,6 +135,7 @@ type InsertUserParams struct {
 	LastName   pgtype.Text `json:"last_name"`
 	Password   pgtype.Text `json:"password"`
 	UserActive pgtype.Int4 `json:"user_active"`
+	Locale     string      `json:"locale"`
 }
 
 func (q *Queries) InsertUser(ctx context.Context, arg InsertUserParams) (User, error) {
@@ -141,6 +145,7 @@ func (q *Queries) InsertUser(ctx context.Context, arg InsertUserParams) (User, e
 		arg.LastName,
 		arg.Password,
 		arg.UserActive,
+		arg.Locale,
 	)
 	var i User
 	err := row.Scan(
@@ -153,6 +158,7 @@ func (q *Queries) InsertUser(ctx context.Context, arg InsertUserParams) (User, e
 		&i.IsAdmin,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.Locale,
 	)
 	return i, err
 }
@@ -165,10 +171,11 @@ SET
   email = COALESCE($3, email),
   user_active = COALESCE($4, user_active),
   password = COALESCE($5, password),
-  updated_at = COALESCE($6, updated_at)
+  locale = COALESCE($6, locale),
+  updated_at = COALESCE($7, updated_at)
 WHERE
-  id = $7
-RETURNING id, email, first_name, last_name, password, user_active, is_admin, created_at, updated_at
+  id = $8
+RETURNING id, email, first_name, last_name, password, user_active, is_admin, created_at, updated_at, locale
 `
 
 type UpdateUserParams struct {
@@ -177,6 +184,7 @@ type UpdateUserParams struct {
 	Email      pgtype.Text      `json:"email"`
 	UserActive pgtype.Int4      `json:"user_active"`
 	Password   pgtype.Text      `json:"password"`
+	Locale     pgtype.Text      `json:"locale"`
 	UpdatedAt  pgtype.Timestamp `json:"updated_at"`
 	ID         int32            `json:"id"`
 }
@@ -188,6 +196,7 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, e
 		arg.Email,
 		arg.UserActive,
 		arg.Password,
+		arg.Locale,
 		arg.UpdatedAt,
 		arg.ID,
 	)
@@ -202,6 +211,7 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, e
 		&i.IsAdmin,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.Locale,
 	)
 	return i, err
 }
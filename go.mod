@@ -3,6 +3,7 @@ module github.com/dubass83/go-concurrency-project
 go 1.24
 
 require (
+	github.com/PuerkitoBio/goquery v1.9.2
 	github.com/alexedwards/scs/redisstore v0.0.0-20250212122300-421ef1d8611c
 	github.com/alexedwards/scs/v2 v2.8.0
 	github.com/bwmarrin/go-alone v0.0.0-20190806015146-742bb55d1631
@@ -21,7 +22,6 @@ require (
 )
 
 require (
-	github.com/PuerkitoBio/goquery v1.9.2 // indirect
 	github.com/andybalholm/cascadia v1.3.2 // indirect
 	github.com/davecgh/go-spew v1.1.2-0.20180830191138-d8f796af33cc // indirect
 	github.com/fsnotify/fsnotify v1.7.0 // indirect
@@ -9,28 +9,63 @@ import (
 // Config store all configuration of the application
 // the values read by viper from file or enviroment variables
 type Config struct {
-	Enviroment              string        `mapstructure:"ENVIROMENT"`
-	DBSource                string        `mapstructure:"DB_SOURCE"`
-	DBPoolMaxConns          int32         `mapstructure:"DB_POOL_MAX_CONNS"`
-	DBPoolMinConns          int32         `mapstructure:"DB_POOL_MIN_CONNS"`
-	DBPoolMaxConnLifetime   time.Duration `mapstructure:"DB_POOL_MAX_CONN_LIFETIME"`
-	DBPoolMaxConnIdleTime   time.Duration `mapstructure:"DB_POOL_MAX_CONN_IDLE_TIME"`
-	DBPoolHealthCheckPeriod time.Duration `mapstructure:"DB_POOL_HEALTH_CHECK_PERIOD"`
-	DBPoolConnectTimeout    time.Duration `mapstructure:"DB_POOL_CONNECT_TIMEOUT"`
-	MigrationURL            string        `mapstructure:"MIGRATION_URL"`
-	WebPort                 string        `mapstructure:"WEB_PORT"`
-	RedisURL                string        `mapstructure:"REDIS_URL"`
-	PathToTemplate          string        `mapstructure:"PATH_TO_TEMPLATE"`
-	PathToManual            string        `mapstructure:"PATH_TO_MANUAL"`
-	PathToTmp               string        `mapstructure:"PATH_TO_TMP"`
-	EmailTemplate           string        `mapstructure:"EMAIL_TEMPLATE"`
-	EmailService            string        `mapstructure:"EMAIL_SERVICE"`
-	EmailLogin              string        `mapstructure:"EMAIL_LOGIN"`
-	EmailPassword           string        `mapstructure:"EMAIL_PASSWORD"`
-	EmailEncryption         string        `mapstructure:"EMAIL_ENCRYPTION"`
-	SenderName              string        `mapstructure:"SENDER_NAME"`
-	SenderEmail             string        `mapstructure:"SENDER_EMAIL"`
-	TokenSecret             string        `mapstructure:"TOKEN_SECRET"`
+	Enviroment                 string        `mapstructure:"ENVIROMENT"`
+	DBSource                   string        `mapstructure:"DB_SOURCE"`
+	DBPoolMaxConns             int32         `mapstructure:"DB_POOL_MAX_CONNS"`
+	DBPoolMinConns             int32         `mapstructure:"DB_POOL_MIN_CONNS"`
+	DBPoolMaxConnLifetime      time.Duration `mapstructure:"DB_POOL_MAX_CONN_LIFETIME"`
+	DBPoolMaxConnIdleTime      time.Duration `mapstructure:"DB_POOL_MAX_CONN_IDLE_TIME"`
+	DBPoolHealthCheckPeriod    time.Duration `mapstructure:"DB_POOL_HEALTH_CHECK_PERIOD"`
+	DBPoolConnectTimeout       time.Duration `mapstructure:"DB_POOL_CONNECT_TIMEOUT"`
+	MigrationURL               string        `mapstructure:"MIGRATION_URL"`
+	WebPort                    string        `mapstructure:"WEB_PORT"`
+	PublicBaseURL              string        `mapstructure:"PUBLIC_BASE_URL"`
+	TrustedProxies             string        `mapstructure:"TRUSTED_PROXIES"`
+	RedisURL                   string        `mapstructure:"REDIS_URL"`
+	PathToTemplate             string        `mapstructure:"PATH_TO_TEMPLATE"`
+	PathToManual               string        `mapstructure:"PATH_TO_MANUAL"`
+	PathToTmp                  string        `mapstructure:"PATH_TO_TMP"`
+	EmailTemplate              string        `mapstructure:"EMAIL_TEMPLATE"`
+	EmailSubject               string        `mapstructure:"EMAIL_SUBJECT"`
+	MailTemplatesEmbedded      bool          `mapstructure:"MAIL_TEMPLATES_EMBEDDED"`
+	EmailService               string        `mapstructure:"EMAIL_SERVICE"`
+	MailFileDir                string        `mapstructure:"MAIL_FILE_DIR"`
+	MailRedirectTo             string        `mapstructure:"MAIL_REDIRECT_TO"`
+	MailRedirectAllowedDomains string        `mapstructure:"MAIL_REDIRECT_ALLOWED_DOMAINS"`
+	EmailHost                  string        `mapstructure:"EMAIL_HOST"`
+	EmailPort                  int           `mapstructure:"EMAIL_PORT"`
+	EmailAuth                  string        `mapstructure:"EMAIL_AUTH"`
+	EmailLogin                 string        `mapstructure:"EMAIL_LOGIN"`
+	EmailPassword              string        `mapstructure:"EMAIL_PASSWORD"`
+	EmailEncryption            string        `mapstructure:"EMAIL_ENCRYPTION"`
+	SenderName                 string        `mapstructure:"SENDER_NAME"`
+	SenderEmail                string        `mapstructure:"SENDER_EMAIL"`
+	DKIMPrivateKeyFile         string        `mapstructure:"DKIM_PRIVATE_KEY_FILE"`
+	DKIMSelector               string        `mapstructure:"DKIM_SELECTOR"`
+	DKIMDomain                 string        `mapstructure:"DKIM_DOMAIN"`
+	TokenSecret                string        `mapstructure:"TOKEN_SECRET"`
+	TokenKeys                  string        `mapstructure:"TOKEN_KEYS"`
+	TokenActiveKey             string        `mapstructure:"TOKEN_ACTIVE_KEY"`
+	ActivationTTL              time.Duration `mapstructure:"ACTIVATION_TTL"`
+	PasswordResetTTL           time.Duration `mapstructure:"PASSWORD_RESET_TTL"`
+	MailOutboxPollInterval     time.Duration `mapstructure:"MAIL_OUTBOX_POLL_INTERVAL"`
+	MailOutboxBatchSize        int32         `mapstructure:"MAIL_OUTBOX_BATCH_SIZE"`
+	MailOutboxLease            time.Duration `mapstructure:"MAIL_OUTBOX_LEASE"`
+	MailRetryMaxAttempts       int           `mapstructure:"MAIL_RETRY_MAX_ATTEMPTS"`
+	MailRetryBaseDelay         time.Duration `mapstructure:"MAIL_RETRY_BASE_DELAY"`
+	MailRetryMaxDelay          time.Duration `mapstructure:"MAIL_RETRY_MAX_DELAY"`
+	MailWorkers                int           `mapstructure:"MAIL_WORKERS"`
+	MailBulkWorkers            int           `mapstructure:"MAIL_BULK_WORKERS"`
+	MailQueueSize              int           `mapstructure:"MAIL_QUEUE_SIZE"`
+	MailEnqueueTimeout         time.Duration `mapstructure:"MAIL_ENQUEUE_TIMEOUT"`
+	MailConnIdleTimeout        time.Duration `mapstructure:"MAIL_CONN_IDLE_TIMEOUT"`
+	MailBreakerThreshold       int           `mapstructure:"MAIL_BREAKER_THRESHOLD"`
+	MailBreakerCooldown        time.Duration `mapstructure:"MAIL_BREAKER_COOLDOWN"`
+	MailThrottleFailedLogin    time.Duration `mapstructure:"MAIL_THROTTLE_FAILED_LOGIN"`
+	MailThrottleActivation     time.Duration `mapstructure:"MAIL_THROTTLE_ACTIVATION"`
+	MailThrottlePasswordReset  time.Duration `mapstructure:"MAIL_THROTTLE_PASSWORD_RESET"`
+	MailWebhookSecret          string        `mapstructure:"MAIL_WEBHOOK_SECRET"`
+	MailTracking               bool          `mapstructure:"MAIL_TRACKING"`
 }
 
 // LoadConfig
@@ -53,7 +53,8 @@ func RandomUser(password string) (user data.User) {
 	hash, _ := HashPassword(password)
 
 	return data.User{
-		ID: int32(RandomInt(1, 100)),
+		ID:     int32(RandomInt(1, 100)),
+		Locale: "en",
 		Email: pgtype.Text{
 			String: RandomEmail(),
 			Valid:  true,